@@ -2,4 +2,4 @@ module websocket-server
 
 go 1.23.5
 
-require github.com/leanovate/gopter v0.2.11 // indirect
+require github.com/leanovate/gopter v0.2.11
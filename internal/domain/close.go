@@ -0,0 +1,131 @@
+package domain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"websocket-server/pkg/protocol"
+)
+
+// closeMappings associates domain errors with the close status code and the reason text
+// sent to the peer. Entries are checked in order with errors.Is, so more specific errors
+// come first. Reasons must fit in a control frame alongside the 2-byte code (123 bytes).
+var closeMappings = []struct {
+	err    error
+	code   uint16
+	reason string
+}{
+	{ErrInvalidCloseReason, protocol.StatusInvalidFramePayloadData, "invalid UTF-8 in close reason"},
+	{ErrInvalidFramePayloadData, protocol.StatusInvalidFramePayloadData, "invalid UTF-8 in text message"},
+	{ErrChecksumMismatch, protocol.StatusInvalidFramePayloadData, "frame checksum mismatch"},
+	{ErrPayloadTooLarge, protocol.StatusMessageTooBig, "message too big"},
+	{ErrMessageTooLarge, protocol.StatusMessageTooBig, "message too big"},
+	{ErrUnmaskedClientFrame, protocol.StatusProtocolError, "client frame not masked"},
+	{ErrMaskedServerFrame, protocol.StatusProtocolError, "server frame must not be masked"},
+	{ErrReservedBitsSet, protocol.StatusProtocolError, "reserved bits set without negotiated extension"},
+	{ErrInvalidOpcode, protocol.StatusProtocolError, "invalid opcode"},
+	{ErrInvalidFrameStructure, protocol.StatusProtocolError, "malformed frame"},
+	{ErrProtocolViolation, protocol.StatusProtocolError, "protocol violation"},
+	{ErrPolicyViolation, protocol.StatusPolicyViolation, "policy violation"},
+	{ErrRateLimited, protocol.StatusPolicyViolation, "rate limit exceeded"},
+	{ErrDraining, protocol.StatusGoingAway, "server is draining"},
+}
+
+// CloseCodeForError returns the close status code that should be sent when a connection
+// is terminated because of err. A nil error maps to a normal closure.
+func CloseCodeForError(err error) uint16 {
+	if err == nil {
+		return protocol.StatusNormalClosure
+	}
+	for _, m := range closeMappings {
+		if errors.Is(err, m.err) {
+			return m.code
+		}
+	}
+	return protocol.StatusInternalServerError
+}
+
+// CloseReasonForError returns the standardized, human-readable close reason for err
+func CloseReasonForError(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, m := range closeMappings {
+		if errors.Is(err, m.err) {
+			return m.reason
+		}
+	}
+	return "internal error"
+}
+
+// maxCloseReasonSize is the room left for the reason in a close frame after the status code
+const maxCloseReasonSize = protocol.MaxControlFramePayloadSize - 2
+
+// CloseFrameForError builds the close frame sent when a connection fails with err
+func CloseFrameForError(err error) *Frame {
+	// The mapped reasons are all valid UTF-8
+	return closeFrame(CloseCodeForError(err), CloseReasonForError(err))
+}
+
+// NewCloseFrame builds a close frame carrying code and reason. A reason that is not valid
+// UTF-8 is rejected with ErrInvalidCloseReason, since a peer fails the connection on it,
+// and one longer than 123 bytes is truncated on a rune boundary so the frame always fits
+// the 125-byte control frame limit.
+func NewCloseFrame(code uint16, reason string) (*Frame, error) {
+	if !utf8.ValidString(reason) {
+		return nil, ErrInvalidCloseReason
+	}
+	return closeFrame(code, reason), nil
+}
+
+// closeFrame builds a close frame like NewCloseFrame, for a reason known to be valid UTF-8
+func closeFrame(code uint16, reason string) *Frame {
+	if len(reason) > maxCloseReasonSize {
+		cut := maxCloseReasonSize
+		for cut > 0 && !utf8.RuneStart(reason[cut]) {
+			cut--
+		}
+		reason = reason[:cut]
+	}
+	return NewFrame(OpcodeClose, encodeClosePayload(code, reason))
+}
+
+// ParseClosePayload decodes a close frame payload into its status code and reason.
+// An empty payload carries no status and yields StatusNoStatusReceived (1005); a
+// one-byte payload is malformed, a code rejected by protocol.IsValidCloseCode is a
+// protocol violation and a reason that is not valid UTF-8 is rejected.
+func ParseClosePayload(payload []byte) (code uint16, reason string, err error) {
+	switch len(payload) {
+	case 0:
+		return protocol.StatusNoStatusReceived, "", nil
+	case 1:
+		return 0, "", fmt.Errorf("%w: 1-byte close payload", ErrInvalidFrameStructure)
+	}
+
+	code = binary.BigEndian.Uint16(payload)
+	if !protocol.IsValidCloseCode(code) {
+		return 0, "", fmt.Errorf("%w: close code %d is not allowed on the wire", ErrProtocolViolation, code)
+	}
+	if !utf8.Valid(payload[2:]) {
+		return 0, "", ErrInvalidCloseReason
+	}
+	return code, string(payload[2:]), nil
+}
+
+// CloseCode returns the status code carried by a close frame, see ParseClosePayload
+func (f *Frame) CloseCode() (uint16, error) {
+	if f.Opcode != OpcodeClose {
+		return 0, fmt.Errorf("%w: %s frame carries no close code", ErrInvalidOpcode, f.Opcode)
+	}
+	code, _, err := ParseClosePayload(f.Payload)
+	return code, err
+}
+
+// encodeClosePayload encodes a close frame payload: a big-endian status code followed by the reason
+func encodeClosePayload(code uint16, reason string) []byte {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	return append(payload, reason...)
+}
@@ -0,0 +1,195 @@
+package domain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"websocket-server/pkg/protocol"
+)
+
+func TestCloseCodeForError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected uint16
+	}{
+		{"nil error", nil, protocol.StatusNormalClosure},
+		{"payload too large", ErrPayloadTooLarge, protocol.StatusMessageTooBig},
+		{"unmasked client frame", ErrUnmaskedClientFrame, protocol.StatusProtocolError},
+		{"reserved bits set", ErrReservedBitsSet, protocol.StatusProtocolError},
+		{"invalid opcode with value", &FrameError{Opcode: 0x5, Err: ErrInvalidOpcode}, protocol.StatusProtocolError},
+		{"invalid payload data", ErrInvalidFramePayloadData, protocol.StatusInvalidFramePayloadData},
+		{"checksum mismatch", ErrChecksumMismatch, protocol.StatusInvalidFramePayloadData},
+		{"invalid close reason", ErrInvalidCloseReason, protocol.StatusInvalidFramePayloadData},
+		{"wrapped protocol violation", fmt.Errorf("bad sequence: %w", ErrProtocolViolation), protocol.StatusProtocolError},
+		{"policy violation", ErrPolicyViolation, protocol.StatusPolicyViolation},
+		{"unknown error", fmt.Errorf("boom"), protocol.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CloseCodeForError(tt.err); got != tt.expected {
+				t.Errorf("CloseCodeForError() = %d, want %d", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCloseFrameForError(t *testing.T) {
+	frame := CloseFrameForError(fmt.Errorf("text message: %w", ErrInvalidFramePayloadData))
+
+	if frame.Opcode != OpcodeClose {
+		t.Fatalf("expected Close opcode, got %v", frame.Opcode)
+	}
+	if err := frame.Validate(); err != nil {
+		t.Fatalf("expected valid close frame, got %v", err)
+	}
+
+	code := binary.BigEndian.Uint16(frame.Payload[:2])
+	reason := string(frame.Payload[2:])
+	if code != protocol.StatusInvalidFramePayloadData {
+		t.Errorf("expected code %d, got %d", protocol.StatusInvalidFramePayloadData, code)
+	}
+	if reason != "invalid UTF-8 in text message" {
+		t.Errorf("expected UTF-8 reason, got %q", reason)
+	}
+}
+
+func TestCloseReasonsFitControlFrame(t *testing.T) {
+	for _, m := range closeMappings {
+		if len(m.reason) > protocol.MaxControlFramePayloadSize-2 {
+			t.Errorf("reason %q for %v is %d bytes, exceeding the close reason limit", m.reason, m.err, len(m.reason))
+		}
+		if !utf8.ValidString(m.reason) {
+			t.Errorf("reason %q for %v is not valid UTF-8", m.reason, m.err)
+		}
+		if CloseReasonForError(m.err) != m.reason {
+			t.Errorf("CloseReasonForError(%v) = %q, want %q", m.err, CloseReasonForError(m.err), m.reason)
+		}
+	}
+
+	if reason := CloseReasonForError(nil); reason != "" {
+		t.Errorf("expected empty reason for nil error, got %q", reason)
+	}
+	if reason := CloseReasonForError(fmt.Errorf("boom")); reason != "internal error" {
+		t.Errorf("expected internal error reason, got %q", reason)
+	}
+}
+
+func TestParseClosePayload(t *testing.T) {
+	tests := []struct {
+		name           string
+		payload        []byte
+		expectedCode   uint16
+		expectedReason string
+		wantErr        error
+	}{
+		{"empty payload", nil, protocol.StatusNoStatusReceived, "", nil},
+		{"code only", []byte{0x03, 0xE8}, protocol.StatusNormalClosure, "", nil},
+		{"code and reason", append([]byte{0x03, 0xE9}, "bye"...), protocol.StatusGoingAway, "bye", nil},
+		{"single byte", []byte{0x03}, 0, "", ErrInvalidFrameStructure},
+		{"invalid UTF-8 reason", []byte{0x03, 0xE8, 0xFF, 0xFE}, 0, "", ErrInvalidCloseReason},
+		{"application code", []byte{0x0F, 0xA0}, 4000, "", nil},
+		{"local-only code", []byte{0x03, 0xEE}, 0, "", ErrProtocolViolation},
+		{"code below 1000", []byte{0x03, 0xE7}, 0, "", ErrProtocolViolation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, reason, err := ParseClosePayload(tt.payload)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseClosePayload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if code != tt.expectedCode || reason != tt.expectedReason {
+				t.Errorf("ParseClosePayload() = (%d, %q), want (%d, %q)", code, reason, tt.expectedCode, tt.expectedReason)
+			}
+		})
+	}
+}
+
+func TestFrameCloseCode(t *testing.T) {
+	code, err := CloseFrameForError(ErrPayloadTooLarge).CloseCode()
+	if err != nil || code != protocol.StatusMessageTooBig {
+		t.Errorf("CloseCode() = (%d, %v), want %d", code, err, protocol.StatusMessageTooBig)
+	}
+
+	if _, err := NewFrame(OpcodeText, []byte("hi")).CloseCode(); !errors.Is(err, ErrInvalidOpcode) {
+		t.Errorf("expected ErrInvalidOpcode for a text frame, got %v", err)
+	}
+}
+
+func TestNewCloseFrame(t *testing.T) {
+	tests := []struct {
+		name           string
+		reason         string
+		expectedReason string
+		wantErr        error
+	}{
+		{"short reason", "policy", "policy", nil},
+		{"empty reason", "", "", nil},
+		{"ascii truncated", strings.Repeat("a", 200), strings.Repeat("a", 123), nil},
+		// 41 three-byte runes take 123 bytes; a 42nd would split across the limit
+		{"multibyte truncated on rune boundary", strings.Repeat("€", 50), strings.Repeat("€", 41), nil},
+		{"invalid UTF-8", "bad\xffbyte", "", ErrInvalidCloseReason},
+		{"invalid UTF-8 past the truncation point", strings.Repeat("a", 200) + "\xff", "", ErrInvalidCloseReason},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := NewCloseFrame(protocol.StatusPolicyViolation, tt.reason)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewCloseFrame() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if frame != nil {
+					t.Errorf("expected no frame for a rejected reason, got %v", frame)
+				}
+				return
+			}
+			if err := frame.Validate(); err != nil {
+				t.Fatalf("expected a valid control frame, got %v", err)
+			}
+
+			code, reason, err := ParseClosePayload(frame.Payload)
+			if err != nil {
+				t.Fatalf("ParseClosePayload failed: %v", err)
+			}
+			if code != protocol.StatusPolicyViolation {
+				t.Errorf("expected code %d, got %d", protocol.StatusPolicyViolation, code)
+			}
+			if reason != tt.expectedReason {
+				t.Errorf("expected reason %q, got %q", tt.expectedReason, reason)
+			}
+		})
+	}
+}
+
+func TestCloseError(t *testing.T) {
+	var err error = &CloseError{Code: protocol.StatusGoingAway, Reason: "restarting"}
+	wrapped := fmt.Errorf("read loop: %w", err)
+
+	if !errors.Is(wrapped, ErrConnectionClosed) {
+		t.Error("expected CloseError to match ErrConnectionClosed")
+	}
+	if !IsCloseError(wrapped, protocol.StatusGoingAway) {
+		t.Error("expected IsCloseError to match the going away code")
+	}
+	if IsCloseError(wrapped, protocol.StatusPolicyViolation) {
+		t.Error("expected IsCloseError not to match a different code")
+	}
+	if IsCloseError(ErrConnectionClosed, protocol.StatusGoingAway) {
+		t.Error("expected IsCloseError not to match a plain ErrConnectionClosed")
+	}
+
+	var closeErr *CloseError
+	if !errors.As(wrapped, &closeErr) || closeErr.Reason != "restarting" {
+		t.Errorf("expected errors.As to recover the reason, got %v", closeErr)
+	}
+	if msg := err.Error(); msg != "connection closed by peer: 1001 (GoingAway): restarting" {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
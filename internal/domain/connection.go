@@ -2,7 +2,11 @@ package domain
 
 import (
 	"fmt"
+	"net/http"
+	"sync"
 	"time"
+
+	"websocket-server/pkg/protocol"
 )
 
 // ConnectionState represents the state of a WebSocket connection
@@ -35,28 +39,86 @@ func (s ConnectionState) String() string {
 	}
 }
 
+// CloseInitiator identifies which side started the closing handshake
+type CloseInitiator int
+
+const (
+	// CloseInitiatorNone indicates no closing handshake has started
+	CloseInitiatorNone CloseInitiator = iota
+	// CloseInitiatorLocal indicates this endpoint sent the first close frame
+	CloseInitiatorLocal
+	// CloseInitiatorPeer indicates the peer sent the first close frame
+	CloseInitiatorPeer
+)
+
+// String returns the string representation of the close initiator
+func (i CloseInitiator) String() string {
+	switch i {
+	case CloseInitiatorNone:
+		return "None"
+	case CloseInitiatorLocal:
+		return "Local"
+	case CloseInitiatorPeer:
+		return "Peer"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(i))
+	}
+}
+
+// MessageSender delivers outbound messages on behalf of a connection
+type MessageSender interface {
+	SendMessage(msg *Message) error
+}
+
+// flusher is implemented by senders that buffer outbound data
+type flusher interface {
+	Flush() error
+}
+
+// closeSender is implemented by senders that can queue a close frame behind pending messages
+type closeSender interface {
+	SendClose(frame *Frame) error
+}
+
 // Connection represents a WebSocket connection
 type Connection struct {
 	ID           string                 // Unique connection identifier
 	RemoteAddr   string                 // Remote address
-	State        ConnectionState        // Current connection state
+	State        ConnectionState        // Current connection state; read it through CurrentState when shared
+	CreatedAt    time.Time              // When NewConnection created the connection
 	LastActivity time.Time              // Last activity timestamp
-	Metadata     map[string]interface{} // Connection metadata
+	Metadata     map[string]interface{} // Connection metadata; use SetMetadata and Get for concurrent access
+	Headers      http.Header            // Upgrade request headers kept for the connection's lifetime
+
+	sender MessageSender // Outbound transport, nil until attached
+
+	mu       sync.RWMutex   // Guards State, LastActivity, Metadata, draining and closedBy
+	draining bool           // Set by Drain; new sends are rejected and inbound messages dropped
+	closedBy CloseInitiator // Side that started the closing handshake
 }
 
 // NewConnection creates a new connection with the given ID and remote address
 func NewConnection(id, remoteAddr string) *Connection {
+	now := time.Now()
 	return &Connection{
 		ID:           id,
 		RemoteAddr:   remoteAddr,
 		State:        StateConnecting,
-		LastActivity: time.Now(),
+		CreatedAt:    now,
+		LastActivity: now,
 		Metadata:     make(map[string]interface{}),
 	}
 }
 
 // CanTransitionTo checks if the connection can transition to the given state
 func (c *Connection) CanTransitionTo(newState ConnectionState) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.canTransitionToLocked(newState)
+}
+
+// canTransitionToLocked implements CanTransitionTo; the caller must hold mu
+func (c *Connection) canTransitionToLocked(newState ConnectionState) bool {
 	switch c.State {
 	case StateConnecting:
 		return newState == StateOpen || newState == StateClosed
@@ -73,7 +135,9 @@ func (c *Connection) CanTransitionTo(newState ConnectionState) bool {
 
 // TransitionTo transitions the connection to the given state
 func (c *Connection) TransitionTo(newState ConnectionState) error {
-	if !c.CanTransitionTo(newState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.canTransitionToLocked(newState) {
 		return fmt.Errorf("%w: cannot transition from %s to %s", ErrInvalidState, c.State, newState)
 	}
 	c.State = newState
@@ -82,20 +146,211 @@ func (c *Connection) TransitionTo(newState ConnectionState) error {
 
 // UpdateActivity updates the last activity timestamp
 func (c *Connection) UpdateActivity() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.LastActivity = time.Now()
 }
 
+// Age returns how long ago the connection was created
+func (c *Connection) Age() time.Duration {
+	return time.Since(c.CreatedAt)
+}
+
+// IdleDuration returns how long ago the last activity was recorded; safe for concurrent use
+func (c *Connection) IdleDuration() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return time.Since(c.LastActivity)
+}
+
+// SetMetadata stores a metadata value under key; safe for concurrent use
+func (c *Connection) SetMetadata(key string, v interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Metadata == nil {
+		c.Metadata = make(map[string]interface{})
+	}
+	c.Metadata[key] = v
+}
+
+// Get returns the metadata value stored under key; safe for concurrent use
+func (c *Connection) Get(key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.Metadata[key]
+	return v, ok
+}
+
+// GetString returns the metadata value stored under key if it is a string
+func (c *Connection) GetString(key string) (string, bool) {
+	v, _ := c.Get(key)
+	s, ok := v.(string)
+	return s, ok
+}
+
+// GetInt returns the metadata value stored under key if it is an int
+func (c *Connection) GetInt(key string) (int, bool) {
+	v, _ := c.Get(key)
+	i, ok := v.(int)
+	return i, ok
+}
+
+// CurrentState returns the connection state; safe for concurrent use
+func (c *Connection) CurrentState() ConnectionState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.State
+}
+
 // IsOpen returns true if the connection is open
 func (c *Connection) IsOpen() bool {
-	return c.State == StateOpen
+	return c.CurrentState() == StateOpen
 }
 
 // IsClosed returns true if the connection is closed
 func (c *Connection) IsClosed() bool {
-	return c.State == StateClosed
+	return c.CurrentState() == StateClosed
 }
 
 // IsClosing returns true if the connection is closing
 func (c *Connection) IsClosing() bool {
-	return c.State == StateClosing
+	return c.CurrentState() == StateClosing
+}
+
+// SetSender attaches the transport used to deliver outbound messages
+func (c *Connection) SetSender(sender MessageSender) {
+	c.sender = sender
+}
+
+// Sender returns the attached transport, or nil if none is attached
+func (c *Connection) Sender() MessageSender {
+	return c.sender
+}
+
+// Send delivers a message to the peer through the attached sender
+func (c *Connection) Send(msg *Message) error {
+	if c.IsDraining() {
+		return ErrDraining
+	}
+	if !c.IsOpen() {
+		return ErrConnectionClosed
+	}
+	if c.sender == nil {
+		return fmt.Errorf("%w: no sender attached to connection %s", ErrInvalidState, c.ID)
+	}
+	return c.sender.SendMessage(msg)
+}
+
+// Flush blocks until messages previously handed to the sender have been written to the
+// transport. It is a no-op when the sender does not buffer.
+func (c *Connection) Flush() error {
+	if f, ok := c.sender.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Drain stops the connection from taking on new work ahead of a graceful close: new
+// sends fail with ErrDraining and inbound messages are discarded by read loops that
+// check IsDraining, such as Conn.ReadMessage. Drain blocks until messages already
+// handed to the sender have been written, then moves to Closing and queues a 1001
+// (going away) close frame if the sender supports it.
+func (c *Connection) Drain() error {
+	if !c.IsOpen() {
+		return ErrConnectionClosed
+	}
+
+	c.mu.Lock()
+	c.draining = true
+	c.mu.Unlock()
+
+	// Let in-flight writes complete before closing
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	if err := c.StartClose(CloseCodeForError(ErrDraining), CloseReasonForError(ErrDraining)); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// IsDraining returns true once Drain has been called
+func (c *Connection) IsDraining() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.draining
+}
+
+// StartClose starts the closing handshake from this side: the connection moves to
+// Closing, so further sends fail with ErrConnectionClosed, and a close frame carrying
+// code and reason is queued behind pending messages if the sender supports it. The
+// handshake completes when OnCloseReceived sees the peer's echo. A reason that is not
+// valid UTF-8 is rejected with ErrInvalidFramePayloadData, leaving the connection open.
+func (c *Connection) StartClose(code uint16, reason string) error {
+	if !c.IsOpen() {
+		return ErrConnectionClosed
+	}
+	frame, err := NewCloseFrame(code, reason)
+	if err != nil {
+		return err
+	}
+	if err := c.TransitionTo(StateClosing); err != nil {
+		return err
+	}
+	c.setClosedBy(CloseInitiatorLocal)
+	return c.sendClose(frame)
+}
+
+// OnCloseReceived advances the closing handshake when a close frame carrying code
+// arrives. If this side started the handshake the frame is the peer's echo and the
+// connection is closed. Otherwise the peer started it: its code is echoed back behind
+// pending messages and the connection moves through Closing to Closed.
+func (c *Connection) OnCloseReceived(code uint16) error {
+	switch c.CurrentState() {
+	case StateClosing:
+		return c.TransitionTo(StateClosed)
+	case StateOpen:
+		if err := c.TransitionTo(StateClosing); err != nil {
+			return err
+		}
+		c.setClosedBy(CloseInitiatorPeer)
+
+		// A close without a status code is echoed without one
+		echo := NewFrame(OpcodeClose, nil)
+		if code != protocol.StatusNoStatusReceived {
+			echo = closeFrame(code, "")
+		}
+		if err := c.sendClose(echo); err != nil {
+			return err
+		}
+		if err := c.Flush(); err != nil {
+			return err
+		}
+		return c.TransitionTo(StateClosed)
+	default:
+		return ErrConnectionClosed
+	}
+}
+
+// ClosedBy returns the side that started the closing handshake, letting callers tell a
+// clean shutdown from a connection that was dropped without one
+func (c *Connection) ClosedBy() CloseInitiator {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.closedBy
+}
+
+// setClosedBy records the side that started the closing handshake
+func (c *Connection) setClosedBy(initiator CloseInitiator) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closedBy = initiator
+}
+
+// sendClose queues a close frame if the sender supports it
+func (c *Connection) sendClose(frame *Frame) error {
+	if cs, ok := c.sender.(closeSender); ok {
+		return cs.SendClose(frame)
+	}
+	return nil
 }
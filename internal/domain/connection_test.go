@@ -1,8 +1,14 @@
 package domain
 
 import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"websocket-server/pkg/protocol"
 )
 
 func TestNewConnection(t *testing.T) {
@@ -141,6 +147,116 @@ func TestConnectionUpdateActivity(t *testing.T) {
 	}
 }
 
+func TestConnectionAgeAndIdleDuration(t *testing.T) {
+	conn := NewConnection("test", "127.0.0.1:8080")
+	if !conn.CreatedAt.Equal(conn.LastActivity) {
+		t.Errorf("expected CreatedAt %v to match the initial LastActivity %v", conn.CreatedAt, conn.LastActivity)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	conn.UpdateActivity()
+
+	if age := conn.Age(); age < 20*time.Millisecond {
+		t.Errorf("expected Age of at least 20ms, got %v", age)
+	}
+	if idle := conn.IdleDuration(); idle >= conn.Age() {
+		t.Errorf("expected IdleDuration %v below Age %v after activity", idle, conn.Age())
+	}
+}
+
+func TestConnectionConcurrentTransitions(t *testing.T) {
+	conn := NewConnection("test", "127.0.0.1:8080")
+	_ = conn.TransitionTo(StateOpen)
+
+	// Readers poll the state while several goroutines race to close the connection
+	var wg sync.WaitGroup
+	var closings atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = conn.IsOpen() || conn.IsClosing() || conn.IsClosed()
+				_ = conn.CanTransitionTo(StateClosed)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if conn.TransitionTo(StateClosing) == nil {
+				closings.Add(1)
+			}
+			_ = conn.TransitionTo(StateClosed)
+		}()
+	}
+	wg.Wait()
+
+	if closings.Load() != 1 {
+		t.Errorf("expected exactly one transition to Closing, got %d", closings.Load())
+	}
+	if state := conn.CurrentState(); state != StateClosed {
+		t.Errorf("expected Closed, got %s", state)
+	}
+}
+
+func TestConnectionMetadata(t *testing.T) {
+	conn := NewConnection("test", "127.0.0.1:8080")
+	conn.SetMetadata("user", "u-42")
+	conn.SetMetadata("retries", 3)
+
+	tests := []struct {
+		name       string
+		key        string
+		wantString string
+		wantInt    int
+		isString   bool
+		isInt      bool
+	}{
+		{"string value", "user", "u-42", 0, true, false},
+		{"int value", "retries", "", 3, false, true},
+		{"missing key", "missing", "", 0, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if s, ok := conn.GetString(tt.key); s != tt.wantString || ok != tt.isString {
+				t.Errorf("GetString() = (%q, %v), want (%q, %v)", s, ok, tt.wantString, tt.isString)
+			}
+			if i, ok := conn.GetInt(tt.key); i != tt.wantInt || ok != tt.isInt {
+				t.Errorf("GetInt() = (%d, %v), want (%d, %v)", i, ok, tt.wantInt, tt.isInt)
+			}
+			if _, ok := conn.Get(tt.key); ok != (tt.isString || tt.isInt) {
+				t.Errorf("Get() ok = %v", ok)
+			}
+		})
+	}
+}
+
+func TestConnectionMetadataConcurrentAccess(t *testing.T) {
+	conn := NewConnection("test", "127.0.0.1:8080")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				conn.SetMetadata("counter", i*100+j)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				conn.GetInt("counter")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, ok := conn.GetInt("counter"); !ok {
+		t.Error("expected counter to be set")
+	}
+}
+
 func TestConnectionIsOpen(t *testing.T) {
 	tests := []struct {
 		state    ConnectionState
@@ -242,3 +358,154 @@ func TestConnectionStateTransitions(t *testing.T) {
 		t.Error("expected error when transitioning from Closed state")
 	}
 }
+
+// stubSender records the messages and close frames passed to it
+type stubSender struct {
+	sent   []*Message
+	closes []*Frame
+}
+
+func (s *stubSender) SendMessage(msg *Message) error {
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func (s *stubSender) SendClose(frame *Frame) error {
+	s.closes = append(s.closes, frame)
+	return nil
+}
+
+func TestConnectionSend(t *testing.T) {
+	conn := NewConnection("test", "127.0.0.1:8080")
+	msg := NewTextMessage([]byte("hi"))
+
+	// Not open yet
+	if err := conn.Send(msg); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("expected ErrConnectionClosed before open, got %v", err)
+	}
+
+	// Open without a sender
+	_ = conn.TransitionTo(StateOpen)
+	if err := conn.Send(msg); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected ErrInvalidState without sender, got %v", err)
+	}
+
+	sender := &stubSender{}
+	conn.SetSender(sender)
+	if err := conn.Send(msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sender.sent) != 1 || sender.sent[0] != msg {
+		t.Errorf("expected message to reach sender, got %v", sender.sent)
+	}
+}
+
+func TestConnectionDrain(t *testing.T) {
+	conn := NewConnection("test", "127.0.0.1:8080")
+	if err := conn.Drain(); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("expected ErrConnectionClosed draining a connection that never opened, got %v", err)
+	}
+
+	_ = conn.TransitionTo(StateOpen)
+	sender := &stubSender{}
+	conn.SetSender(sender)
+
+	if err := conn.Drain(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !conn.IsDraining() || !conn.IsClosing() {
+		t.Errorf("expected draining connection in Closing state, got draining=%v state=%s", conn.IsDraining(), conn.State)
+	}
+	if err := conn.Send(NewTextMessage([]byte("late"))); !errors.Is(err, ErrDraining) {
+		t.Errorf("expected ErrDraining, got %v", err)
+	}
+	if len(sender.sent) != 0 {
+		t.Errorf("expected no messages after drain, got %d", len(sender.sent))
+	}
+	if len(sender.closes) != 1 {
+		t.Fatalf("expected one close frame, got %d", len(sender.closes))
+	}
+	if code, _ := sender.closes[0].CloseCode(); code != protocol.StatusGoingAway {
+		t.Errorf("expected close code %d, got %d", protocol.StatusGoingAway, code)
+	}
+}
+
+func TestConnectionStartClose(t *testing.T) {
+	conn := NewConnection("test", "127.0.0.1:8080")
+	if err := conn.StartClose(protocol.StatusNormalClosure, ""); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("expected ErrConnectionClosed closing a connection that never opened, got %v", err)
+	}
+
+	_ = conn.TransitionTo(StateOpen)
+	sender := &stubSender{}
+	conn.SetSender(sender)
+
+	if err := conn.StartClose(protocol.StatusNormalClosure, "bad\xffreason"); !errors.Is(err, ErrInvalidFramePayloadData) {
+		t.Errorf("expected ErrInvalidFramePayloadData for an invalid UTF-8 reason, got %v", err)
+	}
+	if !conn.IsOpen() || len(sender.closes) != 0 {
+		t.Fatalf("expected the connection left open with nothing sent, got %s with %d close frames", conn.State, len(sender.closes))
+	}
+
+	if err := conn.StartClose(protocol.StatusNormalClosure, "bye"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !conn.IsClosing() || conn.ClosedBy() != CloseInitiatorLocal {
+		t.Errorf("expected Closing state started locally, got %s by %s", conn.State, conn.ClosedBy())
+	}
+	if len(sender.closes) != 1 {
+		t.Fatalf("expected one close frame, got %d", len(sender.closes))
+	}
+	if code, reason, _ := ParseClosePayload(sender.closes[0].Payload); code != protocol.StatusNormalClosure || reason != "bye" {
+		t.Errorf("expected close 1000 'bye', got %d %q", code, reason)
+	}
+
+	if err := conn.Send(NewTextMessage([]byte("late"))); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("expected ErrConnectionClosed sending after StartClose, got %v", err)
+	}
+	if err := conn.StartClose(protocol.StatusNormalClosure, ""); !errors.Is(err, ErrConnectionClosed) {
+		t.Errorf("expected ErrConnectionClosed starting a second close, got %v", err)
+	}
+
+	// The peer's echo completes the handshake without another close frame
+	if err := conn.OnCloseReceived(protocol.StatusNormalClosure); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !conn.IsClosed() || len(sender.closes) != 1 {
+		t.Errorf("expected Closed with no echo sent, got %s with %d close frames", conn.State, len(sender.closes))
+	}
+}
+
+func TestConnectionOnCloseReceived(t *testing.T) {
+	tests := []struct {
+		name        string
+		code        uint16
+		wantPayload []byte
+	}{
+		{"status code echoed", protocol.StatusGoingAway, []byte{0x03, 0xE9}},
+		{"no status echoed empty", protocol.StatusNoStatusReceived, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := NewConnection("test", "127.0.0.1:8080")
+			_ = conn.TransitionTo(StateOpen)
+			sender := &stubSender{}
+			conn.SetSender(sender)
+
+			if err := conn.OnCloseReceived(tt.code); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !conn.IsClosed() || conn.ClosedBy() != CloseInitiatorPeer {
+				t.Errorf("expected Closed state started by peer, got %s by %s", conn.State, conn.ClosedBy())
+			}
+			if len(sender.closes) != 1 || !bytes.Equal(sender.closes[0].Payload, tt.wantPayload) {
+				t.Fatalf("expected one echo with payload %x, got %v", tt.wantPayload, sender.closes)
+			}
+
+			if err := conn.OnCloseReceived(tt.code); !errors.Is(err, ErrConnectionClosed) {
+				t.Errorf("expected ErrConnectionClosed after the handshake, got %v", err)
+			}
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package domain
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"websocket-server/pkg/protocol"
+)
 
 // Domain errors
 var (
@@ -12,17 +17,88 @@ var (
 	ErrUnmaskedClientFrame   = errors.New("client frame must be masked")
 	ErrMaskedServerFrame     = errors.New("server frame must not be masked")
 
+	// Payload errors
+	ErrInvalidFramePayloadData = errors.New("invalid frame payload data")
+	ErrChecksumMismatch        = errors.New("frame checksum mismatch")
+	ErrInvalidCloseReason      = fmt.Errorf("%w: close reason is not valid UTF-8", ErrInvalidFramePayloadData)
+
 	// Connection errors
 	ErrConnectionClosed   = errors.New("connection is closed")
 	ErrInvalidState       = errors.New("invalid connection state")
 	ErrConnectionNotFound = errors.New("connection not found")
+	ErrSetupTimeout       = errors.New("connection setup exceeded its deadline")
+	ErrSendQueueFull      = errors.New("send queue is full")
+	ErrHeaderTimeout      = errors.New("frame header read exceeded its deadline")
+	ErrReadTimeout        = errors.New("no frame received before the read deadline")
+	ErrWriteTimeout       = errors.New("frame write did not complete before the deadline")
+	ErrDraining           = errors.New("connection is draining")
+	ErrRateLimited        = errors.New("inbound rate limit exceeded")
+
+	ErrTooManyConnectionsForUser = errors.New("too many connections for user")
 
 	// Message errors
 	ErrInvalidMessageType = errors.New("invalid message type")
 	ErrEmptyPayload       = errors.New("empty payload")
+	ErrMessageTooLarge    = errors.New("message exceeds size limit")
 
 	// Protocol errors
 	ErrProtocolViolation = errors.New("protocol violation")
 	ErrPolicyViolation   = errors.New("policy violation")
 	ErrInternalError     = errors.New("internal error")
+
+	// Handshake errors
+	ErrInsecureTransport   = errors.New("handshake requires a secure transport")
+	ErrNotWebSocketRequest = errors.New("not a WebSocket upgrade request")
+	ErrOriginNotAllowed    = errors.New("request origin not allowed")
+	ErrUnsupportedVersion  = errors.New("unsupported WebSocket version")
+	ErrBadHandshake        = errors.New("invalid handshake response")
+
+	// Configuration errors
+	ErrInvalidConfig = errors.New("invalid configuration")
 )
+
+// FrameError wraps a frame error with the opcode that caused it, so logs show the value
+// a peer actually sent. Use errors.Is to match the underlying sentinel.
+type FrameError struct {
+	Opcode Opcode
+	Err    error
+}
+
+// Error returns the wrapped error followed by the opcode, e.g. "invalid opcode 0x5"
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("%v 0x%X", e.Err, byte(e.Opcode))
+}
+
+// Unwrap returns the wrapped sentinel error
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// CloseError reports a close frame received from the peer, keeping its status code and
+// reason. It wraps ErrConnectionClosed, so errors.Is(err, ErrConnectionClosed) still
+// matches; use errors.As or IsCloseError to inspect the code.
+type CloseError struct {
+	Code   uint16
+	Reason string
+}
+
+// Error returns the close code, its name and the reason, e.g.
+// "connection closed by peer: 1001 (GoingAway): restarting"
+func (e *CloseError) Error() string {
+	msg := fmt.Sprintf("connection closed by peer: %d (%s)", e.Code, protocol.CloseCodeName(e.Code))
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// Unwrap returns ErrConnectionClosed
+func (e *CloseError) Unwrap() error {
+	return ErrConnectionClosed
+}
+
+// IsCloseError reports whether err is, or wraps, a CloseError carrying code
+func IsCloseError(err error, code uint16) bool {
+	var closeErr *CloseError
+	return errors.As(err, &closeErr) && closeErr.Code == code
+}
@@ -1,6 +1,13 @@
 package domain
 
-import "fmt"
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"websocket-server/pkg/protocol"
+)
 
 // Opcode represents the WebSocket frame opcode
 type Opcode byte
@@ -25,6 +32,17 @@ func (o Opcode) IsData() bool {
 	return o <= 0x2
 }
 
+// IsReserved returns true for opcodes RFC 6455 reserves for future use: 0x3-0x7 among
+// the non-control opcodes and 0xB-0xF among the control opcodes
+func (o Opcode) IsReserved() bool {
+	switch o {
+	case OpcodeContinuation, OpcodeText, OpcodeBinary, OpcodeClose, OpcodePing, OpcodePong:
+		return false
+	default:
+		return true
+	}
+}
+
 // String returns the string representation of the opcode
 func (o Opcode) String() string {
 	switch o {
@@ -56,6 +74,8 @@ type Frame struct {
 	PayloadLen uint64  // Payload length
 	MaskingKey [4]byte // Masking key (if masked)
 	Payload    []byte  // Payload data
+
+	release func() // Returns a pooled payload buffer, nil when the payload is owned
 }
 
 // NewFrame creates a new frame with the given opcode and payload
@@ -72,15 +92,22 @@ func NewFrame(opcode Opcode, payload []byte) *Frame {
 	}
 }
 
-// Validate checks if the frame is valid according to RFC 6455
+// Validate checks if the frame is valid according to RFC 6455, with no extension
+// negotiated, so any reserved bit is rejected
 func (f *Frame) Validate() error {
+	return f.ValidateWithExtensions([3]bool{})
+}
+
+// ValidateWithExtensions is Validate for a connection whose negotiated extensions give
+// meaning to some reserved bits: allowedRSV[i] permits RSV(i+1) to be set
+func (f *Frame) ValidateWithExtensions(allowedRSV [3]bool) error {
 	// Check if opcode is valid
 	if !f.isValidOpcode() {
 		return ErrInvalidOpcode
 	}
 
-	// Check if reserved bits are set (they should be 0 unless extensions are negotiated)
-	if f.RSV1 || f.RSV2 || f.RSV3 {
+	// Reserved bits must be 0 unless an extension defines them
+	if (f.RSV1 && !allowedRSV[0]) || (f.RSV2 && !allowedRSV[1]) || (f.RSV3 && !allowedRSV[2]) {
 		return ErrReservedBitsSet
 	}
 
@@ -102,6 +129,42 @@ func (f *Frame) Validate() error {
 	return nil
 }
 
+// maxStringPayload is how many payload bytes String shows
+const maxStringPayload = 8
+
+// String returns a compact summary for logs and test failures, e.g.
+// "Frame{FIN=true Opcode=Text Masked=false Len=17 Payload=68656c6c6f2c2077...}".
+// Reserved bits appear only when set, and at most the first 8 payload bytes are shown.
+func (f *Frame) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Frame{FIN=%t Opcode=%s", f.FIN, f.Opcode)
+	if f.RSV1 || f.RSV2 || f.RSV3 {
+		fmt.Fprintf(&b, " RSV=%d%d%d", boolBit(f.RSV1), boolBit(f.RSV2), boolBit(f.RSV3))
+	}
+	fmt.Fprintf(&b, " Masked=%t Len=%d", f.Masked, f.PayloadLen)
+	if len(f.Payload) > 0 {
+		shown := f.Payload
+		if len(shown) > maxStringPayload {
+			shown = shown[:maxStringPayload]
+		}
+		b.WriteString(" Payload=")
+		b.WriteString(hex.EncodeToString(shown))
+		if len(f.Payload) > maxStringPayload {
+			b.WriteString("...")
+		}
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// boolBit returns 1 for true and 0 for false
+func boolBit(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
 // isValidOpcode checks if the opcode is valid
 func (f *Frame) isValidOpcode() bool {
 	switch f.Opcode {
@@ -121,3 +184,83 @@ func (f *Frame) IsControlFrame() bool {
 func (f *Frame) IsDataFrame() bool {
 	return f.Opcode.IsData()
 }
+
+// Header encodes the frame header as it goes on the wire: the first two bytes, the
+// extended payload length and, for a masked frame, the masking key. Together with the
+// payload, masked with MaskingKey when Masked is set, it forms the whole frame, so
+// callers can write both with net.Buffers instead of copying the payload. Reserved bits
+// are encoded as set; checking them against the negotiated extensions is up to the
+// caller.
+func (f *Frame) Header() ([]byte, error) {
+	if err := f.ValidateWithExtensions([3]bool{f.RSV1, f.RSV2, f.RSV3}); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 2, protocol.MaxFrameHeaderSize)
+
+	// First byte: FIN, RSV1-3, Opcode
+	header[0] = byte(f.Opcode)
+	if f.FIN {
+		header[0] |= 0x80
+	}
+	if f.RSV1 {
+		header[0] |= 0x40
+	}
+	if f.RSV2 {
+		header[0] |= 0x20
+	}
+	if f.RSV3 {
+		header[0] |= 0x10
+	}
+
+	// Second byte: MASK and payload length, extended to 16 or 64 bits when needed
+	if f.Masked {
+		header[1] = 0x80
+	}
+	switch {
+	case f.PayloadLen <= 125:
+		header[1] |= byte(f.PayloadLen)
+	case f.PayloadLen <= 65535:
+		header[1] |= protocol.PayloadLen16Bit
+		header = binary.BigEndian.AppendUint16(header, uint16(f.PayloadLen))
+	default:
+		header[1] |= protocol.PayloadLen64Bit
+		header = binary.BigEndian.AppendUint64(header, f.PayloadLen)
+	}
+
+	if f.Masked {
+		header = append(header, f.MaskingKey[:]...)
+	}
+	return header, nil
+}
+
+// SetRelease registers the function that hands the payload buffer back to its pool.
+// It is called by pooling parsers; applications should not need it.
+func (f *Frame) SetRelease(release func()) {
+	f.release = release
+}
+
+// Release returns a pooled payload buffer for reuse, after which Payload is nil.
+// The payload of a pooled frame is only valid until Release is called. Release is a
+// no-op for frames whose payload is owned by the caller.
+func (f *Frame) Release() {
+	if f.release == nil {
+		return
+	}
+	f.release()
+	f.release = nil
+	f.Payload = nil
+}
+
+// Clone returns a deep copy of the frame with its own payload, which stays valid after
+// the original is released. Frames are not safe to share between goroutines that mask
+// or modify them, e.g. when broadcasting one frame to many connections: give each its
+// own Clone.
+func (f *Frame) Clone() *Frame {
+	clone := *f
+	clone.release = nil
+	if f.Payload != nil {
+		clone.Payload = append([]byte(nil), f.Payload...)
+	}
+	return &clone
+}
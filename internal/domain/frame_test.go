@@ -1,6 +1,8 @@
 package domain
 
 import (
+	"bytes"
+	"errors"
 	"testing"
 )
 
@@ -193,6 +195,37 @@ func TestFrameValidate(t *testing.T) {
 	}
 }
 
+func TestFrameValidateWithExtensions(t *testing.T) {
+	rsv := func(rsv1, rsv2, rsv3 bool) *Frame {
+		frame := NewFrame(OpcodeBinary, []byte("data"))
+		frame.RSV1, frame.RSV2, frame.RSV3 = rsv1, rsv2, rsv3
+		return frame
+	}
+
+	tests := []struct {
+		name    string
+		frame   *Frame
+		allowed [3]bool
+		wantErr error
+	}{
+		{"no bits, none allowed", rsv(false, false, false), [3]bool{}, nil},
+		{"RSV1 allowed", rsv(true, false, false), [3]bool{true, false, false}, nil},
+		{"RSV1 not allowed", rsv(true, false, false), [3]bool{}, ErrReservedBitsSet},
+		{"RSV2 with only RSV1 allowed", rsv(false, true, false), [3]bool{true, false, false}, ErrReservedBitsSet},
+		{"all allowed", rsv(true, true, true), [3]bool{true, true, true}, nil},
+		{"RSV3 not allowed", rsv(true, false, true), [3]bool{true, true, false}, ErrReservedBitsSet},
+		{"other checks still apply", &Frame{FIN: false, RSV1: true, Opcode: OpcodePing}, [3]bool{true, false, false}, ErrInvalidFrameStructure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.frame.ValidateWithExtensions(tt.allowed); err != tt.wantErr {
+				t.Errorf("ValidateWithExtensions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestFrameIsControlFrame(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -238,3 +271,130 @@ func TestFrameIsDataFrame(t *testing.T) {
 		})
 	}
 }
+
+func TestFrameRelease(t *testing.T) {
+	// Owned payloads are left alone
+	frame := NewFrame(OpcodeText, []byte("owned"))
+	frame.Release()
+	if string(frame.Payload) != "owned" {
+		t.Errorf("expected owned payload to survive Release, got %q", frame.Payload)
+	}
+
+	// Pooled payloads are handed back exactly once
+	released := 0
+	frame.SetRelease(func() { released++ })
+	frame.Release()
+	frame.Release()
+	if released != 1 {
+		t.Errorf("expected release func to run once, ran %d times", released)
+	}
+	if frame.Payload != nil {
+		t.Error("expected payload to be cleared after Release")
+	}
+}
+
+func TestFrameClone(t *testing.T) {
+	original := NewFrame(OpcodeBinary, []byte("broadcast"))
+	original.SetRelease(func() {})
+
+	a, b := original.Clone(), original.Clone()
+	for i, clone := range []*Frame{a, b} {
+		clone.Masked = true
+		clone.MaskingKey = [4]byte{byte(i + 1), 0x22, 0x33, 0x44}
+		for j := range clone.Payload {
+			clone.Payload[j] ^= clone.MaskingKey[j%4]
+		}
+	}
+
+	if original.Masked || original.MaskingKey != [4]byte{} || string(original.Payload) != "broadcast" {
+		t.Errorf("expected the original untouched, got masked=%v key=%x payload=%q",
+			original.Masked, original.MaskingKey, original.Payload)
+	}
+	if a.Payload[0] == b.Payload[0] {
+		t.Error("expected clones masked with different keys to differ")
+	}
+
+	// Clones own their payload, so releasing the original leaves them intact
+	original.Release()
+	if len(a.Payload) != len("broadcast") {
+		t.Error("expected the clone's payload to survive releasing the original")
+	}
+	a.Release()
+	if a.Payload == nil {
+		t.Error("expected Release to be a no-op on a clone")
+	}
+}
+
+func TestFrameHeader(t *testing.T) {
+	masked := NewFrame(OpcodeBinary, []byte("abc"))
+	masked.Masked = true
+	masked.MaskingKey = [4]byte{0x01, 0x02, 0x03, 0x04}
+
+	compressed := NewFrame(OpcodeText, []byte("x"))
+	compressed.RSV1 = true
+
+	fragment := NewFrame(OpcodeText, make([]byte, 126))
+	fragment.FIN = false
+
+	tests := []struct {
+		name     string
+		frame    *Frame
+		expected []byte
+		wantErr  error
+	}{
+		{"empty text", NewFrame(OpcodeText, nil), []byte{0x81, 0x00}, nil},
+		{"masked binary", masked, []byte{0x82, 0x83, 0x01, 0x02, 0x03, 0x04}, nil},
+		{"rsv1 kept", compressed, []byte{0xC1, 0x01}, nil},
+		{"16-bit length fragment", fragment, []byte{0x01, 0x7E, 0x00, 0x7E}, nil},
+		{"64-bit length", NewFrame(OpcodeBinary, make([]byte, 65536)), []byte{0x82, 0x7F, 0, 0, 0, 0, 0, 0x01, 0, 0}, nil},
+		{"oversized control", NewFrame(OpcodePing, make([]byte, 126)), nil, ErrInvalidFrameStructure},
+		{"length mismatch", &Frame{FIN: true, Opcode: OpcodeText, PayloadLen: 3}, nil, ErrInvalidFrameStructure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := tt.frame.Header()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Header() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(header, tt.expected) {
+				t.Errorf("Header() = % X, want % X", header, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFrameString(t *testing.T) {
+	compressed := NewFrame(OpcodeBinary, []byte{0x01, 0x02})
+	compressed.RSV1 = true
+	compressed.Masked = true
+
+	tests := []struct {
+		name     string
+		frame    *Frame
+		expected string
+	}{
+		{"text", NewFrame(OpcodeText, []byte("hello, world!!!!!")), "Frame{FIN=true Opcode=Text Masked=false Len=17 Payload=68656c6c6f2c2077...}"},
+		{"short payload", NewFrame(OpcodePing, []byte("hi")), "Frame{FIN=true Opcode=Ping Masked=false Len=2 Payload=6869}"},
+		{"empty", NewFrame(OpcodeClose, nil), "Frame{FIN=true Opcode=Close Masked=false Len=0}"},
+		{"reserved bits", compressed, "Frame{FIN=true Opcode=Binary RSV=100 Masked=true Len=2 Payload=0102}"},
+		{"unknown opcode", &Frame{Opcode: 0x5}, "Frame{FIN=false Opcode=Unknown(0x5) Masked=false Len=0}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.frame.String(); got != tt.expected {
+				t.Errorf("String() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOpcodeIsReserved(t *testing.T) {
+	for o := Opcode(0); o <= 0xF; o++ {
+		known := o <= OpcodeBinary || (o >= OpcodeClose && o <= OpcodePong)
+		if o.IsReserved() == known {
+			t.Errorf("Opcode(0x%X).IsReserved() = %v", byte(o), o.IsReserved())
+		}
+	}
+}
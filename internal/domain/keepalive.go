@@ -0,0 +1,118 @@
+package domain
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// pingFrame is an unmasked, empty ping frame with FIN set, as sent by a server
+var pingFrame = []byte{0x80 | byte(OpcodePing), 0x00}
+
+// KeepaliveConfig controls when keepalive pings are sent on a connection
+type KeepaliveConfig struct {
+	Interval      time.Duration // How often the connection's activity is checked
+	IdleThreshold time.Duration // Quiet period after which a ping is sent, defaults to Interval
+	Timeout       time.Duration // How long a ping may go unanswered before the peer is dead, 0 to never give up
+}
+
+// Keepalive is a running keepalive loop
+type Keepalive struct {
+	stop     chan struct{}
+	done     chan struct{}
+	dead     chan struct{}
+	stopOnce sync.Once
+}
+
+// Stop ends the keepalive loop and waits for it to exit
+func (k *Keepalive) Stop() {
+	k.stopOnce.Do(func() {
+		close(k.stop)
+	})
+	<-k.done
+}
+
+// Dead is closed when a ping went unanswered for the configured timeout
+func (k *Keepalive) Dead() <-chan struct{} {
+	return k.dead
+}
+
+// StartKeepalive pings the peer through w whenever the connection has been idle for
+// interval, and declares it dead if no pong or other activity follows within timeout
+func (c *Connection) StartKeepalive(w io.Writer, interval, timeout time.Duration) (*Keepalive, error) {
+	return c.StartKeepaliveWithConfig(w, KeepaliveConfig{Interval: interval, Timeout: timeout})
+}
+
+// StartKeepaliveWithConfig starts a goroutine that writes a ping frame to w whenever the
+// connection has seen no activity (see UpdateActivity) for cfg.IdleThreshold. Connections
+// that are actively exchanging frames are never pinged. When cfg.Timeout is set and no
+// activity follows a ping in time, the connection moves to Closing and Dead is closed.
+// The loop exits when Stop is called, when the connection is closed, when the peer is
+// declared dead, or when writing a ping fails. Writes to w must not interleave with
+// other writers on the same transport. A non-positive Interval or a negative Timeout
+// fails with ErrInvalidConfig.
+func (c *Connection) StartKeepaliveWithConfig(w io.Writer, cfg KeepaliveConfig) (*Keepalive, error) {
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("%w: keepalive interval must be positive, got %v", ErrInvalidConfig, cfg.Interval)
+	}
+	if cfg.Timeout < 0 {
+		return nil, fmt.Errorf("%w: negative keepalive timeout %v", ErrInvalidConfig, cfg.Timeout)
+	}
+	if cfg.IdleThreshold <= 0 {
+		cfg.IdleThreshold = cfg.Interval
+	}
+
+	k := &Keepalive{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		dead: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(k.done)
+
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+
+		var pingSent time.Time // zero while no ping is outstanding
+		for {
+			select {
+			case <-ticker.C:
+			case <-k.stop:
+				return
+			}
+
+			if c.IsClosed() {
+				return
+			}
+
+			idle := c.IdleDuration()
+			if !pingSent.IsZero() {
+				waited := time.Since(pingSent)
+				switch {
+				case idle < waited:
+					// The peer answered, or sent something else, after the ping
+					pingSent = time.Time{}
+				case cfg.Timeout > 0 && waited >= cfg.Timeout:
+					_ = c.TransitionTo(StateClosing)
+					close(k.dead)
+					return
+				default:
+					continue
+				}
+			}
+
+			if idle < cfg.IdleThreshold {
+				continue
+			}
+			// Stamp before writing so a pong racing the write still counts as an answer
+			pingSent = time.Now()
+			if _, err := w.Write(pingFrame); err != nil {
+				return
+			}
+		}
+	}()
+
+	return k, nil
+}
@@ -0,0 +1,181 @@
+package domain
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pingCounter counts the ping frames written to it
+type pingCounter struct {
+	mu    sync.Mutex
+	pings int
+}
+
+func (p *pingCounter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(b) > 0 && Opcode(b[0]&0x0F) == OpcodePing {
+		p.pings++
+	}
+	return len(b), nil
+}
+
+func (p *pingCounter) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pings
+}
+
+func TestKeepaliveSkipsActiveConnections(t *testing.T) {
+	conn := NewConnection("active", "127.0.0.1:8080")
+	_ = conn.TransitionTo(StateOpen)
+
+	counter := &pingCounter{}
+	keepalive, err := conn.StartKeepaliveWithConfig(counter, KeepaliveConfig{
+		Interval:      5 * time.Millisecond,
+		IdleThreshold: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("StartKeepalive failed: %v", err)
+	}
+	defer keepalive.Stop()
+
+	// Simulate a steady stream of inbound frames
+	deadline := time.Now().Add(150 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		conn.UpdateActivity()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if n := counter.count(); n != 0 {
+		t.Errorf("expected no pings on an active connection, got %d", n)
+	}
+}
+
+func TestKeepalivePingsIdleConnections(t *testing.T) {
+	conn := NewConnection("silent", "127.0.0.1:8080")
+	_ = conn.TransitionTo(StateOpen)
+
+	counter := &pingCounter{}
+	keepalive, err := conn.StartKeepaliveWithConfig(counter, KeepaliveConfig{
+		Interval:      5 * time.Millisecond,
+		IdleThreshold: 30 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("StartKeepalive failed: %v", err)
+	}
+	defer keepalive.Stop()
+
+	time.Sleep(15 * time.Millisecond)
+	if n := counter.count(); n != 0 {
+		t.Errorf("expected no ping before the idle threshold, got %d", n)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := counter.count(); n == 0 {
+		t.Error("expected a ping once the connection went idle")
+	}
+}
+
+func TestKeepaliveStopsWhenConnectionCloses(t *testing.T) {
+	conn := NewConnection("closing", "127.0.0.1:8080")
+	_ = conn.TransitionTo(StateOpen)
+
+	_ = conn.TransitionTo(StateClosed)
+	keepalive, err := conn.StartKeepaliveWithConfig(&pingCounter{}, KeepaliveConfig{Interval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("StartKeepalive failed: %v", err)
+	}
+
+	select {
+	case <-keepalive.done:
+	case <-time.After(time.Second):
+		t.Fatal("keepalive did not exit after the connection closed")
+	}
+}
+
+func TestKeepaliveDeclaresUnresponsivePeerDead(t *testing.T) {
+	conn := NewConnection("half-open", "127.0.0.1:8080")
+	_ = conn.TransitionTo(StateOpen)
+
+	counter := &pingCounter{}
+	keepalive, err := conn.StartKeepalive(counter, 5*time.Millisecond, 30*time.Millisecond)
+	if err != nil {
+		t.Fatalf("StartKeepalive failed: %v", err)
+	}
+	defer keepalive.Stop()
+
+	select {
+	case <-keepalive.Dead():
+	case <-time.After(time.Second):
+		t.Fatal("expected the unresponsive peer to be declared dead")
+	}
+	if !conn.IsClosing() {
+		t.Errorf("expected connection to be closing, got %s", conn.State)
+	}
+	if n := counter.count(); n != 1 {
+		t.Errorf("expected a single unanswered ping, got %d", n)
+	}
+}
+
+func TestKeepaliveKeepsResponsivePeerAlive(t *testing.T) {
+	conn := NewConnection("responsive", "127.0.0.1:8080")
+	_ = conn.TransitionTo(StateOpen)
+
+	// Answer every ping right away, as a pong would
+	pongs := &pongingWriter{conn: conn}
+	keepalive, err := conn.StartKeepalive(pongs, 5*time.Millisecond, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("StartKeepalive failed: %v", err)
+	}
+	defer keepalive.Stop()
+
+	select {
+	case <-keepalive.Dead():
+		t.Fatal("responsive peer was declared dead")
+	case <-time.After(150 * time.Millisecond):
+	}
+	if pongs.count() < 2 {
+		t.Errorf("expected repeated pings on an idle but responsive connection, got %d", pongs.count())
+	}
+}
+
+// pongingWriter records activity on conn for every ping written, simulating a prompt pong
+type pongingWriter struct {
+	pingCounter
+	conn *Connection
+}
+
+func (p *pongingWriter) Write(b []byte) (int, error) {
+	n, err := p.pingCounter.Write(b)
+	p.conn.UpdateActivity()
+	return n, err
+}
+
+func TestKeepaliveRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  KeepaliveConfig
+	}{
+		{"zero value", KeepaliveConfig{}},
+		{"negative interval", KeepaliveConfig{Interval: -time.Second}},
+		{"negative timeout", KeepaliveConfig{Interval: time.Second, Timeout: -time.Second}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := NewConnection("misconfigured", "127.0.0.1:8080")
+			_ = conn.TransitionTo(StateOpen)
+
+			keepalive, err := conn.StartKeepaliveWithConfig(&pingCounter{}, tt.cfg)
+			if !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("expected ErrInvalidConfig, got %v", err)
+			}
+			if keepalive != nil {
+				t.Error("expected no keepalive loop to be started")
+			}
+		})
+	}
+}
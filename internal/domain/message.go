@@ -1,6 +1,10 @@
 package domain
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
 
 // MessageType represents the type of WebSocket message
 type MessageType int
@@ -55,9 +59,38 @@ func (m *Message) Validate() error {
 
 	// Payload can be empty for some use cases, so we don't enforce non-empty
 
+	return m.ValidateText()
+}
+
+// ValidateText returns ErrInvalidFramePayloadData when a text message is not valid UTF-8,
+// including a truncated multi-byte sequence at the end. Binary messages always pass.
+// Fragmented messages must be checked once reassembled, as a rune may span fragments.
+func (m *Message) ValidateText() error {
+	if m.Type == MessageTypeText && !utf8.Valid(m.Payload) {
+		return ErrInvalidFramePayloadData
+	}
 	return nil
 }
 
+// NewJSONMessage marshals v with encoding/json into a text message
+func NewJSONMessage(v interface{}) (*Message, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return NewTextMessage(payload), nil
+}
+
+// DecodeJSON unmarshals the payload of a text message into v. Binary messages are
+// rejected with ErrInvalidMessageType. It is not named UnmarshalJSON so that Message
+// keeps the default encoding/json behavior.
+func (m *Message) DecodeJSON(v interface{}) error {
+	if m.Type != MessageTypeText {
+		return fmt.Errorf("%w: JSON requires a text message, got %s", ErrInvalidMessageType, m.Type)
+	}
+	return json.Unmarshal(m.Payload, v)
+}
+
 // IsText returns true if this is a text message
 func (m *Message) IsText() bool {
 	return m.Type == MessageTypeText
@@ -68,6 +101,26 @@ func (m *Message) IsBinary() bool {
 	return m.Type == MessageTypeBinary
 }
 
+// Len returns the payload size in bytes
+func (m *Message) Len() int {
+	return len(m.Payload)
+}
+
+// ExceedsSize reports whether the payload is larger than max bytes. A max of zero or
+// less means no limit.
+func (m *Message) ExceedsSize(max int) bool {
+	return max > 0 && m.Len() > max
+}
+
+// CheckSize returns ErrMessageTooLarge when the payload is larger than max bytes, see
+// ExceedsSize
+func (m *Message) CheckSize(max int) error {
+	if m.ExceedsSize(max) {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrMessageTooLarge, m.Len(), max)
+	}
+	return nil
+}
+
 // ToOpcode converts the message type to the corresponding frame opcode
 func (m *Message) ToOpcode() Opcode {
 	switch m.Type {
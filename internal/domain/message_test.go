@@ -1,7 +1,10 @@
 package domain
 
 import (
+	"errors"
 	"testing"
+
+	"websocket-server/pkg/protocol"
 )
 
 func TestNewTextMessage(t *testing.T) {
@@ -82,6 +85,30 @@ func TestMessageValidate(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		{
+			name: "invalid UTF-8 text message",
+			message: &Message{
+				Type:    MessageTypeText,
+				Payload: []byte{0xC3, 0x28},
+			},
+			wantErr: ErrInvalidFramePayloadData,
+		},
+		{
+			name: "truncated multi-byte text message",
+			message: &Message{
+				Type:    MessageTypeText,
+				Payload: []byte{'h', 'i', 0xE2, 0x82},
+			},
+			wantErr: ErrInvalidFramePayloadData,
+		},
+		{
+			name: "invalid UTF-8 is fine in binary message",
+			message: &Message{
+				Type:    MessageTypeBinary,
+				Payload: []byte{0xC3, 0x28},
+			},
+			wantErr: nil,
+		},
 		{
 			name: "invalid message type",
 			message: &Message{
@@ -102,6 +129,77 @@ func TestMessageValidate(t *testing.T) {
 	}
 }
 
+func TestMessageSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		max     int
+		exceeds bool
+	}{
+		{"under limit", []byte("hello"), 10, false},
+		{"at limit", []byte("hello"), 5, false},
+		{"over limit", []byte("hello!"), 5, true},
+		{"empty payload", nil, 1, false},
+		{"no limit", make([]byte, 1<<10), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := NewBinaryMessage(tt.payload)
+			if msg.Len() != len(tt.payload) {
+				t.Errorf("Len() = %d, want %d", msg.Len(), len(tt.payload))
+			}
+			if got := msg.ExceedsSize(tt.max); got != tt.exceeds {
+				t.Errorf("ExceedsSize(%d) = %v, want %v", tt.max, got, tt.exceeds)
+			}
+			err := msg.CheckSize(tt.max)
+			if tt.exceeds != errors.Is(err, ErrMessageTooLarge) {
+				t.Errorf("CheckSize(%d) = %v, want ErrMessageTooLarge: %v", tt.max, err, tt.exceeds)
+			}
+			if tt.exceeds && errors.Is(err, ErrPayloadTooLarge) {
+				t.Error("ErrMessageTooLarge must stay distinct from ErrPayloadTooLarge")
+			}
+		})
+	}
+
+	if code := CloseCodeForError(ErrMessageTooLarge); code != protocol.StatusMessageTooBig {
+		t.Errorf("expected close code %d, got %d", protocol.StatusMessageTooBig, code)
+	}
+}
+
+func TestMessageJSON(t *testing.T) {
+	type event struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	msg, err := NewJSONMessage(event{Name: "join", Count: 3})
+	if err != nil {
+		t.Fatalf("NewJSONMessage failed: %v", err)
+	}
+	if !msg.IsText() || string(msg.Payload) != `{"name":"join","count":3}` {
+		t.Errorf("unexpected message %s %q", msg.Type, msg.Payload)
+	}
+
+	var decoded event
+	if err := msg.DecodeJSON(&decoded); err != nil {
+		t.Fatalf("DecodeJSON failed: %v", err)
+	}
+	if decoded != (event{Name: "join", Count: 3}) {
+		t.Errorf("round trip mismatch: %+v", decoded)
+	}
+
+	if err := NewBinaryMessage(msg.Payload).DecodeJSON(&decoded); !errors.Is(err, ErrInvalidMessageType) {
+		t.Errorf("expected ErrInvalidMessageType for a binary message, got %v", err)
+	}
+	if err := NewTextMessage([]byte("{")).DecodeJSON(&decoded); err == nil {
+		t.Error("expected an error for malformed JSON")
+	}
+	if _, err := NewJSONMessage(make(chan int)); err == nil {
+		t.Error("expected an error for a value JSON cannot encode")
+	}
+}
+
 func TestMessageIsText(t *testing.T) {
 	tests := []struct {
 		msgType  MessageType
@@ -0,0 +1,360 @@
+package infrastructure
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+// DefaultCloseTimeout is how long Close waits for the peer to answer a close frame
+const DefaultCloseTimeout = 5 * time.Second
+
+// Conn is a server-side WebSocket connection returned by Upgrade. It bundles the
+// hijacked network connection, a frame parser and the connection state: ReadMessage
+// reassembles fragmented messages and handles control frames, and Close runs the closing
+// handshake.
+//
+// A Conn may be written from several goroutines at once: WriteMessage, WritePing,
+// WritePong and WriteClose, along with the pongs ReadMessage sends, never interleave
+// their frames. Reads are single-threaded; only one goroutine may call ReadMessage.
+//
+// Every frame ReadMessage reads, pongs included, counts as activity on the connection
+// (see domain.Connection.UpdateActivity), so keepalives and ReapIdle leave busy
+// connections alone.
+type Conn struct {
+	// CloseTimeout bounds how long Close waits for the peer's close frame. Zero means
+	// DefaultCloseTimeout.
+	CloseTimeout time.Duration
+
+	// OnProtocolError, when set, is called by ReadMessage with every error that makes it
+	// reject the peer's data and fail the connection, e.g. an unmasked frame or invalid
+	// UTF-8, before the close frame is sent. Use errors.As with *domain.FrameError to get
+	// the offending opcode where there is one. Network errors and the peer's own close
+	// are not reported.
+	OnProtocolError func(conn *domain.Connection, err error)
+
+	netConn   net.Conn
+	brw       *bufio.ReadWriter
+	out       io.Writer // brw.Writer, counting bytes into bytesWritten
+	parser    *FrameParser
+	reader    *MessageReader
+	state     *domain.Connection
+	handshake *HandshakeResult
+	readMu    sync.Mutex // Held by the reading goroutine, so Close knows whether to read itself
+	writeMu   sync.Mutex // Serializes frame writes so they never interleave on the wire
+	closeOnce sync.Once
+
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+}
+
+// countingReader counts the bytes read through it into n
+type countingReader struct {
+	r io.Reader
+	n *atomic.Uint64
+}
+
+// Read reads from the underlying reader
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n.Add(uint64(n))
+	return n, err
+}
+
+// countingWriter counts the bytes written through it into n
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Uint64
+}
+
+// Write writes to the underlying writer
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n.Add(uint64(n))
+	return n, err
+}
+
+// Upgrade performs the opening handshake with a default HandshakeValidator and returns
+// the upgraded connection
+func Upgrade(w http.ResponseWriter, req *http.Request) (*Conn, error) {
+	return NewHandshakeValidator().Upgrade(w, req)
+}
+
+// Upgrade performs the opening handshake through Hijack, applying the validator's
+// options, and returns the upgraded connection. Messages are compressed when
+// EnableCompression is set and the client offered permessage-deflate.
+func (h *HandshakeValidator) Upgrade(w http.ResponseWriter, req *http.Request) (*Conn, error) {
+	netConn, brw, err := h.Hijack(w, req)
+	if err != nil {
+		return nil, err
+	}
+
+	handshake := NewHandshakeResult(req, w.Header())
+	parser := NewServerFrameParser(protocol.MaxPayloadSize)
+	parser.Deflate = handshake.Compression
+
+	state := domain.NewConnection(newConnectionID(), h.remoteAddr(req))
+	state.Headers = h.CaptureHeaders(req)
+
+	c := &Conn{
+		netConn:   netConn,
+		brw:       brw,
+		parser:    parser,
+		state:     state,
+		handshake: handshake,
+	}
+	c.reader = NewMessageReader(parser, countingReader{brw.Reader, &c.bytesRead}, 0)
+	c.out = countingWriter{brw.Writer, &c.bytesWritten}
+	c.reader.OnPing = c.writePong
+	c.reader.onFrame = state.UpdateActivity
+	state.SetSender(c)
+
+	if err := state.TransitionTo(domain.StateOpen); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Connection returns the connection's state, e.g. to register it with a ConnectionManager
+func (c *Conn) Connection() *domain.Connection {
+	return c.state
+}
+
+// Stats returns the bytes read and written on the connection since the upgrade, counting
+// whole frames, headers included, as they cross the wire. The handshake is not counted.
+// It is safe to call from any goroutine.
+func (c *Conn) Stats() (read, written uint64) {
+	return c.bytesRead.Load(), c.bytesWritten.Load()
+}
+
+// SetRateLimiter caps the inbound traffic ReadMessage accepts; once limiter refuses a
+// frame the connection is closed with StatusPolicyViolation. Call it before the first
+// ReadMessage. Nil, the default, means unlimited.
+func (c *Conn) SetRateLimiter(limiter RateLimiter) {
+	c.reader.RateLimiter = limiter
+}
+
+// SetReadLimit caps the size of a message ReadMessage reassembles, and so of any single
+// frame; a larger one closes the connection with StatusMessageTooBig. Zero restores the
+// default, protocol.MaxMessageSize. Call it before the first ReadMessage.
+func (c *Conn) SetReadLimit(n uint64) {
+	if n == 0 {
+		n = protocol.MaxMessageSize
+	}
+	c.reader.maxMessageSize = n
+	c.parser.SetMaxPayloadSize(n)
+}
+
+// Handshake describes the accepted handshake: request path and query, subprotocol and
+// compression
+func (c *Conn) Handshake() *HandshakeResult {
+	return c.handshake
+}
+
+// Subprotocol returns the subprotocol selected during the handshake, or "" for none
+func (c *Conn) Subprotocol() string {
+	return c.handshake.Subprotocol
+}
+
+// ReadMessage reads the next complete data message, reassembling fragments and checking
+// that text is valid UTF-8; messages over the read limit (see SetReadLimit) are
+// rejected. Pings are answered and pongs consumed. A close frame from the peer completes
+// or answers the closing handshake and is returned as a *domain.CloseError. Any other
+// read error also closes the connection, first telling the peer why when the error is a
+// protocol violation. Once the connection is draining (see domain.Connection.Drain),
+// data messages are discarded while ReadMessage waits for the peer's close. ReadMessage
+// must not be called from more than one goroutine at a time.
+func (c *Conn) ReadMessage() (*domain.Message, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	return c.readMessage()
+}
+
+// readMessage implements ReadMessage; the caller must hold readMu
+func (c *Conn) readMessage() (*domain.Message, error) {
+	msg, err := c.reader.ReadMessage()
+	for err == nil && c.state.IsDraining() {
+		// Drain stopped taking on new work: drop the message and wait for the peer's close
+		msg, err = c.reader.ReadMessage()
+	}
+	if err == nil {
+		return msg, nil
+	}
+
+	var closeErr *domain.CloseError
+	if errors.As(err, &closeErr) {
+		// Echoes the peer's close, unless it is the answer to ours
+		_ = c.state.OnCloseReceived(closeErr.Code)
+	} else if code := domain.CloseCodeForError(err); code != protocol.StatusInternalServerError {
+		if c.OnProtocolError != nil {
+			c.OnProtocolError(c.state, err)
+		}
+		if c.state.IsOpen() {
+			_ = c.state.StartClose(code, domain.CloseReasonForError(err))
+		}
+	}
+	c.shutdown()
+	return nil, err
+}
+
+// WriteMessage sends a data message. It fails with ErrConnectionClosed once the closing
+// handshake has started.
+func (c *Conn) WriteMessage(msg *domain.Message) error {
+	return c.state.Send(msg)
+}
+
+// WritePing sends a ping carrying payload, at most 125 bytes
+func (c *Conn) WritePing(payload []byte) error {
+	return c.writeControl(domain.OpcodePing, payload)
+}
+
+// WritePong sends an unsolicited pong carrying payload, e.g. as a unidirectional
+// heartbeat; pings are already answered by ReadMessage
+func (c *Conn) WritePong(payload []byte) error {
+	return c.writeControl(domain.OpcodePong, payload)
+}
+
+// StartKeepalive pings the peer whenever the connection has been quiet for a while (see
+// domain.Connection.StartKeepaliveWithConfig). Pings go through WritePing, so they never
+// interleave with other frames, and are answered by any frame ReadMessage reads, so a
+// goroutine must keep reading.
+func (c *Conn) StartKeepalive(cfg domain.KeepaliveConfig) (*domain.Keepalive, error) {
+	return c.state.StartKeepaliveWithConfig(keepaliveWriter{c}, cfg)
+}
+
+// keepaliveWriter sends the pings of a keepalive loop as frames of its Conn
+type keepaliveWriter struct {
+	c *Conn
+}
+
+// Write sends an empty ping in place of the raw ping frame in p
+func (w keepaliveWriter) Write(p []byte) (int, error) {
+	if err := w.c.WritePing(nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteClose starts the closing handshake by sending a close frame carrying code and
+// reason, without waiting for the peer's answer; the reading goroutine receives it. Use
+// Close to wait for it instead. A reason that is not valid UTF-8 fails with
+// domain.ErrInvalidFramePayloadData and nothing is sent.
+func (c *Conn) WriteClose(code uint16, reason string) error {
+	return c.state.StartClose(code, reason)
+}
+
+// writeControl sends a ping or pong while the connection is open
+func (c *Conn) writeControl(opcode domain.Opcode, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	// Checked under writeMu so the frame can never follow a close frame
+	if !c.state.IsOpen() {
+		return domain.ErrConnectionClosed
+	}
+	if err := c.parser.writeControl(c.out, opcode, payload); err != nil {
+		return err
+	}
+	return c.brw.Flush()
+}
+
+// Close runs the closing handshake: it sends a close frame carrying code and reason, then
+// waits up to CloseTimeout for the peer's answer before closing the network connection.
+// When another goroutine is blocked in ReadMessage, that reader receives the answer, or
+// times out, and closes the connection; Close then returns once the close frame is sent.
+//
+// Close is idempotent: once the connection is closed, e.g. by an earlier Close or after
+// ReadMessage echoed the peer's close, it returns nil without sending anything. After
+// WriteClose it sends no second close frame and only waits for the answer.
+func (c *Conn) Close(code uint16, reason string) error {
+	if err := c.WriteClose(code, reason); err != nil {
+		switch {
+		case errors.Is(err, domain.ErrInvalidFramePayloadData):
+			return err
+		case !errors.Is(err, domain.ErrConnectionClosed):
+			c.shutdown()
+			return err
+		case c.state.IsClosed():
+			c.shutdown()
+			return nil
+		}
+		// The closing handshake is already underway: wait for the peer's answer
+	}
+
+	timeout := c.CloseTimeout
+	if timeout <= 0 {
+		timeout = DefaultCloseTimeout
+	}
+	if err := c.netConn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		c.shutdown()
+		return err
+	}
+
+	if !c.readMu.TryLock() {
+		return nil
+	}
+	defer c.readMu.Unlock()
+
+	// Discard data still in flight until the peer's close frame arrives
+	for {
+		if _, err := c.readMessage(); err != nil {
+			var closeErr *domain.CloseError
+			if errors.As(err, &closeErr) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// SendMessage implements domain.MessageSender by writing the message to the network
+func (c *Conn) SendMessage(msg *domain.Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	// The close frame may have gone out since Send checked the state; nothing may follow it
+	if !c.state.IsOpen() {
+		return domain.ErrConnectionClosed
+	}
+	if err := c.parser.WriteMessage(c.out, msg, 0); err != nil {
+		return err
+	}
+	return c.brw.Flush()
+}
+
+// SendClose writes a close frame; the domain close handshake uses it
+func (c *Conn) SendClose(frame *domain.Frame) error {
+	return c.writeFrame(frame)
+}
+
+// writePong answers a ping, echoing its payload
+func (c *Conn) writePong(payload []byte) error {
+	return c.writeFrame(domain.NewFrame(domain.OpcodePong, append([]byte(nil), payload...)))
+}
+
+// writeFrame writes a single frame to the network
+func (c *Conn) writeFrame(frame *domain.Frame) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.parser.WriteFrame(c.out, frame); err != nil {
+		return err
+	}
+	return c.brw.Flush()
+}
+
+// shutdown marks the connection closed and closes the network connection
+func (c *Conn) shutdown() {
+	c.closeOnce.Do(func() {
+		if !c.state.IsClosed() {
+			_ = c.state.TransitionTo(domain.StateClosed)
+		}
+		c.netConn.Close()
+	})
+}
@@ -0,0 +1,763 @@
+package infrastructure
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+// upgradeServer starts an HTTP server upgrading every request with Upgrade and handing
+// the connection to handle; handle's error is delivered on the returned channel
+func upgradeServer(t *testing.T, handle func(*Conn) error) (*httptest.Server, <-chan error) {
+	t.Helper()
+	result := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := Upgrade(w, req)
+		if err != nil {
+			result <- err
+			return
+		}
+		result <- handle(conn)
+	}))
+	t.Cleanup(server.Close)
+	return server, result
+}
+
+// dialWebSocket completes a client handshake with server, returning the connection and
+// a reader positioned at the first frame
+func dialWebSocket(t *testing.T, server *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.WriteString(conn, rawHandshakeRequest); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	return conn, br
+}
+
+// expectFrame reads the next frame and checks its opcode and payload
+func expectFrame(t *testing.T, r io.Reader, opcode domain.Opcode, payload []byte) {
+	t.Helper()
+	frame, err := NewFrameParser(protocol.MaxPayloadSize).ReadFrame(r)
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if frame.Opcode != opcode || string(frame.Payload) != string(payload) {
+		t.Errorf("expected %s %q, got %s %q", opcode, payload, frame.Opcode, frame.Payload)
+	}
+}
+
+// newCloseFrame builds a close frame, failing the test on an invalid reason
+func newCloseFrame(t testing.TB, code uint16, reason string) *domain.Frame {
+	t.Helper()
+	frame, err := domain.NewCloseFrame(code, reason)
+	if err != nil {
+		t.Fatalf("NewCloseFrame failed: %v", err)
+	}
+	return frame
+}
+
+func TestConn_EchoAndPeerClose(t *testing.T) {
+	server, result := upgradeServer(t, func(c *Conn) error {
+		for {
+			msg, err := c.ReadMessage()
+			if err != nil {
+				return err
+			}
+			if err := c.WriteMessage(msg); err != nil {
+				return err
+			}
+		}
+	})
+	conn, br := dialWebSocket(t, server)
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+
+	_ = client.WriteFrame(conn, fragment(domain.OpcodeText, "hel", false))
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodePing, []byte("p")))
+	_ = client.WriteFrame(conn, fragment(domain.OpcodeContinuation, "lo", true))
+	expectFrame(t, br, domain.OpcodePong, []byte("p"))
+	expectFrame(t, br, domain.OpcodeText, []byte("hello"))
+
+	// The peer's close is echoed and surfaces as a CloseError
+	closeFrame := newCloseFrame(t, protocol.StatusNormalClosure, "done")
+	_ = client.WriteFrame(conn, closeFrame)
+	expectFrame(t, br, domain.OpcodeClose, closeFrame.Payload[:2])
+
+	err := <-result
+	if !domain.IsCloseError(err, protocol.StatusNormalClosure) {
+		t.Errorf("expected CloseError 1000, got %v", err)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("expected the server to close the connection, got %v", err)
+	}
+}
+
+func TestConn_ServerClose(t *testing.T) {
+	var upgraded *Conn
+	server, result := upgradeServer(t, func(c *Conn) error {
+		upgraded = c
+		return c.Close(protocol.StatusGoingAway, "restarting")
+	})
+	conn, br := dialWebSocket(t, server)
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+
+	closeFrame := newCloseFrame(t, protocol.StatusGoingAway, "restarting")
+	expectFrame(t, br, domain.OpcodeClose, closeFrame.Payload)
+
+	// Data sent before the echo is discarded while Close waits
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodeText, []byte("in flight")))
+	_ = client.WriteFrame(conn, newCloseFrame(t, protocol.StatusGoingAway, ""))
+
+	if err := <-result; err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !upgraded.Connection().IsClosed() || upgraded.Connection().ClosedBy() != domain.CloseInitiatorLocal {
+		t.Errorf("expected a closed connection closed locally, got %s by %s",
+			upgraded.Connection().CurrentState(), upgraded.Connection().ClosedBy())
+	}
+	if err := upgraded.WriteMessage(domain.NewTextMessage([]byte("late"))); !errors.Is(err, domain.ErrConnectionClosed) {
+		t.Errorf("expected ErrConnectionClosed writing after Close, got %v", err)
+	}
+}
+
+func TestConn_DrainDiscardsInboundMessages(t *testing.T) {
+	var received []string
+	server, result := upgradeServer(t, func(c *Conn) error {
+		for {
+			msg, err := c.ReadMessage()
+			if err != nil {
+				return err
+			}
+			received = append(received, string(msg.Payload))
+			if err := c.Connection().Drain(); err != nil {
+				return err
+			}
+		}
+	})
+	conn, br := dialWebSocket(t, server)
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodeText, []byte("before")))
+	expectFrame(t, br, domain.OpcodeClose, newCloseFrame(t, protocol.StatusGoingAway, "server is draining").Payload)
+
+	// Messages the peer sends before answering the close are dropped
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodeText, []byte("late")))
+	_ = client.WriteFrame(conn, newCloseFrame(t, protocol.StatusGoingAway, ""))
+
+	if err := <-result; !domain.IsCloseError(err, protocol.StatusGoingAway) {
+		t.Errorf("expected CloseError 1001, got %v", err)
+	}
+	if strings.Join(received, ",") != "before" {
+		t.Errorf("expected only the message sent before the drain, got %v", received)
+	}
+}
+
+func TestConn_NoDataAfterCloseFrame(t *testing.T) {
+	server, result := upgradeServer(t, func(c *Conn) error {
+		// Hold the wire so the write passes the state check and then waits for it
+		c.writeMu.Lock()
+		written := make(chan error, 1)
+		go func() {
+			written <- c.WriteMessage(domain.NewTextMessage([]byte("late")))
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		closed := make(chan error, 1)
+		go func() {
+			closed <- c.WriteClose(protocol.StatusNormalClosure, "")
+		}()
+		for c.Connection().IsOpen() {
+			time.Sleep(time.Millisecond)
+		}
+		c.writeMu.Unlock()
+
+		if err := <-closed; err != nil {
+			return err
+		}
+		return <-written
+	})
+	_, br := dialWebSocket(t, server)
+
+	if err := <-result; !errors.Is(err, domain.ErrConnectionClosed) {
+		t.Errorf("expected WriteMessage to fail once closing, got %v", err)
+	}
+	expectFrame(t, br, domain.OpcodeClose, newCloseFrame(t, protocol.StatusNormalClosure, "").Payload)
+}
+
+func TestConn_CloseIsIdempotent(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle func(c *Conn) error
+	}{
+		{"close twice", func(c *Conn) error {
+			if err := c.Close(protocol.StatusNormalClosure, ""); err != nil {
+				return err
+			}
+			return c.Close(protocol.StatusNormalClosure, "")
+		}},
+		{"write close then close", func(c *Conn) error {
+			if err := c.WriteClose(protocol.StatusNormalClosure, ""); err != nil {
+				return err
+			}
+			return c.Close(protocol.StatusGoingAway, "ignored")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, result := upgradeServer(t, tt.handle)
+			conn, br := dialWebSocket(t, server)
+			client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+
+			expectFrame(t, br, domain.OpcodeClose, []byte{0x03, 0xE8})
+			_ = client.WriteFrame(conn, newCloseFrame(t, protocol.StatusNormalClosure, ""))
+
+			if err := <-result; err != nil {
+				t.Fatalf("expected both calls to succeed, got %v", err)
+			}
+			// Exactly one close frame, then the server hangs up
+			if _, err := br.ReadByte(); err != io.EOF {
+				t.Errorf("expected EOF after a single close frame, got %v", err)
+			}
+		})
+	}
+}
+
+func TestConn_CloseAfterPeerClose(t *testing.T) {
+	server, result := upgradeServer(t, func(c *Conn) error {
+		if _, err := c.ReadMessage(); !domain.IsCloseError(err, protocol.StatusGoingAway) {
+			return err
+		}
+		return c.Close(protocol.StatusNormalClosure, "")
+	})
+	conn, br := dialWebSocket(t, server)
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+
+	_ = client.WriteFrame(conn, newCloseFrame(t, protocol.StatusGoingAway, ""))
+	expectFrame(t, br, domain.OpcodeClose, []byte{0x03, 0xE9})
+
+	if err := <-result; err != nil {
+		t.Fatalf("expected Close after the peer's close to succeed, got %v", err)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("expected EOF after the echo, got %v", err)
+	}
+}
+
+func TestConn_CloseTimesOutWithoutAnswer(t *testing.T) {
+	server, result := upgradeServer(t, func(c *Conn) error {
+		c.CloseTimeout = 50 * time.Millisecond
+		return c.Close(protocol.StatusNormalClosure, "")
+	})
+	_, br := dialWebSocket(t, server)
+
+	expectFrame(t, br, domain.OpcodeClose, []byte{0x03, 0xE8})
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("expected an error when the peer never answers")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not give up waiting for the peer")
+	}
+}
+
+func TestConn_ProtocolErrorClosesWithCode(t *testing.T) {
+	server, result := upgradeServer(t, func(c *Conn) error {
+		_, err := c.ReadMessage()
+		return err
+	})
+	conn, br := dialWebSocket(t, server)
+
+	// Clients must mask their frames
+	_ = NewFrameParser(protocol.MaxPayloadSize).WriteFrame(conn, domain.NewFrame(domain.OpcodeText, []byte("bare")))
+
+	frame, err := NewFrameParser(protocol.MaxPayloadSize).ReadFrame(br)
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if code, _ := frame.CloseCode(); code != protocol.StatusProtocolError {
+		t.Errorf("expected close code 1002, got %d", code)
+	}
+	if err := <-result; !errors.Is(err, domain.ErrUnmaskedClientFrame) {
+		t.Errorf("expected ErrUnmaskedClientFrame, got %v", err)
+	}
+}
+
+func TestConn_InvalidCloseReason(t *testing.T) {
+	var closeErr error
+	server, result := upgradeServer(t, func(c *Conn) error {
+		closeErr = c.Close(protocol.StatusNormalClosure, "bad\xffreason")
+		_, err := c.ReadMessage()
+		return err
+	})
+	conn, br := dialWebSocket(t, server)
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+
+	// A peer's close reason with invalid UTF-8 fails the connection with 1007
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodeClose, []byte{0x03, 0xE8, 0xC3, 0x28}))
+
+	frame, err := NewFrameParser(protocol.MaxPayloadSize).ReadFrame(br)
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if code, _ := frame.CloseCode(); code != protocol.StatusInvalidFramePayloadData {
+		t.Errorf("expected close code 1007, got %d", code)
+	}
+	if reason := string(frame.Payload[2:]); reason != "invalid UTF-8 in close reason" {
+		t.Errorf("expected a reason naming the close reason, got %q", reason)
+	}
+	if err := <-result; !errors.Is(err, domain.ErrInvalidCloseReason) {
+		t.Errorf("expected ErrInvalidCloseReason from ReadMessage, got %v", err)
+	}
+	if !errors.Is(closeErr, domain.ErrInvalidFramePayloadData) {
+		t.Errorf("expected Close to reject the invalid reason, got %v", closeErr)
+	}
+}
+
+func TestConn_OnProtocolError(t *testing.T) {
+	tests := []struct {
+		name   string
+		frame  *domain.Frame
+		mask   bool
+		err    error
+		opcode domain.Opcode
+	}{
+		{"unmasked frame", domain.NewFrame(domain.OpcodeText, []byte("bare")), false, domain.ErrUnmaskedClientFrame, 0},
+		{"reserved opcode", &domain.Frame{FIN: true, Opcode: 0x3}, true, domain.ErrInvalidOpcode, 0x3},
+		{"invalid UTF-8", domain.NewFrame(domain.OpcodeText, []byte{0xFF}), true, domain.ErrInvalidFramePayloadData, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			type report struct {
+				id  string
+				err error
+			}
+			reports := make(chan report, 2)
+			var id string
+			server, result := upgradeServer(t, func(c *Conn) error {
+				id = c.Connection().ID
+				c.OnProtocolError = func(conn *domain.Connection, err error) {
+					reports <- report{conn.ID, err}
+				}
+				_, err := c.ReadMessage()
+				return err
+			})
+			conn, br := dialWebSocket(t, server)
+
+			client := NewFrameParser(protocol.MaxPayloadSize)
+			if tt.mask {
+				client, _ = NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+			}
+			var wire bytes.Buffer
+			if tt.frame.Opcode.IsReserved() {
+				// WriteFrame refuses reserved opcodes, so build the frame by hand
+				wire.Write([]byte{0x83, 0x80, 0x00, 0x00, 0x00, 0x00})
+			} else if err := client.WriteFrame(&wire, tt.frame); err != nil {
+				t.Fatalf("WriteFrame failed: %v", err)
+			}
+			_, _ = conn.Write(wire.Bytes())
+
+			expectFrame(t, br, domain.OpcodeClose, domain.CloseFrameForError(tt.err).Payload)
+			<-result
+
+			select {
+			case r := <-reports:
+				if r.id != id || !errors.Is(r.err, tt.err) {
+					t.Errorf("expected %v on connection %s, got %v on %s", tt.err, id, r.err, r.id)
+				}
+				var frameErr *domain.FrameError
+				if tt.opcode != 0 && (!errors.As(r.err, &frameErr) || frameErr.Opcode != tt.opcode) {
+					t.Errorf("expected a FrameError for opcode 0x%X, got %v", byte(tt.opcode), r.err)
+				}
+			default:
+				t.Fatal("expected OnProtocolError to be called")
+			}
+			if len(reports) != 0 {
+				t.Error("expected a single report")
+			}
+		})
+	}
+}
+
+func TestConn_ReadMessageSequence(t *testing.T) {
+	messages := make(chan *domain.Message, 2)
+	server, result := upgradeServer(t, func(c *Conn) error {
+		for {
+			msg, err := c.ReadMessage()
+			if err != nil {
+				return err
+			}
+			messages <- msg
+		}
+	})
+	conn, br := dialWebSocket(t, server)
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+
+	// "héllo" split inside the two-byte é, with a ping between the fragments
+	text := []byte("héllo")
+	_ = client.WriteFrame(conn, fragment(domain.OpcodeText, string(text[:2]), false))
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodePing, []byte("are you there")))
+	_ = client.WriteFrame(conn, fragment(domain.OpcodeContinuation, string(text[2:4]), false))
+	_ = client.WriteFrame(conn, fragment(domain.OpcodeContinuation, string(text[4:]), true))
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodeBinary, []byte{0x00, 0xFF}))
+	expectFrame(t, br, domain.OpcodePong, []byte("are you there"))
+
+	for _, want := range []*domain.Message{domain.NewTextMessage(text), domain.NewBinaryMessage([]byte{0x00, 0xFF})} {
+		got := <-messages
+		if got.Type != want.Type || !bytes.Equal(got.Payload, want.Payload) {
+			t.Errorf("expected %s %q, got %s %q", want.Type, want.Payload, got.Type, got.Payload)
+		}
+	}
+
+	_ = client.WriteFrame(conn, newCloseFrame(t, protocol.StatusGoingAway, "bye"))
+	expectFrame(t, br, domain.OpcodeClose, []byte{0x03, 0xE9})
+	var closeErr *domain.CloseError
+	if err := <-result; !errors.As(err, &closeErr) || closeErr.Code != protocol.StatusGoingAway || closeErr.Reason != "bye" {
+		t.Errorf("expected CloseError 1001 \"bye\", got %v", err)
+	}
+}
+
+func TestConn_FramesKeepConnectionActive(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame *domain.Frame
+	}{
+		{"text", domain.NewFrame(domain.OpcodeText, []byte("busy"))},
+		{"ping", domain.NewFrame(domain.OpcodePing, []byte("busy"))},
+		{"pong", domain.NewFrame(domain.OpcodePong, []byte("busy"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewConnectionManager()
+			server, result := upgradeServer(t, func(c *Conn) error {
+				_ = manager.Add(c.Connection())
+				for {
+					if _, err := c.ReadMessage(); err != nil {
+						return err
+					}
+				}
+			})
+			conn, _ := dialWebSocket(t, server)
+			client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+
+			reap := func() int {
+				return manager.ReapIdle(50*time.Millisecond, func(*domain.Connection) error { return nil })
+			}
+
+			// A connection that keeps sending frames is never idle
+			for deadline := time.Now().Add(150 * time.Millisecond); time.Now().Before(deadline); {
+				_ = client.WriteFrame(conn, tt.frame)
+				time.Sleep(5 * time.Millisecond)
+			}
+			if n := reap(); n != 0 {
+				t.Errorf("expected the busy connection to survive, got %d reaped", n)
+			}
+
+			time.Sleep(100 * time.Millisecond)
+			if n := reap(); n != 1 {
+				t.Errorf("expected the connection to be reaped once quiet, got %d reaped", n)
+			}
+
+			conn.Close()
+			<-result
+		})
+	}
+}
+
+func TestConn_Keepalive(t *testing.T) {
+	keepalives := make(chan *domain.Keepalive, 1)
+	server, result := upgradeServer(t, func(c *Conn) error {
+		keepalive, err := c.StartKeepalive(domain.KeepaliveConfig{
+			Interval: 5 * time.Millisecond,
+			Timeout:  50 * time.Millisecond,
+		})
+		if err != nil {
+			keepalives <- nil
+			return err
+		}
+		defer keepalive.Stop()
+		keepalives <- keepalive
+		for {
+			if _, err := c.ReadMessage(); err != nil {
+				return err
+			}
+		}
+	})
+	conn, br := dialWebSocket(t, server)
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+	keepalive := <-keepalives
+	if keepalive == nil {
+		t.Fatalf("StartKeepalive failed: %v", <-result)
+	}
+
+	// Answer pings until told to stop, then let them go unanswered
+	var answering atomic.Bool
+	answering.Store(true)
+	var pings atomic.Int32
+	go func() {
+		for {
+			frame, err := client.ReadFrame(br)
+			if err != nil {
+				return
+			}
+			if frame.Opcode != domain.OpcodePing {
+				continue
+			}
+			pings.Add(1)
+			if answering.Load() {
+				_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodePong, frame.Payload))
+			}
+		}
+	}()
+
+	select {
+	case <-keepalive.Dead():
+		t.Fatal("a peer answering pings was declared dead")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if n := pings.Load(); n < 2 {
+		t.Errorf("expected repeated pings on a quiet connection, got %d", n)
+	}
+
+	answering.Store(false)
+	select {
+	case <-keepalive.Dead():
+	case <-time.After(time.Second):
+		t.Fatal("expected an unresponsive peer to be declared dead")
+	}
+
+	conn.Close()
+	<-result
+}
+
+func TestConn_ReadLimit(t *testing.T) {
+	server, result := upgradeServer(t, func(c *Conn) error {
+		c.SetReadLimit(8)
+		for {
+			if _, err := c.ReadMessage(); err != nil {
+				return err
+			}
+		}
+	})
+	conn, br := dialWebSocket(t, server)
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodeText, []byte("12345678")))
+	_ = client.WriteFrame(conn, fragment(domain.OpcodeBinary, "12345", false))
+	_ = client.WriteFrame(conn, fragment(domain.OpcodeContinuation, "6789", true))
+
+	frame, err := NewFrameParser(protocol.MaxPayloadSize).ReadFrame(br)
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if code, _ := frame.CloseCode(); code != protocol.StatusMessageTooBig {
+		t.Errorf("expected close code 1009, got %d", code)
+	}
+	if err := <-result; !errors.Is(err, domain.ErrPayloadTooLarge) {
+		t.Errorf("expected ErrPayloadTooLarge, got %v", err)
+	}
+}
+
+func TestConn_ConcurrentWritesDoNotInterleave(t *testing.T) {
+	const writers, perWriter = 8, 50
+	payload := strings.Repeat("x", 4096)
+
+	server, result := upgradeServer(t, func(c *Conn) error {
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < perWriter; j++ {
+					if i%2 == 0 {
+						_ = c.WriteMessage(domain.NewTextMessage([]byte(payload)))
+					} else {
+						_ = c.WritePing([]byte("ping"))
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		return nil
+	})
+	_, br := dialWebSocket(t, server)
+
+	// Every frame must parse cleanly, with the payload it was written with
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	for n := 0; n < writers*perWriter; n++ {
+		frame, err := parser.ReadFrame(br)
+		if err != nil {
+			t.Fatalf("frame %d: %v", n, err)
+		}
+		switch frame.Opcode {
+		case domain.OpcodeText:
+			if string(frame.Payload) != payload {
+				t.Fatalf("frame %d: corrupted text payload", n)
+			}
+		case domain.OpcodePing:
+			if string(frame.Payload) != "ping" {
+				t.Fatalf("frame %d: corrupted ping payload %q", n, frame.Payload)
+			}
+		default:
+			t.Fatalf("frame %d: unexpected %s frame", n, frame.Opcode)
+		}
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+}
+
+func TestConn_StatsCountWireBytes(t *testing.T) {
+	type stats struct{ read, written uint64 }
+	statsCh := make(chan stats, 1)
+	server, result := upgradeServer(t, func(c *Conn) error {
+		for i := 0; i < 2; i++ {
+			msg, err := c.ReadMessage()
+			if err != nil {
+				return err
+			}
+			if err := c.WriteMessage(msg); err != nil {
+				return err
+			}
+		}
+		read, written := c.Stats()
+		statsCh <- stats{read, written}
+		return nil
+	})
+	conn, br := dialWebSocket(t, server)
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+
+	// Masked client frames: a two-fragment text message, a ping and a 300-byte binary
+	// message whose length needs the 16-bit encoding
+	var sent bytes.Buffer
+	_ = client.WriteFrame(&sent, fragment(domain.OpcodeText, "hel", false))
+	_ = client.WriteFrame(&sent, domain.NewFrame(domain.OpcodePing, []byte("p")))
+	_ = client.WriteFrame(&sent, fragment(domain.OpcodeContinuation, "lo", true))
+	_ = client.WriteFrame(&sent, domain.NewFrame(domain.OpcodeBinary, make([]byte, 300)))
+	wantRead := uint64(sent.Len())
+	if _, err := conn.Write(sent.Bytes()); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// Unmasked server frames: the pong and the two echoes
+	serverParser := NewFrameParser(protocol.MaxPayloadSize)
+	var expected bytes.Buffer
+	_ = serverParser.WriteFrame(&expected, domain.NewFrame(domain.OpcodePong, []byte("p")))
+	_ = serverParser.WriteFrame(&expected, domain.NewFrame(domain.OpcodeText, []byte("hello")))
+	_ = serverParser.WriteFrame(&expected, domain.NewFrame(domain.OpcodeBinary, make([]byte, 300)))
+	wantWritten := uint64(expected.Len())
+
+	if err := <-result; err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+	got := <-statsCh
+	if got.read != wantRead || got.written != wantWritten {
+		t.Errorf("Stats() = (%d, %d), want (%d, %d)", got.read, got.written, wantRead, wantWritten)
+	}
+
+	received := make([]byte, wantWritten)
+	if _, err := io.ReadFull(br, received); err != nil {
+		t.Fatalf("reading the server frames failed: %v", err)
+	}
+	if !bytes.Equal(received, expected.Bytes()) {
+		t.Error("expected the counted bytes to be exactly the frames on the wire")
+	}
+}
+
+func TestConn_ForwardedRemoteAddr(t *testing.T) {
+	remoteAddr, err := ForwardedRemoteAddr("127.0.0.1")
+	if err != nil {
+		t.Fatalf("ForwardedRemoteAddr failed: %v", err)
+	}
+	addrs := make(chan string, 1)
+	validator := &HandshakeValidator{RemoteAddrFunc: remoteAddr}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := validator.Upgrade(w, req)
+		if err != nil {
+			addrs <- ""
+			return
+		}
+		addrs <- conn.Connection().RemoteAddr
+		conn.netConn.Close()
+	}))
+	t.Cleanup(server.Close)
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	request := strings.Replace(rawHandshakeRequest, "\r\n\r\n", "\r\nX-Forwarded-For: 198.51.100.4\r\n\r\n", 1)
+	if _, err := io.WriteString(conn, request); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	if got := <-addrs; got != "198.51.100.4" {
+		t.Errorf("expected the forwarded client address, got %q", got)
+	}
+}
+
+func TestConn_HandshakeResult(t *testing.T) {
+	results := make(chan *HandshakeResult, 1)
+	validator := &HandshakeValidator{Subprotocols: []string{"chat"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := validator.Upgrade(w, req)
+		if err != nil {
+			results <- nil
+			return
+		}
+		results <- conn.Handshake()
+		if conn.Subprotocol() != conn.Handshake().Subprotocol {
+			t.Errorf("Subprotocol() = %q disagrees with the handshake result", conn.Subprotocol())
+		}
+		_ = conn.Close(protocol.StatusNormalClosure, "")
+	}))
+	t.Cleanup(server.Close)
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	request := strings.Replace(rawHandshakeRequest, "GET /chat ", "GET /ws/notifications?user=ann ", 1)
+	request = strings.Replace(request, "\r\n\r\n", "\r\n"+protocol.HeaderSecWebSocketProtocol+": chat\r\n\r\n", 1)
+	if _, err := io.WriteString(conn, request); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	result := <-results
+	if result == nil {
+		t.Fatal("upgrade failed")
+	}
+	if result.Path != "/ws/notifications" || result.Query.Get("user") != "ann" || result.Subprotocol != "chat" {
+		t.Errorf("unexpected handshake result %+v", result)
+	}
+}
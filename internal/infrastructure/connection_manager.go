@@ -0,0 +1,424 @@
+package infrastructure
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+// BroadcastPolicy decides what a broadcast does with a connection whose send queue is full
+type BroadcastPolicy int
+
+const (
+	// BroadcastBlock waits for room in every queue, so one slow consumer delays the
+	// broadcast. It is the default.
+	BroadcastBlock BroadcastPolicy = iota
+	// BroadcastSkip skips a connection whose queue is full and reports domain.ErrSendQueueFull
+	BroadcastSkip
+	// BroadcastDropOldest discards the oldest queued messages of a full connection to make room
+	BroadcastDropOldest
+)
+
+// broadcastParser marshals broadcast messages once for every connection that writes
+// the default server wire format
+var broadcastParser = NewFrameParser(protocol.MaxPayloadSize)
+
+// ConnectionManager tracks live connections, the groups they are tagged with and
+// secondary indexes over selected metadata keys
+type ConnectionManager struct {
+	mu          sync.RWMutex
+	connections map[string]*domain.Connection
+	groups      map[string]map[string]*domain.Connection // group -> connection ID -> connection
+	memberships map[string]map[string]struct{}           // connection ID -> groups
+
+	indexes map[string]map[string]map[string]*domain.Connection // metadata key -> value -> connection ID -> connection
+	indexed map[string]map[string]string                        // connection ID -> metadata key -> indexed value
+	limits  map[string]int                                      // metadata key -> max connections per value
+
+	policy BroadcastPolicy
+}
+
+// NewConnectionManager creates an empty ConnectionManager
+func NewConnectionManager() *ConnectionManager {
+	return &ConnectionManager{
+		connections: make(map[string]*domain.Connection),
+		groups:      make(map[string]map[string]*domain.Connection),
+		memberships: make(map[string]map[string]struct{}),
+		indexes:     make(map[string]map[string]map[string]*domain.Connection),
+		indexed:     make(map[string]map[string]string),
+		limits:      make(map[string]int),
+	}
+}
+
+// Add registers a connection, replacing any existing connection with the same ID.
+// It returns ErrTooManyConnectionsForUser when the connection would exceed a limit set
+// with SetConnectionLimit, in which case nothing is registered or replaced.
+func (m *ConnectionManager) Add(conn *domain.Connection) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkLimitsLocked(conn); err != nil {
+		return err
+	}
+	if _, exists := m.connections[conn.ID]; exists {
+		m.removeLocked(conn.ID)
+	}
+	m.connections[conn.ID] = conn
+	m.indexLocked(conn)
+	return nil
+}
+
+// SetConnectionLimit caps how many connections may share the same string metadata value
+// under key, e.g. 5 connections per "user". The key is indexed as if by AddIndex. The
+// limit is checked when a connection is added; a limit of zero or less removes it.
+func (m *ConnectionManager) SetConnectionLimit(key string, limit int) {
+	if limit <= 0 {
+		m.mu.Lock()
+		delete(m.limits, key)
+		m.mu.Unlock()
+		return
+	}
+
+	m.AddIndex(key)
+	m.mu.Lock()
+	m.limits[key] = limit
+	m.mu.Unlock()
+}
+
+// checkLimitsLocked reports whether adding conn would exceed a per-key connection limit;
+// the caller must hold the write lock
+func (m *ConnectionManager) checkLimitsLocked(conn *domain.Connection) error {
+	for key, limit := range m.limits {
+		value, ok := conn.GetString(key)
+		if !ok {
+			continue
+		}
+		existing := m.indexes[key][value]
+		count := len(existing)
+		if _, replacing := existing[conn.ID]; replacing {
+			count--
+		}
+		if count >= limit {
+			return fmt.Errorf("%w: %s %q already has %d of %d connections",
+				domain.ErrTooManyConnectionsForUser, key, value, count, limit)
+		}
+	}
+	return nil
+}
+
+// Remove unregisters a connection and drops all of its group memberships
+func (m *ConnectionManager) Remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.removeLocked(id)
+}
+
+// removeLocked removes a connection; the caller must hold the write lock
+func (m *ConnectionManager) removeLocked(id string) {
+	for group := range m.memberships[id] {
+		m.untagLocked(id, group)
+	}
+	delete(m.memberships, id)
+	m.unindexLocked(id)
+	delete(m.connections, id)
+}
+
+// Get returns the connection with the given ID
+func (m *ConnectionManager) Get(id string) (*domain.Connection, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	conn, ok := m.connections[id]
+	return conn, ok
+}
+
+// Lookup returns the connection with the given ID, or ErrConnectionNotFound
+func (m *ConnectionManager) Lookup(id string) (*domain.Connection, error) {
+	conn, ok := m.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", domain.ErrConnectionNotFound, id)
+	}
+	return conn, nil
+}
+
+// Count returns the number of registered connections
+func (m *ConnectionManager) Count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.connections)
+}
+
+// Range calls fn for each registered connection until fn returns false. It iterates over
+// a snapshot taken under the read lock, so fn may call back into the manager.
+func (m *ConnectionManager) Range(fn func(*domain.Connection) bool) {
+	for _, conn := range m.snapshot() {
+		if !fn(conn) {
+			return
+		}
+	}
+}
+
+// ReapIdle removes every connection whose IdleDuration exceeds maxIdle and passes it to
+// closeFn, e.g. to run the closing handshake. It returns how many connections closeFn
+// closed without error; a failing closeFn does not stop the scan. It is meant to run on
+// a ticker and is safe to call concurrently with Add and Remove.
+func (m *ConnectionManager) ReapIdle(maxIdle time.Duration, closeFn func(*domain.Connection) error) int {
+	reaped := 0
+	for _, conn := range m.snapshot() {
+		if conn.IdleDuration() <= maxIdle || !m.removeIfCurrent(conn) {
+			continue
+		}
+		if err := closeFn(conn); err == nil {
+			reaped++
+		}
+	}
+	return reaped
+}
+
+// removeIfCurrent removes conn unless it was removed or replaced since the snapshot,
+// reporting whether it did
+func (m *ConnectionManager) removeIfCurrent(conn *domain.Connection) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.connections[conn.ID] != conn {
+		return false
+	}
+	m.removeLocked(conn.ID)
+	return true
+}
+
+// snapshot returns the registered connections without holding the lock afterwards
+func (m *ConnectionManager) snapshot() []*domain.Connection {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	conns := make([]*domain.Connection, 0, len(m.connections))
+	for _, conn := range m.connections {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Tag adds a registered connection to a group
+func (m *ConnectionManager) Tag(id, group string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conn, ok := m.connections[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", domain.ErrConnectionNotFound, id)
+	}
+
+	members, ok := m.groups[group]
+	if !ok {
+		members = make(map[string]*domain.Connection)
+		m.groups[group] = members
+	}
+	members[id] = conn
+
+	groups, ok := m.memberships[id]
+	if !ok {
+		groups = make(map[string]struct{})
+		m.memberships[id] = groups
+	}
+	groups[group] = struct{}{}
+
+	return nil
+}
+
+// Untag removes a connection from a group; it is a no-op if the connection is not a member
+func (m *ConnectionManager) Untag(id, group string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.untagLocked(id, group)
+}
+
+// untagLocked removes a group membership; the caller must hold the write lock
+func (m *ConnectionManager) untagLocked(id, group string) {
+	if members, ok := m.groups[group]; ok {
+		delete(members, id)
+		if len(members) == 0 {
+			delete(m.groups, group)
+		}
+	}
+	if groups, ok := m.memberships[id]; ok {
+		delete(groups, group)
+		if len(groups) == 0 {
+			delete(m.memberships, id)
+		}
+	}
+}
+
+// SetBroadcastPolicy sets how broadcasts treat connections with a full send queue, by
+// default BroadcastBlock. The policy only applies to ConnectionWriter senders; others are always called directly.
+func (m *ConnectionManager) SetBroadcastPolicy(policy BroadcastPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.policy = policy
+}
+
+// Broadcast sends a message to every open connection, skipping connections in any other
+// state. Each connection is passed to write; a nil write uses the connection's sender.
+// Delivery continues past individual failures, which are returned one per connection.
+//
+// With a nil write the message is serialized once and the same bytes are queued on every
+// ConnectionWriter whose parser needs no per-connection transformation such as masking.
+func (m *ConnectionManager) Broadcast(msg *domain.Message, write func(*domain.Connection, *domain.Message) error) []error {
+	// Snapshot connections so slow sends don't hold the lock
+	conns := m.snapshot()
+
+	m.mu.RLock()
+	policy := m.policy
+	m.mu.RUnlock()
+
+	return deliver(conns, msg, policy, write)
+}
+
+// BroadcastToGroup sends a message to every open connection tagged with the group.
+// Delivery continues past individual failures; the returned error joins all of them.
+func (m *ConnectionManager) BroadcastToGroup(group string, msg *domain.Message) error {
+	// Snapshot members so slow sends don't hold the lock
+	m.mu.RLock()
+	members := make([]*domain.Connection, 0, len(m.groups[group]))
+	for _, conn := range m.groups[group] {
+		members = append(members, conn)
+	}
+	policy := m.policy
+	m.mu.RUnlock()
+
+	return errors.Join(deliver(members, msg, policy, nil)...)
+}
+
+// deliver sends msg to each open connection, through write when set or according to
+// policy otherwise, and returns the failures
+func deliver(conns []*domain.Connection, msg *domain.Message, policy BroadcastPolicy, write func(*domain.Connection, *domain.Message) error) []error {
+	var data []byte
+	if write == nil {
+		var err error
+		if data, err = broadcastParser.MarshalMessage(msg); err != nil {
+			return []error{err}
+		}
+	}
+
+	var errs []error
+	for _, conn := range conns {
+		if !conn.IsOpen() {
+			continue
+		}
+
+		var err error
+		if write != nil {
+			err = write(conn, msg)
+		} else {
+			err = sendWithPolicy(conn, msg, data, policy)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("connection %s: %w", conn.ID, err))
+		}
+	}
+	return errs
+}
+
+// sendWithPolicy sends to a single connection, handing ConnectionWriters the shared
+// marshaled bytes and avoiding a block on full queues unless policy is BroadcastBlock.
+// Like Connection.Send, it refuses connections that are draining.
+func sendWithPolicy(conn *domain.Connection, msg *domain.Message, data []byte, policy BroadcastPolicy) error {
+	writer, ok := conn.Sender().(*ConnectionWriter)
+	if !ok {
+		return conn.Send(msg)
+	}
+	if conn.IsDraining() {
+		return domain.ErrDraining
+	}
+	return writer.submit(writeRequest{msg: msg, data: data}, policy)
+}
+
+// AddIndex maintains a secondary index over the string metadata value stored under key,
+// so ConnectionsByKey can answer lookups such as "all connections of user X" without a
+// full scan. Existing connections are indexed immediately.
+func (m *ConnectionManager) AddIndex(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.indexes[key]; exists {
+		return
+	}
+	m.indexes[key] = make(map[string]map[string]*domain.Connection)
+	for _, conn := range m.connections {
+		m.indexLocked(conn)
+	}
+}
+
+// Reindex refreshes the index entries of a connection after its metadata changed
+func (m *ConnectionManager) Reindex(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conn, ok := m.connections[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", domain.ErrConnectionNotFound, id)
+	}
+	m.unindexLocked(id)
+	m.indexLocked(conn)
+	return nil
+}
+
+// ConnectionsByKey returns the connections whose metadata value for key equals value.
+// The key must have been registered with AddIndex; unindexed keys match nothing.
+func (m *ConnectionManager) ConnectionsByKey(key, value string) []*domain.Connection {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matches := m.indexes[key][value]
+	if len(matches) == 0 {
+		return nil
+	}
+	conns := make([]*domain.Connection, 0, len(matches))
+	for _, conn := range matches {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// indexLocked adds a connection to every registered index; the caller must hold the write lock
+func (m *ConnectionManager) indexLocked(conn *domain.Connection) {
+	for key, values := range m.indexes {
+		value, ok := conn.GetString(key)
+		if !ok {
+			continue
+		}
+		ids, ok := values[value]
+		if !ok {
+			ids = make(map[string]*domain.Connection)
+			values[value] = ids
+		}
+		ids[conn.ID] = conn
+
+		entries, ok := m.indexed[conn.ID]
+		if !ok {
+			entries = make(map[string]string)
+			m.indexed[conn.ID] = entries
+		}
+		entries[key] = value
+	}
+}
+
+// unindexLocked removes a connection from every index; the caller must hold the write lock
+func (m *ConnectionManager) unindexLocked(id string) {
+	for key, value := range m.indexed[id] {
+		values := m.indexes[key]
+		delete(values[value], id)
+		if len(values[value]) == 0 {
+			delete(values, value)
+		}
+	}
+	delete(m.indexed, id)
+}
@@ -0,0 +1,681 @@
+package infrastructure
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+// recordingSender captures every message sent through it
+type recordingSender struct {
+	mu       sync.Mutex
+	messages []*domain.Message
+	err      error
+}
+
+func (s *recordingSender) SendMessage(msg *domain.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func (s *recordingSender) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.messages)
+}
+
+// newOpenConnection creates an open connection backed by a recording sender
+func newOpenConnection(t *testing.T, id string) (*domain.Connection, *recordingSender) {
+	t.Helper()
+	conn := domain.NewConnection(id, "127.0.0.1:0")
+	if err := conn.TransitionTo(domain.StateOpen); err != nil {
+		t.Fatalf("failed to open connection: %v", err)
+	}
+	sender := &recordingSender{}
+	conn.SetSender(sender)
+	return conn, sender
+}
+
+func TestConnectionManager_BroadcastToGroup(t *testing.T) {
+	manager := NewConnectionManager()
+
+	alice, aliceSender := newOpenConnection(t, "alice")
+	bob, bobSender := newOpenConnection(t, "bob")
+	carol, carolSender := newOpenConnection(t, "carol")
+	manager.Add(alice)
+	manager.Add(bob)
+	manager.Add(carol)
+
+	if err := manager.Tag("alice", "sports"); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+	if err := manager.Tag("bob", "sports"); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+	if err := manager.Tag("carol", "news"); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+
+	msg := domain.NewTextMessage([]byte("goal!"))
+	if err := manager.BroadcastToGroup("sports", msg); err != nil {
+		t.Fatalf("BroadcastToGroup failed: %v", err)
+	}
+
+	if aliceSender.count() != 1 || bobSender.count() != 1 {
+		t.Errorf("expected sports members to receive 1 message, got alice=%d bob=%d", aliceSender.count(), bobSender.count())
+	}
+	if carolSender.count() != 0 {
+		t.Errorf("expected news member to receive nothing, got %d", carolSender.count())
+	}
+}
+
+func TestConnectionManager_UntagAndRemoveCleanGroups(t *testing.T) {
+	manager := NewConnectionManager()
+
+	alice, aliceSender := newOpenConnection(t, "alice")
+	bob, bobSender := newOpenConnection(t, "bob")
+	manager.Add(alice)
+	manager.Add(bob)
+
+	for _, group := range []string{"a", "b"} {
+		if err := manager.Tag("alice", group); err != nil {
+			t.Fatalf("Tag failed: %v", err)
+		}
+	}
+	if err := manager.Tag("bob", "a"); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+
+	manager.Untag("bob", "a")
+	manager.Remove("alice")
+
+	msg := domain.NewTextMessage([]byte("hello"))
+	for _, group := range []string{"a", "b"} {
+		if err := manager.BroadcastToGroup(group, msg); err != nil {
+			t.Fatalf("BroadcastToGroup(%s) failed: %v", group, err)
+		}
+	}
+
+	if aliceSender.count() != 0 || bobSender.count() != 0 {
+		t.Errorf("expected no deliveries after untag/remove, got alice=%d bob=%d", aliceSender.count(), bobSender.count())
+	}
+	if len(manager.groups) != 0 || len(manager.memberships) != 0 {
+		t.Errorf("expected group index to be empty, got groups=%v memberships=%v", manager.groups, manager.memberships)
+	}
+}
+
+func TestConnectionManager_TagUnknownConnection(t *testing.T) {
+	manager := NewConnectionManager()
+
+	err := manager.Tag("missing", "group")
+	if !errors.Is(err, domain.ErrConnectionNotFound) {
+		t.Errorf("expected ErrConnectionNotFound, got %v", err)
+	}
+}
+
+func TestConnectionManager_BroadcastToGroupCollectsErrors(t *testing.T) {
+	manager := NewConnectionManager()
+
+	ok, okSender := newOpenConnection(t, "ok")
+	broken, brokenSender := newOpenConnection(t, "broken")
+	brokenSender.err = domain.ErrConnectionClosed
+	manager.Add(ok)
+	manager.Add(broken)
+	_ = manager.Tag("ok", "room")
+	_ = manager.Tag("broken", "room")
+
+	err := manager.BroadcastToGroup("room", domain.NewBinaryMessage([]byte{0x01}))
+	if !errors.Is(err, domain.ErrConnectionClosed) {
+		t.Errorf("expected joined error to contain ErrConnectionClosed, got %v", err)
+	}
+	if okSender.count() != 1 {
+		t.Errorf("expected healthy connection to still receive the message, got %d", okSender.count())
+	}
+}
+
+func TestConnectionManager_ConnectionsByKey(t *testing.T) {
+	manager := NewConnectionManager()
+	manager.AddIndex("user")
+
+	phone, _ := newOpenConnection(t, "phone")
+	phone.Metadata["user"] = "u-42"
+	laptop, _ := newOpenConnection(t, "laptop")
+	laptop.Metadata["user"] = "u-42"
+	other, _ := newOpenConnection(t, "other")
+	other.Metadata["user"] = "u-7"
+	anonymous, _ := newOpenConnection(t, "anonymous")
+
+	for _, conn := range []*domain.Connection{phone, laptop, other, anonymous} {
+		manager.Add(conn)
+	}
+
+	conns := manager.ConnectionsByKey("user", "u-42")
+	if len(conns) != 2 {
+		t.Fatalf("Expected 2 connections for u-42, got %d", len(conns))
+	}
+	ids := map[string]bool{conns[0].ID: true, conns[1].ID: true}
+	if !ids["phone"] || !ids["laptop"] {
+		t.Errorf("Expected phone and laptop, got %v", ids)
+	}
+
+	manager.Remove("phone")
+	conns = manager.ConnectionsByKey("user", "u-42")
+	if len(conns) != 1 || conns[0].ID != "laptop" {
+		t.Errorf("Expected only laptop after removal, got %v", conns)
+	}
+
+	// Metadata changes are picked up on Reindex
+	laptop.Metadata["user"] = "u-7"
+	if err := manager.Reindex("laptop"); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	if conns := manager.ConnectionsByKey("user", "u-42"); len(conns) != 0 {
+		t.Errorf("Expected no connections for u-42 after reindex, got %d", len(conns))
+	}
+	if conns := manager.ConnectionsByKey("user", "u-7"); len(conns) != 2 {
+		t.Errorf("Expected 2 connections for u-7 after reindex, got %d", len(conns))
+	}
+}
+
+func TestConnectionManager_AddIndexCoversExistingConnections(t *testing.T) {
+	manager := NewConnectionManager()
+
+	conn, _ := newOpenConnection(t, "c1")
+	conn.Metadata["tenant"] = "acme"
+	manager.Add(conn)
+
+	if conns := manager.ConnectionsByKey("tenant", "acme"); conns != nil {
+		t.Errorf("Expected no results before the key is indexed, got %v", conns)
+	}
+
+	manager.AddIndex("tenant")
+	if conns := manager.ConnectionsByKey("tenant", "acme"); len(conns) != 1 {
+		t.Errorf("Expected existing connection to be indexed, got %v", conns)
+	}
+}
+
+func TestConnectionManager_BroadcastBlocksByDefault(t *testing.T) {
+	manager := NewConnectionManager()
+
+	// The slow connection's transport holds its write, so its queue fills up
+	out := newGatedWriter()
+	writer := NewConnectionWriter(out, NewFrameParser(protocol.MaxPayloadSize), 1)
+	defer writer.Close()
+
+	slow, _ := newOpenConnection(t, "slow")
+	slow.SetSender(writer)
+	manager.Add(slow)
+	if err := manager.Tag("slow", "room"); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("in-flight")))
+	<-out.entered
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("queued")))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- manager.BroadcastToGroup("room", domain.NewTextMessage([]byte("news")))
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected the broadcast to wait for room, it returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(out.release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected the message to be delivered once room freed up, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("broadcast did not complete after the queue drained")
+	}
+}
+
+func TestConnectionManager_BroadcastSkipsStuckConnections(t *testing.T) {
+	manager := NewConnectionManager()
+	manager.SetBroadcastPolicy(BroadcastSkip)
+
+	fast, fastSender := newOpenConnection(t, "fast")
+	manager.Add(fast)
+
+	// The stuck connection's transport never completes a write, so its queue fills up
+	out := newGatedWriter()
+	writer := NewConnectionWriter(out, NewFrameParser(protocol.MaxPayloadSize), 1)
+	defer writer.Close()
+	defer close(out.release)
+
+	stuck, _ := newOpenConnection(t, "stuck")
+	stuck.SetSender(writer)
+	manager.Add(stuck)
+
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("in-flight")))
+	<-out.entered
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("queued")))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- errors.Join(manager.Broadcast(domain.NewTextMessage([]byte("news")), nil)...)
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, domain.ErrSendQueueFull) {
+			t.Errorf("expected stuck connection to be reported, got %v", err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "connection stuck") {
+			t.Errorf("expected error to name the stuck connection, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("broadcast blocked on a stuck connection")
+	}
+
+	if fastSender.count() != 1 {
+		t.Errorf("expected fast connection to receive the message, got %d", fastSender.count())
+	}
+}
+
+func TestConnectionManager_BroadcastSkipsDrainingConnections(t *testing.T) {
+	manager := NewConnectionManager()
+
+	open, openSender := newOpenConnection(t, "open")
+	manager.Add(open)
+
+	// Hold the draining connection's in-flight write so Drain stays in progress
+	out := newGatedWriter()
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	writer := NewConnectionWriter(out, parser, 8)
+	defer writer.Close()
+
+	draining, _ := newOpenConnection(t, "draining")
+	draining.SetSender(writer)
+	manager.Add(draining)
+	for _, id := range []string{"open", "draining"} {
+		if err := manager.Tag(id, "room"); err != nil {
+			t.Fatalf("Tag failed: %v", err)
+		}
+	}
+
+	_ = draining.Send(domain.NewTextMessage([]byte("in-flight")))
+	<-out.entered
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- draining.Drain()
+	}()
+	for !draining.IsDraining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	tests := []struct {
+		name      string
+		broadcast func() error
+	}{
+		{"Broadcast", func() error {
+			return errors.Join(manager.Broadcast(domain.NewTextMessage([]byte("news")), nil)...)
+		}},
+		{"BroadcastToGroup", func() error {
+			return manager.BroadcastToGroup("room", domain.NewTextMessage([]byte("news")))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.broadcast()
+			if !errors.Is(err, domain.ErrDraining) {
+				t.Errorf("expected ErrDraining for the draining connection, got %v", err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "connection draining") {
+				t.Errorf("expected error to name the draining connection, got %v", err)
+			}
+		})
+	}
+	if openSender.count() != len(tests) {
+		t.Errorf("expected open connection to receive %d messages, got %d", len(tests), openSender.count())
+	}
+
+	close(out.release)
+	if err := <-drained; err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+
+	for out.buf.Len() > 0 {
+		frame, err := parser.ReadFrame(&out.buf)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if string(frame.Payload) == "news" {
+			t.Error("expected no broadcast to be written to the draining connection")
+		}
+	}
+}
+
+func TestConnectionManager_BroadcastSharedBytesRespectTransforms(t *testing.T) {
+	manager := NewConnectionManager()
+	manager.SetBroadcastPolicy(BroadcastBlock)
+
+	serverOut, clientOut := &bytes.Buffer{}, &bytes.Buffer{}
+	clientParser, err := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+	if err != nil {
+		t.Fatalf("NewFrameParserWithConfig failed: %v", err)
+	}
+	serverWriter := NewConnectionWriter(serverOut, NewFrameParser(protocol.MaxPayloadSize), 4)
+	clientWriter := NewConnectionWriter(clientOut, clientParser, 4)
+
+	server, _ := newOpenConnection(t, "server")
+	server.SetSender(serverWriter)
+	client, _ := newOpenConnection(t, "client")
+	client.SetSender(clientWriter)
+	manager.Add(server)
+	manager.Add(client)
+
+	msg := domain.NewTextMessage([]byte("shared"))
+	if errs := manager.Broadcast(msg, nil); len(errs) > 0 {
+		t.Fatalf("Broadcast failed: %v", errs)
+	}
+	_ = serverWriter.Flush()
+	_ = clientWriter.Flush()
+	_ = serverWriter.Close()
+	_ = clientWriter.Close()
+
+	expected, _ := NewFrameParser(protocol.MaxPayloadSize).MarshalMessage(msg)
+	if !bytes.Equal(serverOut.Bytes(), expected) {
+		t.Errorf("expected server connection to receive the shared bytes %x, got %x", expected, serverOut.Bytes())
+	}
+
+	// The client-role writer must still mask with its own key
+	frame, err := NewFrameParser(protocol.MaxPayloadSize).ReadFrame(clientOut)
+	if err != nil {
+		t.Fatalf("failed to read client frame: %v", err)
+	}
+	if !frame.Masked || string(frame.Payload) != "shared" {
+		t.Errorf("expected a masked frame carrying the message, got masked=%v payload=%q", frame.Masked, frame.Payload)
+	}
+}
+
+// benchmarkBroadcast broadcasts to 10k connections whose writers use the given parser
+func benchmarkBroadcast(b *testing.B, parser *FrameParser) {
+	const connections = 10000
+
+	manager := NewConnectionManager()
+	manager.SetBroadcastPolicy(BroadcastBlock)
+	writers := make([]*ConnectionWriter, 0, connections)
+	for i := 0; i < connections; i++ {
+		writer := NewConnectionWriter(io.Discard, parser, 64)
+		writers = append(writers, writer)
+
+		conn := domain.NewConnection(fmt.Sprintf("conn-%d", i), "127.0.0.1:0")
+		_ = conn.TransitionTo(domain.StateOpen)
+		conn.SetSender(writer)
+		manager.Add(conn)
+	}
+	defer func() {
+		for _, writer := range writers {
+			_ = writer.Close()
+		}
+	}()
+
+	msg := domain.NewTextMessage(bytes.Repeat([]byte("x"), 512))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if errs := manager.Broadcast(msg, nil); len(errs) > 0 {
+			b.Fatal(errs)
+		}
+		for _, writer := range writers {
+			_ = writer.Flush()
+		}
+	}
+}
+
+func BenchmarkConnectionManager_Broadcast10k(b *testing.B) {
+	b.Run("marshal-once", func(b *testing.B) {
+		benchmarkBroadcast(b, NewFrameParser(protocol.MaxPayloadSize))
+	})
+	b.Run("per-connection", func(b *testing.B) {
+		// A ValidateFrame hook forces every writer to serialize the message itself
+		parser := NewFrameParser(protocol.MaxPayloadSize)
+		parser.ValidateFrame = func(*domain.Frame) error { return nil }
+		benchmarkBroadcast(b, parser)
+	})
+}
+
+func TestConnectionManager_PerUserConnectionLimit(t *testing.T) {
+	manager := NewConnectionManager()
+	manager.SetConnectionLimit("user", 2)
+
+	newUserConnection := func(id, user string) *domain.Connection {
+		conn, _ := newOpenConnection(t, id)
+		conn.Metadata["user"] = user
+		return conn
+	}
+
+	for _, id := range []string{"phone", "laptop"} {
+		if err := manager.Add(newUserConnection(id, "u-42")); err != nil {
+			t.Fatalf("Add %s failed: %v", id, err)
+		}
+	}
+
+	err := manager.Add(newUserConnection("tablet", "u-42"))
+	if !errors.Is(err, domain.ErrTooManyConnectionsForUser) {
+		t.Fatalf("expected ErrTooManyConnectionsForUser, got %v", err)
+	}
+	if _, ok := manager.Get("tablet"); ok {
+		t.Error("rejected connection must not be registered")
+	}
+
+	if err := manager.Add(newUserConnection("desktop", "u-7")); err != nil {
+		t.Errorf("expected a different user to connect, got %v", err)
+	}
+
+	// Re-adding an existing connection replaces it rather than counting twice
+	if err := manager.Add(newUserConnection("phone", "u-42")); err != nil {
+		t.Errorf("expected replacing a connection to stay within the limit, got %v", err)
+	}
+
+	// Freeing a slot lets the user connect again
+	manager.Remove("laptop")
+	if err := manager.Add(newUserConnection("tablet", "u-42")); err != nil {
+		t.Errorf("expected connection after a slot was freed, got %v", err)
+	}
+}
+
+func TestConnectionManager_CountRangeLookup(t *testing.T) {
+	manager := NewConnectionManager()
+	for _, id := range []string{"a", "b", "c"} {
+		conn, _ := newOpenConnection(t, id)
+		_ = manager.Add(conn)
+	}
+
+	if manager.Count() != 3 {
+		t.Errorf("expected 3 connections, got %d", manager.Count())
+	}
+
+	seen := make(map[string]bool)
+	manager.Range(func(conn *domain.Connection) bool {
+		seen[conn.ID] = true
+		// Callbacks may modify the manager without deadlocking
+		manager.Remove(conn.ID)
+		return true
+	})
+	if len(seen) != 3 || manager.Count() != 0 {
+		t.Errorf("expected to visit and remove all connections, visited %v, %d left", seen, manager.Count())
+	}
+
+	for _, id := range []string{"d", "e"} {
+		conn, _ := newOpenConnection(t, id)
+		_ = manager.Add(conn)
+	}
+	visits := 0
+	manager.Range(func(*domain.Connection) bool {
+		visits++
+		return false
+	})
+	if visits != 1 {
+		t.Errorf("expected Range to stop after the callback returned false, got %d visits", visits)
+	}
+
+	if conn, err := manager.Lookup("d"); err != nil || conn.ID != "d" {
+		t.Errorf("expected to find connection d, got %v, %v", conn, err)
+	}
+	if _, err := manager.Lookup("missing"); !errors.Is(err, domain.ErrConnectionNotFound) {
+		t.Errorf("expected ErrConnectionNotFound, got %v", err)
+	}
+}
+
+func TestConnectionManager_ReapIdle(t *testing.T) {
+	manager := NewConnectionManager()
+	senders := make(map[string]*recordingSender)
+	for _, id := range []string{"idle", "stuck", "active"} {
+		conn, sender := newOpenConnection(t, id)
+		if id != "active" {
+			conn.LastActivity = time.Now().Add(-time.Minute)
+		}
+		senders[id] = sender
+		_ = manager.Add(conn)
+	}
+	_ = manager.Tag("idle", "room")
+
+	var closed []string
+	closeFn := func(conn *domain.Connection) error {
+		closed = append(closed, conn.ID)
+		if conn.ID == "stuck" {
+			return errors.New("close failed")
+		}
+		return nil
+	}
+
+	if n := manager.ReapIdle(30*time.Second, closeFn); n != 1 {
+		t.Errorf("expected 1 connection reaped, got %d", n)
+	}
+	if len(closed) != 2 {
+		t.Errorf("expected closeFn called for both idle connections, got %v", closed)
+	}
+	if manager.Count() != 1 {
+		t.Errorf("expected only the active connection left, got %d", manager.Count())
+	}
+	if _, ok := manager.Get("active"); !ok {
+		t.Error("expected the active connection to survive")
+	}
+	_ = manager.BroadcastToGroup("room", domain.NewTextMessage([]byte("hi")))
+	if senders["idle"].count() != 0 {
+		t.Error("expected the reaped connection to leave its groups")
+	}
+
+	// Nothing left to reap on the next tick
+	if n := manager.ReapIdle(30*time.Second, closeFn); n != 0 || len(closed) != 2 {
+		t.Errorf("expected nothing reaped twice, got %d (closed %v)", n, closed)
+	}
+}
+
+func TestConnectionManager_ReapIdleConcurrentWithAdd(t *testing.T) {
+	manager := NewConnectionManager()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				conn := domain.NewConnection(fmt.Sprintf("w%d-%d", worker, j), "127.0.0.1:0")
+				conn.LastActivity = time.Now().Add(-time.Hour)
+				_ = manager.Add(conn)
+				if j%2 == 0 {
+					manager.Remove(conn.ID)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				manager.ReapIdle(time.Minute, func(*domain.Connection) error { return nil })
+			}
+		}()
+	}
+	wg.Wait()
+
+	manager.ReapIdle(time.Minute, func(*domain.Connection) error { return nil })
+	if manager.Count() != 0 {
+		t.Errorf("expected every idle connection reaped, %d left", manager.Count())
+	}
+}
+
+func TestConnectionManager_ConcurrentAccess(t *testing.T) {
+	manager := NewConnectionManager()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				id := fmt.Sprintf("w%d-%d", worker, j)
+				conn := domain.NewConnection(id, "127.0.0.1:0")
+				_ = manager.Add(conn)
+				manager.Get(id)
+				manager.Count()
+				manager.Range(func(*domain.Connection) bool { return true })
+				if j%2 == 0 {
+					manager.Remove(id)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if manager.Count() != 8*25 {
+		t.Errorf("expected %d connections, got %d", 8*25, manager.Count())
+	}
+}
+
+func TestConnectionManager_BroadcastWithWriteCallback(t *testing.T) {
+	manager := NewConnectionManager()
+
+	for _, id := range []string{"ok-1", "ok-2", "failing"} {
+		conn, _ := newOpenConnection(t, id)
+		_ = manager.Add(conn)
+	}
+	connecting := domain.NewConnection("connecting", "127.0.0.1:0")
+	_ = manager.Add(connecting)
+	closed, _ := newOpenConnection(t, "closed")
+	_ = closed.TransitionTo(domain.StateClosed)
+	_ = manager.Add(closed)
+
+	var mu sync.Mutex
+	written := make(map[string]bool)
+	errs := manager.Broadcast(domain.NewTextMessage([]byte("notice")), func(conn *domain.Connection, msg *domain.Message) error {
+		if conn.ID == "failing" {
+			return io.ErrClosedPipe
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		written[conn.ID] = true
+		return nil
+	})
+
+	if len(errs) != 1 || !errors.Is(errs[0], io.ErrClosedPipe) || !strings.Contains(errs[0].Error(), "failing") {
+		t.Errorf("expected a single error for the failing connection, got %v", errs)
+	}
+	if !written["ok-1"] || !written["ok-2"] {
+		t.Errorf("expected both healthy connections to be written, got %v", written)
+	}
+	if written["connecting"] || written["closed"] {
+		t.Errorf("expected connections that are not open to be skipped, got %v", written)
+	}
+}
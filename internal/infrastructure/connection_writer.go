@@ -0,0 +1,340 @@
+package infrastructure
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+
+	"websocket-server/internal/domain"
+)
+
+// Priority orders messages waiting in a ConnectionWriter queue
+type Priority int
+
+const (
+	// PriorityNormal messages are written in FIFO order
+	PriorityNormal Priority = iota
+	// PriorityHigh messages are written before any waiting normal-priority message
+	PriorityHigh
+)
+
+// writeRequest is a single entry in a ConnectionWriter queue
+type writeRequest struct {
+	msg   *domain.Message
+	data  []byte        // msg already marshaled by a default server parser, may be nil
+	frame *domain.Frame // set for a single control frame, which carries no message
+	flush chan error    // set for flush barriers, which carry no message
+}
+
+// ConnectionWriter queues outbound messages and writes them to the transport from a
+// dedicated goroutine. Frames are batched in a bufio.Writer that is flushed whenever
+// the queue runs empty, so bursts of small messages share a single socket write.
+//
+// High-priority messages jump ahead of waiting normal-priority ones. Each message is
+// written in full before the next is picked, so priority never splits a fragmented message.
+// Once SendClose has queued a close frame no message of either priority is accepted, so
+// the close is always the last frame written.
+//
+// The queue applies backpressure: Enqueue fails with ErrSendQueueFull instead of waiting
+// behind a slow client, so a broadcaster can drop that client and move on. Use
+// EnqueueWait where the caller should wait for room instead.
+type ConnectionWriter struct {
+	parser   *FrameParser
+	writer   *bufio.Writer
+	queue    chan writeRequest // normal priority
+	priority chan writeRequest // high priority
+	done     chan struct{}     // closed by Close to stop the writer goroutine
+	stopped  chan struct{}     // closed when the writer goroutine exits
+
+	closeOnce   sync.Once
+	mu          sync.Mutex
+	err         error // first write error, returned by every later call
+	closeQueued bool  // A close frame is queued; later messages are rejected
+
+	closeWritten bool // Set by the writer goroutine once the close frame is written
+}
+
+// NewConnectionWriter creates a ConnectionWriter that serializes messages with parser and
+// writes them to w. At most capacity messages of each priority may be waiting.
+func NewConnectionWriter(w io.Writer, parser *FrameParser, capacity int) *ConnectionWriter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	cw := &ConnectionWriter{
+		parser:   parser,
+		writer:   bufio.NewWriter(w),
+		queue:    make(chan writeRequest, capacity),
+		priority: make(chan writeRequest, capacity),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go cw.run()
+	return cw
+}
+
+// Enqueue adds a normal-priority message to the send queue without blocking, returning
+// domain.ErrSendQueueFull when the queue has no room
+func (cw *ConnectionWriter) Enqueue(msg *domain.Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	return cw.submit(writeRequest{msg: msg}, BroadcastSkip)
+}
+
+// EnqueueWait adds a normal-priority message to the send queue, blocking while the queue
+// is full
+func (cw *ConnectionWriter) EnqueueWait(msg *domain.Message) error {
+	return cw.EnqueueWithPriority(msg, PriorityNormal)
+}
+
+// EnqueueWithPriority adds a message to the queue for the given priority, blocking while
+// that queue is full
+func (cw *ConnectionWriter) EnqueueWithPriority(msg *domain.Message, priority Priority) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	if priority == PriorityHigh {
+		return cw.enqueue(cw.priority, writeRequest{msg: msg})
+	}
+	return cw.enqueue(cw.queue, writeRequest{msg: msg})
+}
+
+// EnqueueDropOldest adds a normal-priority message without blocking, discarding the
+// oldest waiting messages to make room when the queue is full
+func (cw *ConnectionWriter) EnqueueDropOldest(msg *domain.Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	return cw.submit(writeRequest{msg: msg}, BroadcastDropOldest)
+}
+
+// SendMessage implements domain.MessageSender by enqueueing the message, waiting for room
+// so Connection.Send never silently loses data
+func (cw *ConnectionWriter) SendMessage(msg *domain.Message) error {
+	return cw.EnqueueWait(msg)
+}
+
+// SendClose queues a close frame behind the messages already waiting, so a graceful
+// close never cuts off data the application has handed over. Messages enqueued
+// afterwards, whatever their priority, fail with domain.ErrConnectionClosed, and one that
+// races SendClose into the queue behind the close frame is discarded rather than written
+// after it.
+func (cw *ConnectionWriter) SendClose(frame *domain.Frame) error {
+	if frame.Opcode != domain.OpcodeClose {
+		return fmt.Errorf("%w: SendClose requires a close frame, got %s", domain.ErrInvalidOpcode, frame.Opcode)
+	}
+	if err := frame.Validate(); err != nil {
+		return err
+	}
+
+	if err := cw.enqueue(cw.queue, writeRequest{frame: frame}); err != nil {
+		return err
+	}
+
+	cw.mu.Lock()
+	cw.closeQueued = true
+	cw.mu.Unlock()
+	return nil
+}
+
+// Flush blocks until every message enqueued before the call has been written and the
+// buffered bytes have been handed to the underlying writer.
+func (cw *ConnectionWriter) Flush() error {
+	result := make(chan error, 1)
+	if err := cw.enqueue(cw.queue, writeRequest{flush: result}); err != nil {
+		return err
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-cw.stopped:
+		return domain.ErrConnectionClosed
+	}
+}
+
+// Close stops the writer goroutine and flushes any bytes already buffered.
+// Messages still waiting in the queue are discarded.
+func (cw *ConnectionWriter) Close() error {
+	cw.closeOnce.Do(func() {
+		close(cw.done)
+	})
+	<-cw.stopped
+
+	if err := cw.writer.Flush(); err != nil {
+		cw.setErr(err)
+	}
+	return cw.Err()
+}
+
+// Err returns the first error encountered while writing, if any
+func (cw *ConnectionWriter) Err() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.err
+}
+
+// checkOpen reports the sticky write error, or ErrConnectionClosed once Close was called
+func (cw *ConnectionWriter) checkOpen() error {
+	if err := cw.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-cw.done:
+		return domain.ErrConnectionClosed
+	default:
+		return nil
+	}
+}
+
+// checkMessage is checkOpen for messages, which are also refused once a close frame is
+// queued
+func (cw *ConnectionWriter) checkMessage() error {
+	cw.mu.Lock()
+	closeQueued := cw.closeQueued
+	cw.mu.Unlock()
+	if closeQueued {
+		return domain.ErrConnectionClosed
+	}
+	return cw.checkOpen()
+}
+
+// submit places a normal-priority message on the queue, handling a full queue as policy
+// says
+func (cw *ConnectionWriter) submit(req writeRequest, policy BroadcastPolicy) error {
+	if policy == BroadcastBlock {
+		return cw.enqueue(cw.queue, req)
+	}
+	if err := cw.checkMessage(); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case cw.queue <- req:
+			return nil
+		default:
+		}
+		if policy != BroadcastDropOldest {
+			return domain.ErrSendQueueFull
+		}
+
+		select {
+		case dropped := <-cw.queue:
+			// A dropped flush barrier can no longer vouch for the messages before it
+			if dropped.flush != nil {
+				dropped.flush <- domain.ErrSendQueueFull
+			}
+			// A close frame queued while this message was being submitted must survive
+			if dropped.frame != nil {
+				select {
+				case cw.queue <- dropped:
+				case <-cw.done:
+				}
+				return domain.ErrSendQueueFull
+			}
+		default:
+			// The writer goroutine emptied a slot in the meantime
+		}
+	}
+}
+
+// enqueue places a request on queue unless the writer has stopped or failed, or the
+// request is a message and a close frame was already queued
+func (cw *ConnectionWriter) enqueue(queue chan writeRequest, req writeRequest) error {
+	check := cw.checkOpen
+	if req.msg != nil {
+		check = cw.checkMessage
+	}
+	if err := check(); err != nil {
+		return err
+	}
+
+	select {
+	case queue <- req:
+		return nil
+	case <-cw.done:
+		return domain.ErrConnectionClosed
+	}
+}
+
+// run drains the queue until Close is called
+func (cw *ConnectionWriter) run() {
+	defer close(cw.stopped)
+
+	for {
+		// Drain high-priority requests before looking at the normal queue
+		select {
+		case req := <-cw.priority:
+			cw.handle(req)
+			continue
+		default:
+		}
+
+		select {
+		case req := <-cw.priority:
+			cw.handle(req)
+		case req := <-cw.queue:
+			cw.handle(req)
+		case <-cw.done:
+			return
+		}
+	}
+}
+
+// handle writes a single queued request
+func (cw *ConnectionWriter) handle(req writeRequest) {
+	if req.flush != nil {
+		cw.flush()
+		req.flush <- cw.Err()
+		return
+	}
+
+	switch {
+	case req.msg != nil && cw.closeWritten:
+		// Queued while the close frame was on its way; nothing may follow it on the wire
+	case cw.Err() == nil:
+		if err := cw.write(req); err != nil {
+			cw.setErr(err)
+		}
+		if req.frame != nil && req.frame.Opcode == domain.OpcodeClose {
+			cw.closeWritten = true
+		}
+	}
+
+	// Batch writes: only flush once nothing else is waiting
+	if len(cw.queue) == 0 && len(cw.priority) == 0 {
+		cw.flush()
+	}
+}
+
+// write serializes a message, reusing pre-marshaled bytes when the parser allows it
+func (cw *ConnectionWriter) write(req writeRequest) error {
+	if req.frame != nil {
+		return cw.parser.WriteFrame(cw.writer, req.frame)
+	}
+	if req.data != nil && cw.parser.sharesWireFormat() {
+		_, err := cw.writer.Write(req.data)
+		return err
+	}
+	return cw.parser.WriteMessage(cw.writer, req.msg, 0)
+}
+
+// flush pushes buffered bytes to the underlying writer
+func (cw *ConnectionWriter) flush() {
+	if cw.Err() != nil {
+		return
+	}
+	if err := cw.writer.Flush(); err != nil {
+		cw.setErr(err)
+	}
+}
+
+// setErr records the first write error
+func (cw *ConnectionWriter) setErr(err error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.err == nil {
+		cw.err = err
+	}
+}
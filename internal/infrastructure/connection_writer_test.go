@@ -0,0 +1,426 @@
+package infrastructure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+// readFramesAsync reads frames from conn on a goroutine and publishes them on the returned channel
+func readFramesAsync(parser *FrameParser, conn net.Conn) <-chan *domain.Frame {
+	frames := make(chan *domain.Frame, 64)
+	go func() {
+		defer close(frames)
+		for {
+			frame, err := parser.ReadFrame(conn)
+			if err != nil {
+				return
+			}
+			frames <- frame
+		}
+	}()
+	return frames
+}
+
+func TestConnectionWriter_FlushDeliversQueuedMessages(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	writer := NewConnectionWriter(server, parser, 16)
+	defer writer.Close()
+
+	conn := domain.NewConnection("c1", "pipe")
+	_ = conn.TransitionTo(domain.StateOpen)
+	conn.SetSender(writer)
+
+	received := readFramesAsync(parser, client)
+
+	payloads := []string{"one", "two", "three"}
+	for _, p := range payloads {
+		if err := conn.Send(domain.NewTextMessage([]byte(p))); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// net.Pipe writes complete only once the peer has read them, so every
+	// frame has reached the peer by the time Flush returns
+	for _, want := range payloads {
+		select {
+		case frame := <-received:
+			if string(frame.Payload) != want {
+				t.Errorf("Expected payload %q, got %q", want, frame.Payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Frame %q not observable on peer after Flush", want)
+		}
+	}
+}
+
+func TestConnectionWriter_EnqueueAfterClose(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	writer := NewConnectionWriter(server, NewFrameParser(protocol.MaxPayloadSize), 4)
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if err := writer.Enqueue(domain.NewTextMessage([]byte("late"))); err != domain.ErrConnectionClosed {
+		t.Errorf("Expected ErrConnectionClosed, got %v", err)
+	}
+	if err := writer.Flush(); err != domain.ErrConnectionClosed {
+		t.Errorf("Expected ErrConnectionClosed from Flush, got %v", err)
+	}
+}
+
+func TestConnectionFlush_UnbufferedSenderIsNoop(t *testing.T) {
+	conn, _ := newOpenConnection(t, "plain")
+
+	done := make(chan error, 1)
+	go func() { done <- conn.Flush() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected nil error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Flush blocked on an unbuffered sender")
+	}
+}
+
+// gatedWriter blocks its first Write until released, so tests can build up a queue
+type gatedWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func newGatedWriter() *gatedWriter {
+	return &gatedWriter{entered: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.entered)
+		<-w.release
+	})
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func TestConnectionWriter_HighPriorityJumpsQueue(t *testing.T) {
+	out := newGatedWriter()
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	writer := NewConnectionWriter(out, parser, 16)
+	defer writer.Close()
+
+	// The first message is picked up immediately and blocks inside the transport
+	if err := writer.Enqueue(domain.NewTextMessage([]byte("normal-1"))); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	<-out.entered
+
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("normal-2")))
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("normal-3")))
+	if err := writer.EnqueueWithPriority(domain.NewTextMessage([]byte("urgent")), PriorityHigh); err != nil {
+		t.Fatalf("EnqueueWithPriority failed: %v", err)
+	}
+
+	close(out.release)
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	var order []string
+	for out.buf.Len() > 0 {
+		frame, err := parser.ReadFrame(&out.buf)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		order = append(order, string(frame.Payload))
+	}
+
+	expected := []string{"normal-1", "urgent", "normal-2", "normal-3"}
+	if len(order) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, order)
+		}
+	}
+}
+
+func TestConnectionWriter_CloseFrameIsWrittenLast(t *testing.T) {
+	out := newGatedWriter()
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	writer := NewConnectionWriter(out, parser, 16)
+	defer writer.Close()
+
+	// Hold the transport so everything below is still waiting when the close is queued
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("in-flight")))
+	<-out.entered
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("queued")))
+	if err := writer.SendClose(newCloseFrame(t, protocol.StatusNormalClosure, "")); err != nil {
+		t.Fatalf("SendClose failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		enqueue func(*domain.Message) error
+	}{
+		{"Enqueue", writer.Enqueue},
+		{"EnqueueWait", writer.EnqueueWait},
+		{"EnqueueDropOldest", writer.EnqueueDropOldest},
+		{"EnqueueWithPriority high", func(msg *domain.Message) error {
+			return writer.EnqueueWithPriority(msg, PriorityHigh)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.enqueue(domain.NewTextMessage([]byte("late"))); !errors.Is(err, domain.ErrConnectionClosed) {
+				t.Errorf("expected ErrConnectionClosed after the close was queued, got %v", err)
+			}
+		})
+	}
+
+	close(out.release)
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	var order []string
+	for out.buf.Len() > 0 {
+		frame, err := parser.ReadFrame(&out.buf)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if frame.Opcode == domain.OpcodeClose {
+			order = append(order, "close")
+		} else {
+			order = append(order, string(frame.Payload))
+		}
+	}
+	if strings.Join(order, ",") != "in-flight,queued,close" {
+		t.Errorf("expected the close frame after the queued messages and nothing else, got %v", order)
+	}
+}
+
+func TestConnectionWriter_SendCloseOnFullQueueKeepsEnqueueNonBlocking(t *testing.T) {
+	out := newGatedWriter()
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	writer := NewConnectionWriter(out, parser, 1)
+	defer writer.Close()
+
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("in-flight")))
+	<-out.entered
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("queued")))
+
+	closeFrame := newCloseFrame(t, protocol.StatusNormalClosure, "")
+	closed := make(chan error, 1)
+	go func() {
+		closed <- writer.SendClose(closeFrame)
+	}()
+	time.Sleep(10 * time.Millisecond) // Let SendClose block on the full queue
+
+	enqueued := make(chan error, 1)
+	go func() {
+		enqueued <- writer.Enqueue(domain.NewTextMessage([]byte("overflow")))
+	}()
+	select {
+	case err := <-enqueued:
+		if !errors.Is(err, domain.ErrSendQueueFull) {
+			t.Errorf("expected ErrSendQueueFull, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Enqueue blocked behind a pending SendClose")
+	}
+
+	// A message that slipped into the queue behind the close frame is never written
+	close(out.release)
+	if err := <-closed; err != nil {
+		t.Fatalf("SendClose failed: %v", err)
+	}
+	writer.queue <- writeRequest{msg: domain.NewTextMessage([]byte("raced"))}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	var order []string
+	for out.buf.Len() > 0 {
+		frame, err := parser.ReadFrame(&out.buf)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if frame.Opcode == domain.OpcodeClose {
+			order = append(order, "close")
+		} else {
+			order = append(order, string(frame.Payload))
+		}
+	}
+	if strings.Join(order, ",") != "in-flight,queued,close" {
+		t.Errorf("expected nothing after the close frame, got %v", order)
+	}
+}
+
+func TestConnectionWriter_EnqueueBackpressure(t *testing.T) {
+	out := newGatedWriter()
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	writer := NewConnectionWriter(out, parser, 1)
+	defer writer.Close()
+
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("in-flight")))
+	<-out.entered
+
+	if err := writer.Enqueue(domain.NewTextMessage([]byte("queued"))); err != nil {
+		t.Fatalf("Enqueue failed with room in the queue: %v", err)
+	}
+	if err := writer.Enqueue(domain.NewTextMessage([]byte("overflow"))); !errors.Is(err, domain.ErrSendQueueFull) {
+		t.Fatalf("expected ErrSendQueueFull, got %v", err)
+	}
+
+	// EnqueueWait holds on until the writer makes room
+	waited := make(chan error, 1)
+	go func() {
+		waited <- writer.EnqueueWait(domain.NewTextMessage([]byte("waited")))
+	}()
+	select {
+	case err := <-waited:
+		t.Fatalf("expected EnqueueWait to block on a full queue, returned %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(out.release)
+	if err := <-waited; err != nil {
+		t.Fatalf("EnqueueWait failed: %v", err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	var got []string
+	for out.buf.Len() > 0 {
+		frame, err := parser.ReadFrame(&out.buf)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		got = append(got, string(frame.Payload))
+	}
+	if expected := "in-flight,queued,waited"; strings.Join(got, ",") != expected {
+		t.Errorf("Expected %s, got %v", expected, got)
+	}
+}
+
+func TestConnectionWriter_EnqueueDropOldest(t *testing.T) {
+	out := newGatedWriter()
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	writer := NewConnectionWriter(out, parser, 2)
+	defer writer.Close()
+
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("in-flight")))
+	<-out.entered
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("stale")))
+	_ = writer.Enqueue(domain.NewTextMessage([]byte("kept")))
+
+	if err := writer.EnqueueDropOldest(domain.NewTextMessage([]byte("latest"))); err != nil {
+		t.Fatalf("EnqueueDropOldest failed: %v", err)
+	}
+
+	close(out.release)
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	var got []string
+	for out.buf.Len() > 0 {
+		frame, err := parser.ReadFrame(&out.buf)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		got = append(got, string(frame.Payload))
+	}
+
+	expected := []string{"in-flight", "kept", "latest"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestConnectionDrain_FinishesQueuedSendsThenCloses(t *testing.T) {
+	out := newGatedWriter()
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	writer := NewConnectionWriter(out, parser, 8)
+	defer writer.Close()
+
+	conn := domain.NewConnection("draining", "127.0.0.1:0")
+	_ = conn.TransitionTo(domain.StateOpen)
+	conn.SetSender(writer)
+
+	_ = conn.Send(domain.NewTextMessage([]byte("in-flight")))
+	<-out.entered
+	_ = conn.Send(domain.NewTextMessage([]byte("queued")))
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- conn.Drain()
+	}()
+	for !conn.IsDraining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := conn.Send(domain.NewTextMessage([]byte("late"))); !errors.Is(err, domain.ErrDraining) {
+		t.Errorf("expected ErrDraining for a send during drain, got %v", err)
+	}
+
+	close(out.release)
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatalf("Drain failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Drain did not complete")
+	}
+	if !conn.IsClosing() {
+		t.Errorf("expected connection to be closing, got %s", conn.State)
+	}
+
+	var got []string
+	var last *domain.Frame
+	for out.buf.Len() > 0 {
+		frame, err := parser.ReadFrame(&out.buf)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if frame.Opcode != domain.OpcodeClose {
+			got = append(got, string(frame.Payload))
+		}
+		last = frame
+	}
+	if strings.Join(got, ",") != "in-flight,queued" {
+		t.Errorf("expected queued messages to be written before closing, got %v", got)
+	}
+	if last == nil || last.Opcode != domain.OpcodeClose {
+		t.Fatal("expected the close frame to be written last")
+	}
+	if code := binary.BigEndian.Uint16(last.Payload); code != protocol.StatusGoingAway {
+		t.Errorf("expected close code %d, got %d", protocol.StatusGoingAway, code)
+	}
+}
@@ -0,0 +1,198 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"websocket-server/internal/domain"
+)
+
+// WithSetupBudget runs setup under a single deadline on conn that spans every read and
+// write it performs, typically the handshake request, the 101 response and the first
+// message. A client drip-feeding bytes therefore cannot stretch the setup phase past
+// budget. On success the deadline is cleared so per-frame and idle deadlines can take
+// over; on failure conn is closed and a timeout is reported as ErrSetupTimeout.
+func WithSetupBudget(conn net.Conn, budget time.Duration, setup func() error) error {
+	deadline := time.Now().Add(budget)
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	if err := setup(); err != nil {
+		conn.Close()
+		// Parsers may surface a truncated read as a syntax error, so an expired
+		// budget counts as a timeout whatever error it produced
+		if isTimeout(err) || !time.Now().Before(deadline) {
+			return fmt.Errorf("%w: %v", domain.ErrSetupTimeout, err)
+		}
+		return err
+	}
+
+	return conn.SetDeadline(time.Time{})
+}
+
+// ReadFrameWithDeadline reads a frame from conn, failing with ErrReadTimeout if the whole
+// frame has not arrived by deadline, e.g. time.Now().Add(idleTimeout) to reap silent
+// peers. The deadline stays set on conn after the call returns.
+func (fp *FrameParser) ReadFrameWithDeadline(conn net.Conn, deadline time.Time) (*domain.Frame, error) {
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	frame, err := fp.ReadFrame(conn)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, fmt.Errorf("%w: %v", domain.ErrReadTimeout, err)
+		}
+		return nil, err
+	}
+	return frame, nil
+}
+
+// ReadFrameContext reads a frame from conn until ctx is done. The context's deadline, if
+// any, becomes the read deadline, and cancelling ctx interrupts a read in progress; either
+// way ctx.Err() is returned. A frame cut off mid-read leaves the stream unusable, so conn
+// should be closed after such an error. The read deadline is cleared on return.
+// ReadFrame remains the way to read from readers without deadlines, e.g. in tests.
+func (fp *FrameParser) ReadFrameContext(ctx context.Context, conn net.Conn) (*domain.Frame, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+		close(interrupted)
+	})
+
+	frame, err := fp.ReadFrame(conn)
+
+	// Make sure a cancellation racing with the end of the read has set its deadline
+	// before clearing it
+	if !stop() {
+		<-interrupted
+	}
+	clearErr := conn.SetReadDeadline(time.Time{})
+
+	if err != nil {
+		// The connection's timer may fire a moment before the context's
+		if ctx.Err() == nil && isTimeout(err) && !deadline.IsZero() && !time.Now().Before(deadline) {
+			return nil, context.DeadlineExceeded
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	if clearErr != nil {
+		frame.Release()
+		return nil, clearErr
+	}
+	return frame, nil
+}
+
+// WriteFrameContext writes frame to conn until ctx is done, so a peer that stops reading
+// cannot block the writer forever once the send buffer fills. The context's deadline, if
+// any, becomes the write deadline, and cancelling ctx interrupts a write in progress;
+// either way the error matches ErrWriteTimeout as well as ctx.Err(). A frame cut off
+// mid-write corrupts the stream, so conn should be closed after such an error. The write
+// deadline is cleared on return.
+func (fp *FrameParser) WriteFrameContext(ctx context.Context, conn net.Conn, frame *domain.Frame) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %w", domain.ErrWriteTimeout, err)
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetWriteDeadline(time.Now())
+		close(interrupted)
+	})
+
+	err := fp.WriteFrame(conn, frame)
+
+	// As in ReadFrameContext, let a racing cancellation finish before clearing
+	if !stop() {
+		<-interrupted
+	}
+	clearErr := conn.SetWriteDeadline(time.Time{})
+
+	if err != nil {
+		// The connection's timer may fire a moment before the context's
+		if ctx.Err() == nil && isTimeout(err) && !deadline.IsZero() && !time.Now().Before(deadline) {
+			return fmt.Errorf("%w: %w", domain.ErrWriteTimeout, context.DeadlineExceeded)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %w", domain.ErrWriteTimeout, ctx.Err())
+		}
+		return err
+	}
+	return clearErr
+}
+
+// ReadFrameWithDeadlines reads a frame from conn under two separate deadlines. Once the
+// first byte of a frame arrives, the rest of its header (length and masking key) must
+// follow within headerTimeout, since well-behaved peers send the header in one piece;
+// the payload then gets payloadTimeout, or no deadline if it is zero. Waiting for the
+// first byte is not bounded, so idle connections are unaffected.
+//
+// A peer dripping the header is cut off: conn is closed and ErrHeaderTimeout returned.
+func (fp *FrameParser) ReadFrameWithDeadlines(conn net.Conn, headerTimeout, payloadTimeout time.Duration) (*domain.Frame, error) {
+	var first [1]byte
+	if _, err := io.ReadFull(conn, first[:]); err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(headerTimeout)); err != nil {
+		return nil, err
+	}
+	frame, err := fp.readHeader(PrefixReader(first[:], conn))
+	if err != nil {
+		if isTimeout(err) {
+			conn.Close()
+			return nil, fmt.Errorf("%w: %v", domain.ErrHeaderTimeout, err)
+		}
+		return nil, err
+	}
+
+	var payloadDeadline time.Time
+	if payloadTimeout > 0 {
+		payloadDeadline = time.Now().Add(payloadTimeout)
+	}
+	if err := conn.SetReadDeadline(payloadDeadline); err != nil {
+		return nil, err
+	}
+	if err := fp.readPayload(conn, frame); err != nil {
+		return nil, err
+	}
+
+	// Leave the next frame's first byte unbounded again
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		frame.Release()
+		return nil, err
+	}
+	return frame, nil
+}
+
+// isTimeout reports whether err was caused by an expired deadline
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,324 @@
+package infrastructure
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+// rawHandshakeRequest is a valid client handshake using the RFC 6455 sample key
+const rawHandshakeRequest = "GET /chat HTTP/1.1\r\n" +
+	"Host: server.example.com\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n" +
+	"\r\n"
+
+// acceptHandshake answers a client handshake on a raw connection: it reads and validates
+// the request, then writes the 101 response
+func acceptHandshake(conn net.Conn) error {
+	validator := NewHandshakeValidator()
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return err
+	}
+	if err := validator.ValidateRequest(req); err != nil {
+		return err
+	}
+	accept := validator.GenerateAcceptKey(req.Header.Get(protocol.HeaderSecWebSocketKey))
+	_, err = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: "+accept+"\r\n\r\n")
+	return err
+}
+
+func TestWithSetupBudget_CompletesHandshakeAndFirstMessage(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+
+	type result struct {
+		msg *domain.Message
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var msg *domain.Message
+		err := WithSetupBudget(server, time.Second, func() error {
+			if err := acceptHandshake(server); err != nil {
+				return err
+			}
+			var err error
+			msg, err = NewMessageReader(parser, server, 0).ReadMessage()
+			return err
+		})
+		done <- result{msg, err}
+	}()
+
+	if _, err := client.Write([]byte(rawHandshakeRequest)); err != nil {
+		t.Fatalf("Failed to write handshake: %v", err)
+	}
+	br := bufio.NewReader(client)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("Failed to read handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get(protocol.HeaderSecWebSocketAccept); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected accept key %q", got)
+	}
+
+	frame := domain.NewFrame(domain.OpcodeText, []byte("hello"))
+	if err := parser.WriteFrame(client, frame); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("Setup failed: %v", res.err)
+	}
+	if string(res.msg.Payload) != "hello" {
+		t.Errorf("Expected first message 'hello', got %q", res.msg.Payload)
+	}
+}
+
+func TestWithSetupBudget_SlowHandshakeIsClosed(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- WithSetupBudget(server, 100*time.Millisecond, func() error {
+			return acceptHandshake(server)
+		})
+	}()
+
+	// Drip the handshake one byte at a time; each byte arrives well within any
+	// per-read timeout, but the whole request takes far longer than the budget
+	var writeErr error
+	for i := 0; i < len(rawHandshakeRequest) && writeErr == nil; i++ {
+		_, writeErr = client.Write([]byte{rawHandshakeRequest[i]})
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, domain.ErrSetupTimeout) {
+			t.Fatalf("Expected ErrSetupTimeout, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Setup did not time out")
+	}
+	if writeErr == nil {
+		t.Error("Expected the server to close the connection mid-handshake")
+	}
+}
+
+func TestFrameParser_ReadFrameWithDeadlinesHeaderDrip(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	var wire bytes.Buffer
+	_ = NewFrameParser(protocol.MaxPayloadSize).WriteFrame(&wire, domain.NewFrame(domain.OpcodeText, []byte("hello")))
+
+	// Drip the header one byte at a time, far slower than the header deadline
+	go func() {
+		for _, b := range wire.Bytes() {
+			if _, err := client.Write([]byte{b}); err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	_, err := parser.ReadFrameWithDeadlines(server, 20*time.Millisecond, time.Second)
+	if !errors.Is(err, domain.ErrHeaderTimeout) {
+		t.Fatalf("expected ErrHeaderTimeout, got %v", err)
+	}
+
+	// The server side must have been closed
+	if _, err := server.Read(make([]byte, 1)); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("expected connection to be closed, got %v", err)
+	}
+}
+
+func TestFrameParser_ReadFrameWithDeadlinesSlowPayload(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	payload := bytes.Repeat([]byte("x"), 300)
+	var wire bytes.Buffer
+	_ = NewFrameParser(protocol.MaxPayloadSize).WriteFrame(&wire, domain.NewFrame(domain.OpcodeBinary, payload))
+	header, body := wire.Bytes()[:4], wire.Bytes()[4:]
+
+	// The header arrives atomically; the payload trickles in over longer than the header deadline
+	go func() {
+		_, _ = client.Write(header)
+		for len(body) > 0 {
+			n := min(100, len(body))
+			if _, err := client.Write(body[:n]); err != nil {
+				return
+			}
+			body = body[n:]
+			time.Sleep(30 * time.Millisecond)
+		}
+	}()
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	frame, err := parser.ReadFrameWithDeadlines(server, 20*time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatalf("expected slow payload to be accepted, got %v", err)
+	}
+	if !bytes.Equal(frame.Payload, payload) {
+		t.Errorf("payload mismatch: got %d bytes", len(frame.Payload))
+	}
+}
+
+func TestFrameParser_ReadFrameWithDeadline(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+
+	// A silent peer times out with a distinct error instead of blocking forever
+	start := time.Now()
+	_, err := parser.ReadFrameWithDeadline(server, time.Now().Add(30*time.Millisecond))
+	if !errors.Is(err, domain.ErrReadTimeout) {
+		t.Fatalf("expected ErrReadTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("read blocked for %v", elapsed)
+	}
+
+	// A frame arriving in time is returned normally
+	go func() {
+		_ = parser.WriteFrame(client, domain.NewFrame(domain.OpcodeText, []byte("alive")))
+	}()
+	frame, err := parser.ReadFrameWithDeadline(server, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("ReadFrameWithDeadline failed: %v", err)
+	}
+	if string(frame.Payload) != "alive" {
+		t.Errorf("payload mismatch: %q", frame.Payload)
+	}
+}
+
+func TestFrameParser_WriteFrameContext(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	frame := domain.NewFrame(domain.OpcodeText, []byte("hello"))
+
+	// A peer that reads lets the write complete
+	received := make(chan *domain.Frame, 1)
+	go func() {
+		f, _ := parser.ReadFrame(client)
+		received <- f
+	}()
+	if err := parser.WriteFrameContext(context.Background(), server, frame); err != nil {
+		t.Fatalf("WriteFrameContext failed: %v", err)
+	}
+	if f := <-received; f == nil || string(f.Payload) != "hello" {
+		t.Fatalf("peer did not receive the frame: %v", f)
+	}
+
+	// A peer that never reads blocks net.Pipe writes until the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := parser.WriteFrameContext(ctx, server, frame)
+	if !errors.Is(err, domain.ErrWriteTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ErrWriteTimeout from the deadline, got %v", err)
+	}
+
+	// Cancelling interrupts a blocked write
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	err = parser.WriteFrameContext(ctx, server, frame)
+	if !errors.Is(err, domain.ErrWriteTimeout) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected ErrWriteTimeout from cancellation, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("write blocked for %v after cancellation", elapsed)
+	}
+
+	// An already cancelled context fails without writing
+	if err := parser.WriteFrameContext(ctx, server, frame); !errors.Is(err, domain.ErrWriteTimeout) {
+		t.Errorf("expected ErrWriteTimeout, got %v", err)
+	}
+}
+
+func TestFrameParser_ReadFrameContext(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+
+	// A frame arriving before cancellation is returned normally
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_ = parser.WriteFrame(client, domain.NewFrame(domain.OpcodeText, []byte("first")))
+	}()
+	frame, err := parser.ReadFrameContext(ctx, server)
+	if err != nil {
+		t.Fatalf("ReadFrameContext failed: %v", err)
+	}
+	if string(frame.Payload) != "first" {
+		t.Errorf("payload mismatch: %q", frame.Payload)
+	}
+
+	// Cancelling interrupts a read blocked on a silent peer
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := parser.ReadFrameContext(ctx, server); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("read blocked for %v after cancellation", elapsed)
+	}
+
+	// An already cancelled context fails without reading
+	if _, err := parser.ReadFrameContext(ctx, server); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	// The context deadline bounds the read
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := parser.ReadFrameContext(ctx, server); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// Deadlines are cleared afterwards, so plain reads still block until data arrives
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		_ = parser.WriteFrame(client, domain.NewFrame(domain.OpcodeText, []byte("later")))
+	}()
+	frame, err = parser.ReadFrame(server)
+	if err != nil {
+		t.Fatalf("ReadFrame after ReadFrameContext failed: %v", err)
+	}
+	if string(frame.Payload) != "later" {
+		t.Errorf("payload mismatch: %q", frame.Payload)
+	}
+}
@@ -0,0 +1,190 @@
+package infrastructure
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+// deflateResponse is the permessage-deflate response sent to clients. Both sides compress
+// each message independently, which the server may require even when the client's offer
+// did not ask for it (RFC 7692 section 7.1.1).
+const deflateResponse = protocol.ExtensionPermessageDeflate + "; server_no_context_takeover; client_no_context_takeover"
+
+// deflateTail is the empty stored block a sync flush ends with. Senders strip it from
+// each compressed message and receivers put it back (RFC 7692 section 7.2.1).
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// deflateTrailer restores deflateTail and follows it with a final empty block, so the
+// decompressor reports io.EOF at the end of the message
+var deflateTrailer = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+var (
+	flateWriterPool = sync.Pool{New: func() interface{} {
+		fw, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return fw
+	}}
+	flateReaderPool sync.Pool
+)
+
+// NegotiateDeflate accepts permessage-deflate when the client offers it in
+// Sec-WebSocket-Extensions with parameters this server can honour, adding the accepted
+// extension to the response headers. Call it before PerformUpgrade, or set
+// HandshakeValidator.EnableCompression, and set FrameParser.Deflate when it returns true.
+func NegotiateDeflate(w http.ResponseWriter, req *http.Request) bool {
+	return negotiateDeflate(w.Header(), req)
+}
+
+// negotiateDeflate is NegotiateDeflate adding the accepted extension to header, for
+// handshakes answered without a ResponseWriter
+func negotiateDeflate(header http.Header, req *http.Request) bool {
+	for _, offer := range parseExtensions(req.Header.Values(protocol.HeaderSecWebSocketExtensions)) {
+		if offer.name == protocol.ExtensionPermessageDeflate && acceptableDeflateOffer(offer.params) {
+			response := deflateResponse
+			if _, ok := offer.params["server_max_window_bits"]; ok {
+				// An accepted server_max_window_bits must be echoed (RFC 7692 section 7.1.2.1)
+				response += "; server_max_window_bits=15"
+			}
+			header.Add(protocol.HeaderSecWebSocketExtensions, response)
+			return true
+		}
+	}
+	return false
+}
+
+// DeflateNegotiated reports whether headers, e.g. those of a handshake response, accept
+// permessage-deflate
+func DeflateNegotiated(headers http.Header) bool {
+	for _, ext := range parseExtensions(headers.Values(protocol.HeaderSecWebSocketExtensions)) {
+		if ext.name == protocol.ExtensionPermessageDeflate {
+			return true
+		}
+	}
+	return false
+}
+
+// extension is one entry of a Sec-WebSocket-Extensions header
+type extension struct {
+	name   string
+	params map[string]string // Parameter values, "" for parameters without one
+}
+
+// parseExtensions splits Sec-WebSocket-Extensions header values into their extensions.
+// Parameters repeated within one extension are recorded under a "" key, which no
+// extension accepts.
+func parseExtensions(values []string) []extension {
+	var extensions []extension
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			parts := strings.Split(entry, ";")
+			name := strings.ToLower(strings.TrimSpace(parts[0]))
+			if name == "" {
+				continue
+			}
+
+			ext := extension{name: name, params: make(map[string]string)}
+			for _, param := range parts[1:] {
+				key, val, _ := strings.Cut(param, "=")
+				key = strings.ToLower(strings.TrimSpace(key))
+				if _, dup := ext.params[key]; dup {
+					key = ""
+				}
+				ext.params[key] = strings.Trim(strings.TrimSpace(val), `"`)
+			}
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions
+}
+
+// acceptableDeflateOffer reports whether a permessage-deflate offer can be accepted.
+// compress/flate always uses a 32KB window, so an offer limiting the server's window
+// below 15 bits is declined.
+func acceptableDeflateOffer(params map[string]string) bool {
+	for key, val := range params {
+		switch key {
+		case "server_no_context_takeover", "client_no_context_takeover":
+			if val != "" {
+				return false
+			}
+		case "server_max_window_bits":
+			if val != "15" {
+				return false
+			}
+		case "client_max_window_bits":
+			// A hint the server may ignore; decompression handles any window size
+			if val == "" {
+				continue
+			}
+			if bits, err := strconv.Atoi(val); err != nil || bits < 8 || bits > 15 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// compressPayload deflates a message payload and strips the trailing empty block
+func compressPayload(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(fw)
+
+	fw.Reset(&buf)
+	if _, err := fw.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+
+	compressed := buf.Bytes()
+	if !bytes.HasSuffix(compressed, deflateTail) {
+		return nil, fmt.Errorf("%w: deflate flush did not end with an empty block", domain.ErrInternalError)
+	}
+	return compressed[:len(compressed)-len(deflateTail)], nil
+}
+
+// decompressPayload inflates a compressed message payload, rejecting output larger than
+// limit bytes
+func decompressPayload(payload []byte, limit uint64) ([]byte, error) {
+	fr := newFlateReader(bytes.NewReader(payload))
+	defer flateReaderPool.Put(fr)
+
+	// Read one byte past the limit to tell an exact fit from an overflow
+	readLimit := int64(math.MaxInt64)
+	if limit < math.MaxInt64 {
+		readLimit = int64(limit) + 1
+	}
+	out, err := io.ReadAll(io.LimitReader(fr, readLimit))
+	if err != nil {
+		return nil, fmt.Errorf("%w: corrupt compressed payload: %v", domain.ErrInvalidFramePayloadData, err)
+	}
+	if uint64(len(out)) > limit {
+		return nil, domain.ErrPayloadTooLarge
+	}
+	return out, nil
+}
+
+// newFlateReader returns a pooled decompressor reading the compressed message from r
+// followed by deflateTrailer. Return it to flateReaderPool when done.
+func newFlateReader(r io.Reader) io.ReadCloser {
+	src := io.MultiReader(r, bytes.NewReader(deflateTrailer))
+	if fr, ok := flateReaderPool.Get().(io.ReadCloser); ok {
+		if err := fr.(flate.Resetter).Reset(src, nil); err == nil {
+			return fr
+		}
+	}
+	return flate.NewReader(src)
+}
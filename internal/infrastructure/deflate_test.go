@@ -0,0 +1,239 @@
+package infrastructure
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+func TestNegotiateDeflate(t *testing.T) {
+	tests := []struct {
+		offer    string
+		accepted bool
+	}{
+		{"permessage-deflate", true},
+		{"permessage-deflate; client_max_window_bits", true},
+		{"permessage-deflate; client_max_window_bits=10; server_no_context_takeover", true},
+		{"permessage-deflate; server_max_window_bits=15", true},
+		{`permessage-deflate; client_max_window_bits="12"`, true},
+		{"permessage-deflate; server_max_window_bits=10", false},
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate", true},
+		{"permessage-deflate; client_max_window_bits=16", false},
+		{"permessage-deflate; server_no_context_takeover=1", false},
+		{"permessage-deflate; server_no_context_takeover; server_no_context_takeover", false},
+		{"permessage-deflate; unknown_param", false},
+		{protocol.ExtensionFrameChecksum, false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.offer, func(t *testing.T) {
+			req := newHandshakeRequest()
+			req.Header.Set(protocol.HeaderSecWebSocketExtensions, tt.offer)
+			w := httptest.NewRecorder()
+
+			if got := NegotiateDeflate(w, req); got != tt.accepted {
+				t.Fatalf("expected %v, got %v", tt.accepted, got)
+			}
+			if got := DeflateNegotiated(w.Header()); got != tt.accepted {
+				t.Errorf("expected DeflateNegotiated=%v, response header %q", tt.accepted, w.Header().Get(protocol.HeaderSecWebSocketExtensions))
+			}
+			if tt.accepted && !strings.HasPrefix(w.Header().Get(protocol.HeaderSecWebSocketExtensions), deflateResponse) {
+				t.Errorf("expected response %q, got %q", deflateResponse, w.Header().Get(protocol.HeaderSecWebSocketExtensions))
+			}
+		})
+	}
+}
+
+func TestNegotiateDeflate_Response(t *testing.T) {
+	tests := []struct {
+		offer    string
+		response string
+	}{
+		{"permessage-deflate", deflateResponse},
+		{"permessage-deflate; client_max_window_bits=10", deflateResponse},
+		{"permessage-deflate; server_max_window_bits=15", deflateResponse + "; server_max_window_bits=15"},
+		{`permessage-deflate; server_max_window_bits="15"; client_max_window_bits`, deflateResponse + "; server_max_window_bits=15"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.offer, func(t *testing.T) {
+			req := newHandshakeRequest()
+			req.Header.Set(protocol.HeaderSecWebSocketExtensions, tt.offer)
+			w := httptest.NewRecorder()
+
+			if !NegotiateDeflate(w, req) {
+				t.Fatal("expected the offer to be accepted")
+			}
+			if got := w.Header().Get(protocol.HeaderSecWebSocketExtensions); got != tt.response {
+				t.Errorf("expected response %q, got %q", tt.response, got)
+			}
+		})
+	}
+}
+
+func TestHandshakeValidator_EnableCompression(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		req := newHandshakeRequest()
+		req.Header.Set(protocol.HeaderSecWebSocketExtensions, "permessage-deflate; client_max_window_bits")
+		w := httptest.NewRecorder()
+
+		validator := NewHandshakeValidator()
+		validator.EnableCompression = enabled
+		if err := validator.PerformUpgrade(w, req); err != nil {
+			t.Fatalf("PerformUpgrade failed: %v", err)
+		}
+		if w.Code != http.StatusSwitchingProtocols {
+			t.Fatalf("expected 101, got %d", w.Code)
+		}
+		if got := DeflateNegotiated(w.Header()); got != enabled {
+			t.Errorf("EnableCompression=%v: expected negotiated=%v, got %v", enabled, enabled, got)
+		}
+	}
+}
+
+func TestFrameParser_DeflateRoundTrip(t *testing.T) {
+	text := bytes.Repeat([]byte("compressible text "), 100)
+
+	tests := []struct {
+		name         string
+		msg          *domain.Message
+		fragmentSize int
+	}{
+		{"single frame", domain.NewTextMessage(text), 0},
+		{"fragmented", domain.NewTextMessage(text), 16},
+		{"binary", domain.NewBinaryMessage(text), 0},
+		{"empty", domain.NewBinaryMessage([]byte{}), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewFrameParser(protocol.MaxPayloadSize)
+			parser.Deflate = true
+
+			var wire bytes.Buffer
+			if err := parser.WriteMessage(&wire, tt.msg, tt.fragmentSize); err != nil {
+				t.Fatalf("WriteMessage failed: %v", err)
+			}
+
+			// Only the first frame is marked compressed
+			frames := bytes.NewReader(wire.Bytes())
+			compressedLen := 0
+			for i := 0; frames.Len() > 0; i++ {
+				frame, err := parser.ReadFrame(frames)
+				if err != nil {
+					t.Fatalf("ReadFrame %d failed: %v", i, err)
+				}
+				if frame.RSV1 != (i == 0) {
+					t.Errorf("frame %d: RSV1=%v", i, frame.RSV1)
+				}
+				compressedLen += len(frame.Payload)
+			}
+			if len(tt.msg.Payload) > 0 && compressedLen >= len(tt.msg.Payload) {
+				t.Errorf("expected compression, got %d bytes for a %d-byte payload", compressedLen, len(tt.msg.Payload))
+			}
+
+			msg, err := NewMessageReader(parser, &wire, 0).ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage failed: %v", err)
+			}
+			if msg.Type != tt.msg.Type || !bytes.Equal(msg.Payload, tt.msg.Payload) {
+				t.Errorf("round trip mismatch: got %v with %d bytes", msg.Type, len(msg.Payload))
+			}
+		})
+	}
+}
+
+func TestFrameParser_DeflateRSV1Rules(t *testing.T) {
+	deflate := NewFrameParser(protocol.MaxPayloadSize)
+	deflate.Deflate = true
+
+	tests := []struct {
+		name    string
+		parser  *FrameParser
+		wire    []byte
+		wantErr error
+	}{
+		{"RSV1 without deflate", NewFrameParser(protocol.MaxPayloadSize), []byte{0xC1, 0x00}, domain.ErrReservedBitsSet},
+		{"RSV1 on text", deflate, []byte{0xC1, 0x00}, nil},
+		{"RSV1 on continuation", deflate, []byte{0xC0, 0x00}, domain.ErrReservedBitsSet},
+		{"RSV1 on ping", deflate, []byte{0xC9, 0x00}, domain.ErrReservedBitsSet},
+		{"RSV2 with deflate", deflate, []byte{0xA1, 0x00}, domain.ErrReservedBitsSet},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.parser.ReadFrame(bytes.NewReader(tt.wire)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMessageReader_DeflateLimits(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	parser.Deflate = true
+
+	// A small compressed payload must not inflate past the message limit
+	var bomb bytes.Buffer
+	if err := parser.WriteMessage(&bomb, domain.NewBinaryMessage(make([]byte, 64*1024)), 0); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	if _, err := NewMessageReader(parser, &bomb, 1024).ReadMessage(); !errors.Is(err, domain.ErrPayloadTooLarge) {
+		t.Errorf("expected ErrPayloadTooLarge, got %v", err)
+	}
+
+	corrupt := writeFrames(t, NewFrameParser(protocol.MaxPayloadSize), domain.NewFrame(domain.OpcodeBinary, []byte{0xFF, 0xFF, 0xFF}))
+	corrupt.Bytes()[0] |= 0x40 // RSV1
+	if _, err := NewMessageReader(parser, corrupt, 0).ReadMessage(); !errors.Is(err, domain.ErrInvalidFramePayloadData) {
+		t.Errorf("expected ErrInvalidFramePayloadData, got %v", err)
+	}
+}
+
+func TestMessageStream_Deflate(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	parser.Deflate = true
+
+	payload := bytes.Repeat([]byte("streamed and compressed "), 500)
+	var wire bytes.Buffer
+	if err := parser.WriteMessage(&wire, domain.NewBinaryMessage(payload), 64); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	_ = parser.WriteFrame(&wire, domain.NewFrame(domain.OpcodeText, []byte("next")))
+
+	reader := NewMessageReader(parser, &wire, 0)
+	stream, err := reader.NextStream()
+	if err != nil {
+		t.Fatalf("NextStream failed: %v", err)
+	}
+	got, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("stream payload mismatch: got %d bytes, want %d", len(got), len(payload))
+	}
+
+	msg, err := reader.ReadMessage()
+	if err != nil || string(msg.Payload) != "next" {
+		t.Errorf("expected next message after the stream, got %v, %v", msg, err)
+	}
+
+	// The message limit applies to decompressed bytes
+	wire.Reset()
+	_ = parser.WriteMessage(&wire, domain.NewBinaryMessage(payload), 0)
+	stream, err = NewMessageReader(parser, &wire, 1024).NextStream()
+	if err != nil {
+		t.Fatalf("NextStream failed: %v", err)
+	}
+	if _, err := io.ReadAll(stream); !errors.Is(err, domain.ErrPayloadTooLarge) {
+		t.Errorf("expected ErrPayloadTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package infrastructure
+
+import (
+	"net/http"
+
+	"websocket-server/pkg/protocol"
+)
+
+// EchoHandler is an http.Handler that upgrades every request and writes each message it
+// receives straight back. Pings are answered, fragmented messages reassembled and the
+// closing handshake completed by Conn, so the handler is a complete reference server
+// built on the public API only, e.g. as a target for the Autobahn test suite:
+//
+//	http.Handle("/echo", &infrastructure.EchoHandler{})
+type EchoHandler struct {
+	// Validator performs the opening handshake. Nil means NewHandshakeValidator().
+	Validator *HandshakeValidator
+}
+
+// ServeHTTP upgrades the request and echoes messages until the connection closes
+func (h *EchoHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	validator := h.Validator
+	if validator == nil {
+		validator = NewHandshakeValidator()
+	}
+
+	// A failed handshake has already been answered with an error response
+	conn, err := validator.Upgrade(w, req)
+	if err != nil {
+		return
+	}
+
+	for {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			// ReadMessage has closed the connection, telling the peer why if needed
+			return
+		}
+		if err := conn.WriteMessage(msg); err != nil {
+			_ = conn.Close(protocol.StatusInternalServerError, "echo failed")
+			return
+		}
+	}
+}
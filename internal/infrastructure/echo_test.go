@@ -0,0 +1,66 @@
+package infrastructure
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+func TestEchoHandler_EchoesUntilClose(t *testing.T) {
+	server := httptest.NewServer(&EchoHandler{})
+	t.Cleanup(server.Close)
+	conn, br := dialWebSocket(t, server)
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+
+	_ = client.WriteFrame(conn, fragment(domain.OpcodeBinary, "fragm", false))
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodePing, []byte("p")))
+	_ = client.WriteFrame(conn, fragment(domain.OpcodeContinuation, "ented", true))
+	expectFrame(t, br, domain.OpcodePong, []byte("p"))
+	expectFrame(t, br, domain.OpcodeBinary, []byte("fragmented"))
+
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodeText, []byte("hello")))
+	expectFrame(t, br, domain.OpcodeText, []byte("hello"))
+
+	closeFrame := newCloseFrame(t, protocol.StatusNormalClosure, "")
+	_ = client.WriteFrame(conn, closeFrame)
+	expectFrame(t, br, domain.OpcodeClose, closeFrame.Payload)
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("expected the server to close the connection, got %v", err)
+	}
+}
+
+func TestEchoHandler_ClosesOnInvalidUTF8(t *testing.T) {
+	server := httptest.NewServer(&EchoHandler{})
+	t.Cleanup(server.Close)
+	conn, br := dialWebSocket(t, server)
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodeText, []byte{0xFF, 0xFE}))
+
+	frame, err := NewFrameParser(protocol.MaxPayloadSize).ReadFrame(br)
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if frame.Opcode != domain.OpcodeClose || binary.BigEndian.Uint16(frame.Payload) != protocol.StatusInvalidFramePayloadData {
+		t.Errorf("expected a 1007 close frame, got %s %x", frame.Opcode, frame.Payload)
+	}
+}
+
+func TestEchoHandler_RejectsPlainRequests(t *testing.T) {
+	server := httptest.NewServer(&EchoHandler{})
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 400 {
+		t.Errorf("expected an error status for a plain request, got %d", resp.StatusCode)
+	}
+}
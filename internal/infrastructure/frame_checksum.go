@@ -0,0 +1,57 @@
+package infrastructure
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+// checksumSize is the length of the CRC32 trailer appended to data frame payloads
+const checksumSize = 4
+
+// NegotiateChecksum accepts the non-standard frame checksum extension when the client
+// offers it in any Sec-WebSocket-Extensions line, with or without parameters, echoing
+// the bare token in the response headers. Call it before PerformUpgrade and set
+// FrameParser.Checksum when it returns true.
+func NegotiateChecksum(w http.ResponseWriter, req *http.Request) bool {
+	for _, offer := range parseExtensions(req.Header.Values(protocol.HeaderSecWebSocketExtensions)) {
+		if offer.name == protocol.ExtensionFrameChecksum {
+			w.Header().Add(protocol.HeaderSecWebSocketExtensions, protocol.ExtensionFrameChecksum)
+			return true
+		}
+	}
+	return false
+}
+
+// appendChecksum returns a copy of frame whose payload carries a trailing big-endian CRC32
+func appendChecksum(frame *domain.Frame) *domain.Frame {
+	payload := make([]byte, len(frame.Payload), len(frame.Payload)+checksumSize)
+	copy(payload, frame.Payload)
+	payload = binary.BigEndian.AppendUint32(payload, crc32.ChecksumIEEE(frame.Payload))
+
+	withChecksum := *frame
+	withChecksum.Payload = payload
+	withChecksum.PayloadLen = uint64(len(payload))
+	return &withChecksum
+}
+
+// verifyChecksum checks and strips the CRC32 trailer of an unmasked frame payload
+func verifyChecksum(frame *domain.Frame) error {
+	if len(frame.Payload) < checksumSize {
+		return fmt.Errorf("%w: %d-byte payload cannot hold a checksum", domain.ErrChecksumMismatch, len(frame.Payload))
+	}
+
+	split := len(frame.Payload) - checksumSize
+	data, trailer := frame.Payload[:split], frame.Payload[split:]
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(trailer) {
+		return domain.ErrChecksumMismatch
+	}
+
+	frame.Payload = data
+	frame.PayloadLen = uint64(split)
+	return nil
+}
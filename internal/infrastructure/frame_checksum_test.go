@@ -0,0 +1,175 @@
+package infrastructure
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+func TestFrameParser_ChecksumRoundTrip(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	parser.Checksum = true
+
+	var buf bytes.Buffer
+	frame := domain.NewFrame(domain.OpcodeText, []byte("tunnelled"))
+	if err := parser.WriteFrame(&buf, frame); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	if frame.PayloadLen != uint64(len("tunnelled")) {
+		t.Errorf("WriteFrame must not modify the caller's frame, PayloadLen is %d", frame.PayloadLen)
+	}
+
+	got, err := parser.ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if string(got.Payload) != "tunnelled" || got.PayloadLen != uint64(len("tunnelled")) {
+		t.Errorf("expected checksum to be stripped, got %q (len %d)", got.Payload, got.PayloadLen)
+	}
+}
+
+func TestFrameParser_ChecksumDetectsCorruption(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(wire []byte)
+	}{
+		{"payload byte flipped", func(wire []byte) { wire[2] ^= 0x01 }},
+		{"checksum byte flipped", func(wire []byte) { wire[len(wire)-1] ^= 0x80 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewFrameParser(protocol.MaxPayloadSize)
+			parser.Checksum = true
+
+			var buf bytes.Buffer
+			_ = parser.WriteFrame(&buf, domain.NewFrame(domain.OpcodeBinary, []byte("payload")))
+			wire := buf.Bytes()
+			tt.corrupt(wire)
+
+			_, err := parser.ReadFrame(bytes.NewReader(wire))
+			if !errors.Is(err, domain.ErrChecksumMismatch) {
+				t.Errorf("expected ErrChecksumMismatch, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFrameParser_ChecksumWithOutboundLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		fragmentSize int
+		payloadLen   int
+		wantSizes    []uint64
+	}{
+		{"fits one frame", 0, 96, []uint64{100}},
+		{"fragmented by the limit", 0, 250, []uint64{100, 100, 62}},
+		{"explicit fragment size above the limit", 200, 250, []uint64{100, 100, 62}},
+		{"explicit fragment size within the limit", 50, 120, []uint64{54, 54, 24}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewFrameParser(protocol.MaxPayloadSize)
+			parser.Checksum = true
+			parser.MaxOutboundFrameSize = 100
+
+			payload := bytes.Repeat([]byte("x"), tt.payloadLen)
+			var buf bytes.Buffer
+			if err := parser.WriteMessage(&buf, domain.NewBinaryMessage(payload), tt.fragmentSize); err != nil {
+				t.Fatalf("WriteMessage failed: %v", err)
+			}
+
+			// A parser without Checksum sees the wire payloads, trailer included
+			wire := bytes.NewReader(buf.Bytes())
+			var sizes []uint64
+			for wire.Len() > 0 {
+				frame, err := NewFrameParser(protocol.MaxPayloadSize).ReadFrame(wire)
+				if err != nil {
+					t.Fatalf("Failed to read frame: %v", err)
+				}
+				sizes = append(sizes, frame.PayloadLen)
+			}
+			if fmt.Sprint(sizes) != fmt.Sprint(tt.wantSizes) {
+				t.Errorf("expected frame sizes %v, got %v", tt.wantSizes, sizes)
+			}
+
+			msg, err := NewMessageReader(parser, &buf, 0).ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage failed: %v", err)
+			}
+			if !bytes.Equal(msg.Payload, payload) {
+				t.Errorf("expected the message to survive the round trip, got %d bytes", len(msg.Payload))
+			}
+		})
+	}
+
+	t.Run("limit too small for the checksum", func(t *testing.T) {
+		parser := NewFrameParser(protocol.MaxPayloadSize)
+		parser.Checksum = true
+		parser.MaxOutboundFrameSize = checksumSize
+
+		var buf bytes.Buffer
+		err := parser.WriteMessage(&buf, domain.NewBinaryMessage([]byte("x")), 0)
+		if !errors.Is(err, domain.ErrInvalidConfig) {
+			t.Errorf("expected ErrInvalidConfig, got %v", err)
+		}
+	})
+}
+
+func TestFrameParser_ChecksumSkipsControlFrames(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	parser.Checksum = true
+
+	var buf bytes.Buffer
+	_ = parser.WriteFrame(&buf, domain.NewFrame(domain.OpcodePing, []byte("ping")))
+
+	// Control frames are sent verbatim so peers without the extension still understand them
+	got, err := NewFrameParser(protocol.MaxPayloadSize).ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if string(got.Payload) != "ping" {
+		t.Errorf("expected unmodified ping payload, got %q", got.Payload)
+	}
+}
+
+func TestNegotiateChecksum(t *testing.T) {
+	tests := []struct {
+		offers   []string
+		accepted bool
+	}{
+		{[]string{protocol.ExtensionFrameChecksum}, true},
+		{[]string{"permessage-deflate, " + protocol.ExtensionFrameChecksum}, true},
+		{[]string{protocol.ExtensionFrameChecksum + "; v=1"}, true},
+		{[]string{"permessage-deflate", protocol.ExtensionFrameChecksum}, true},
+		{[]string{strings.ToUpper(protocol.ExtensionFrameChecksum)}, true},
+		{[]string{"permessage-deflate"}, false},
+		{[]string{""}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.offers, " | "), func(t *testing.T) {
+			req := newHandshakeRequest()
+			req.Header.Del(protocol.HeaderSecWebSocketExtensions)
+			for _, offer := range tt.offers {
+				req.Header.Add(protocol.HeaderSecWebSocketExtensions, offer)
+			}
+			w := httptest.NewRecorder()
+
+			if got := NegotiateChecksum(w, req); got != tt.accepted {
+				t.Fatalf("expected %v, got %v", tt.accepted, got)
+			}
+			echoed := w.Header().Get(protocol.HeaderSecWebSocketExtensions) == protocol.ExtensionFrameChecksum
+			if echoed != tt.accepted {
+				t.Errorf("expected extension echoed=%v, response header %q", tt.accepted, w.Header().Get(protocol.HeaderSecWebSocketExtensions))
+			}
+		})
+	}
+}
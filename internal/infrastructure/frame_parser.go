@@ -1,8 +1,15 @@
 package infrastructure
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
 
 	"websocket-server/internal/domain"
 	"websocket-server/pkg/protocol"
@@ -10,21 +17,173 @@ import (
 
 // FrameParser handles parsing and construction of WebSocket frames
 type FrameParser struct {
-	maxPayloadSize uint64
+	maxPayloadSize atomic.Uint64 // Read once per frame header, see SetMaxPayloadSize
+	pool           *sync.Pool    // Payload buffers, nil unless created by NewPooledFrameParser
+
+	// Role-dependent masking rules, set through NewFrameParserWithConfig
+	maskFrames   bool // Mask outgoing frames with a random key
+	requireMask  bool // Reject unmasked incoming frames
+	rejectMasked bool // Reject masked incoming frames
+
+	// MaxOutboundFragments caps how many frames WriteMessage may split one message into.
+	// Zero means protocol.MaxOutboundFragments.
+	MaxOutboundFragments int
+
+	// ClampFragments makes WriteMessage grow the fragment size until the message fits in
+	// MaxOutboundFragments frames instead of rejecting the write, as long as the grown
+	// fragments still fit MaxOutboundFrameSize.
+	ClampFragments bool
+
+	// CopyPayload makes a pooled parser return payloads the caller owns outright, so they
+	// stay valid after later reads and need no Release. Non-pooled parsers always do this.
+	CopyPayload bool
+
+	// ValidateFrame, when set, runs after the built-in RFC 6455 checks on every frame read
+	// or written, letting applications enforce stricter policies. Its error is returned
+	// from the read or write unchanged.
+	ValidateFrame func(*domain.Frame) error
+
+	// MaxOutboundFrameSize is the largest payload a single written frame may carry, e.g. a
+	// limit negotiated with a constrained peer. WriteFrame rejects larger frames and
+	// WriteMessage fragments to stay within it; the limit covers the trailer Checksum
+	// adds. Zero means no limit.
+	MaxOutboundFrameSize uint64
+
+	// Checksum appends a CRC32 of the payload to every data frame written and verifies and
+	// strips it on every data frame read. This is NOT part of RFC 6455: enable it only when
+	// both endpoints use this library and agreed on it, see NegotiateChecksum.
+	Checksum bool
+
+	// Deflate compresses every message written with WriteMessage and accepts RSV1 on the
+	// first frame of incoming messages, as agreed through permessage-deflate; see
+	// NegotiateDeflate. Frames read with ReadFrame keep their compressed payload and
+	// RSV1 bit; MessageReader decompresses whole messages.
+	Deflate bool
+
+	// AllowRSV1 accepts the RSV1 bit on frames read and written, for a custom extension
+	// negotiated by the application that gives it a meaning. RSV2 and RSV3 are still
+	// rejected. Deflate needs no AllowRSV1.
+	AllowRSV1 bool
+
+	// Rand is the source of masking keys, crypto/rand.Reader when nil. Override it only
+	// to make masked output deterministic in tests.
+	Rand io.Reader
+
+	// Strict rejects payload lengths encoded in a longer form than needed, e.g. a 64-bit
+	// length field holding 10, with ErrInvalidFrameStructure as RFC 6455 requires
+	Strict bool
+
+	// AllowLargeControlFrames accepts ping, pong and close frames read with payloads over
+	// the 125 bytes RFC 6455 allows, still bounded by the maximum payload size. Use it
+	// only to diagnose nonconforming peers; frames written are always held to the limit.
+	AllowLargeControlFrames bool
+
+	// AllowedOpcodes restricts the data frames ReadFrame accepts, e.g. to OpcodeBinary
+	// alone, rejecting the others with ErrInvalidOpcode. Close, ping, pong and
+	// continuation frames are always accepted, as the protocol needs them. Nil accepts
+	// every opcode RFC 6455 defines.
+	AllowedOpcodes []domain.Opcode
+
+	// Metrics, when set, is told about every frame read or written and every error that
+	// fails a read or write, e.g. to feed counters. Nil costs nothing.
+	Metrics Metrics
+}
+
+// Metrics receives frame-level events from a FrameParser. Sizes are payload lengths in
+// bytes as they appear on the wire, i.e. compressed when permessage-deflate is in use.
+// Calls are made from the reading or writing goroutine, so implementations shared
+// between connections must be safe for concurrent use.
+type Metrics interface {
+	// OnFrameRead is called for every frame read whose header passed validation
+	OnFrameRead(opcode domain.Opcode, size int)
+
+	// OnFrameWritten is called for every frame written successfully
+	OnFrameWritten(opcode domain.Opcode, size int)
+
+	// OnError is called with every error that fails a read or write, except the io.EOF
+	// of a stream that ends cleanly between frames
+	OnError(err error)
 }
 
 // NewFrameParser creates a new frame parser with the given maximum payload size
 func NewFrameParser(maxPayloadSize uint64) *FrameParser {
-	if maxPayloadSize == 0 {
-		maxPayloadSize = protocol.MaxPayloadSize
-	}
-	return &FrameParser{
-		maxPayloadSize: maxPayloadSize,
+	fp := &FrameParser{}
+	fp.SetMaxPayloadSize(maxPayloadSize)
+	return fp
+}
+
+// SetMaxPayloadSize changes the largest payload a frame read may carry, zero meaning
+// protocol.MaxPayloadSize. It is safe to call while other goroutines read with the
+// parser: frames whose header was already checked keep the old limit, later ones get
+// the new one.
+func (fp *FrameParser) SetMaxPayloadSize(n uint64) {
+	if n == 0 {
+		n = protocol.MaxPayloadSize
 	}
+	fp.maxPayloadSize.Store(n)
 }
 
-// ReadFrame reads and parses a WebSocket frame from the reader
+// MaxPayloadSize returns the current limit on the payload of a frame read
+func (fp *FrameParser) MaxPayloadSize() uint64 {
+	return fp.maxPayloadSize.Load()
+}
+
+// NewPooledFrameParser creates a frame parser that reads payloads into buffers taken from
+// a sync.Pool. A frame's payload is only valid until its Release method is called, which
+// returns the buffer for reuse by a later read.
+func NewPooledFrameParser(maxPayloadSize uint64) *FrameParser {
+	fp := NewFrameParser(maxPayloadSize)
+	fp.pool = &sync.Pool{}
+	return fp
+}
+
+// NewServerFrameParser creates a frame parser for the server side of a connection: every
+// frame read must be masked, as RFC 6455 requires of clients, or ReadFrame returns
+// ErrUnmaskedClientFrame. Frames it writes are left unmasked.
+func NewServerFrameParser(maxPayloadSize uint64) *FrameParser {
+	fp := NewFrameParser(maxPayloadSize)
+	fp.requireMask = true
+	return fp
+}
+
+// ReadFrame reads and parses a WebSocket frame from the reader. Malformed or truncated
+// input always yields an error, never a panic, and the payload is only allocated once its
+// declared length has passed the maxPayloadSize check, so a forged 64-bit length cannot
+// exhaust memory.
+//
+// A frame with no payload, e.g. an empty text message, gets an empty non-nil Payload.
+//
+// ReadFrame reads no further than the end of the frame, so it can be called in a loop on
+// any reader. When that reader is a *bufio.Reader, such as the one Hijack returns, keep
+// passing the same one: it may already hold the start of the next frame, which a fresh
+// reader or the bare connection would never see.
 func (fp *FrameParser) ReadFrame(reader io.Reader) (*domain.Frame, error) {
+	frame, err := fp.readHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fp.readPayload(reader, frame); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// readHeader reads and validates everything up to the payload: the first two bytes,
+// the extended payload length and the masking key. No payload memory is allocated.
+func (fp *FrameParser) readHeader(reader io.Reader) (*domain.Frame, error) {
+	frame, err := fp.decodeHeader(reader)
+	if err != nil {
+		fp.reportError(err)
+		return nil, err
+	}
+	fp.reportRead(frame)
+	return frame, nil
+}
+
+// decodeHeader does the work of readHeader
+func (fp *FrameParser) decodeHeader(reader io.Reader) (*domain.Frame, error) {
 	frame := &domain.Frame{}
 
 	// Read first two bytes (minimum frame header)
@@ -44,15 +203,8 @@ func (fp *FrameParser) ReadFrame(reader io.Reader) (*domain.Frame, error) {
 	frame.Masked = (header[1] & 0x80) != 0
 	payloadLen := uint64(header[1] & 0x7F)
 
-	// Validate frame structure
-	// Check if opcode is valid
-	if !frame.Opcode.IsControl() && !frame.Opcode.IsData() {
-		return nil, domain.ErrInvalidOpcode
-	}
-
-	// Check if reserved bits are set (they should be 0 unless extensions are negotiated)
-	if frame.RSV1 || frame.RSV2 || frame.RSV3 {
-		return nil, domain.ErrReservedBitsSet
+	if err := fp.checkFirstByte(frame); err != nil {
+		return nil, err
 	}
 
 	// Parse extended payload length if needed
@@ -64,42 +216,175 @@ func (fp *FrameParser) ReadFrame(reader io.Reader) (*domain.Frame, error) {
 
 	frame.PayloadLen = payloadLen
 
+	if err := fp.checkLength(frame); err != nil {
+		return nil, err
+	}
+
+	// Read masking key if present
+	if frame.Masked {
+		if _, err := io.ReadFull(reader, frame.MaskingKey[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return frame, nil
+}
+
+// checkFirstByte validates the opcode and reserved bits of a frame header
+func (fp *FrameParser) checkFirstByte(frame *domain.Frame) error {
+	// Reject reserved opcodes, reporting the value seen
+	if frame.Opcode.IsReserved() {
+		return &domain.FrameError{Opcode: frame.Opcode, Err: domain.ErrInvalidOpcode}
+	}
+	if !fp.allowsOpcode(frame.Opcode) {
+		return &domain.FrameError{Opcode: frame.Opcode, Err: domain.ErrInvalidOpcode}
+	}
+
+	// Check if reserved bits are set (they should be 0 unless extensions are negotiated)
+	if (frame.RSV1 && !fp.allowsRSV1(frame)) || frame.RSV2 || frame.RSV3 {
+		return domain.ErrReservedBitsSet
+	}
+
+	return nil
+}
+
+// allowsOpcode reports whether AllowedOpcodes lets a frame with opcode through
+func (fp *FrameParser) allowsOpcode(opcode domain.Opcode) bool {
+	if fp.AllowedOpcodes == nil || opcode.IsControl() || opcode == domain.OpcodeContinuation {
+		return true
+	}
+	return slices.Contains(fp.AllowedOpcodes, opcode)
+}
+
+// allowsRSV1 reports whether a negotiated extension gives the frame's RSV1 bit a
+// meaning; permessage-deflate marks the first frame of a compressed message with it
+func (fp *FrameParser) allowsRSV1(frame *domain.Frame) bool {
+	if fp.AllowRSV1 {
+		return true
+	}
+	return fp.Deflate && (frame.Opcode == domain.OpcodeText || frame.Opcode == domain.OpcodeBinary)
+}
+
+// checkLength validates the payload length and masking of a frame header
+func (fp *FrameParser) checkLength(frame *domain.Frame) error {
 	// Check payload size limit
-	if payloadLen > fp.maxPayloadSize {
-		return nil, domain.ErrPayloadTooLarge
+	if frame.PayloadLen > fp.maxPayloadSize.Load() {
+		return domain.ErrPayloadTooLarge
 	}
 
 	// Control frames must have payload length <= 125
-	if frame.Opcode.IsControl() && payloadLen > 125 {
-		return nil, domain.ErrInvalidFrameStructure
+	if frame.Opcode.IsControl() && frame.PayloadLen > protocol.MaxControlFramePayloadSize && !fp.AllowLargeControlFrames {
+		return domain.ErrInvalidFrameStructure
 	}
 
 	// Control frames must not be fragmented
 	if frame.Opcode.IsControl() && !frame.FIN {
-		return nil, domain.ErrInvalidFrameStructure
+		return domain.ErrInvalidFrameStructure
 	}
 
-	// Read masking key if present
-	if frame.Masked {
-		if _, err := io.ReadFull(reader, frame.MaskingKey[:]); err != nil {
-			return nil, err
-		}
+	// Enforce the masking direction required by the parser's role
+	if fp.requireMask && !frame.Masked {
+		return domain.ErrUnmaskedClientFrame
+	}
+	if fp.rejectMasked && frame.Masked {
+		return domain.ErrMaskedServerFrame
 	}
 
-	// Read payload
-	if payloadLen > 0 {
-		frame.Payload = make([]byte, payloadLen)
+	return nil
+}
+
+// readPayload reads the payload announced by a header returned from readHeader and
+// applies the ValidateFrame hook to the completed frame
+func (fp *FrameParser) readPayload(reader io.Reader, frame *domain.Frame) error {
+	if err := fp.fillPayload(reader, frame); err != nil {
+		fp.reportError(err)
+		return err
+	}
+	return nil
+}
+
+// fillPayload does the work of readPayload
+func (fp *FrameParser) fillPayload(reader io.Reader, frame *domain.Frame) error {
+	if frame.PayloadLen > 0 {
+		fp.allocatePayload(frame)
 		if _, err := io.ReadFull(reader, frame.Payload); err != nil {
-			return nil, err
+			frame.Release()
+			return err
 		}
 
 		// Unmask payload if masked
 		if frame.Masked {
 			fp.UnmaskPayload(frame.Payload, frame.MaskingKey)
 		}
+	} else {
+		// An empty payload is empty, not absent
+		frame.Payload = []byte{}
 	}
 
-	return frame, nil
+	if fp.Checksum && frame.IsDataFrame() {
+		if err := verifyChecksum(frame); err != nil {
+			frame.Release()
+			return err
+		}
+	}
+
+	if fp.ValidateFrame != nil {
+		if err := fp.ValidateFrame(frame); err != nil {
+			frame.Release()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PrefixReader returns a reader that yields the buffered prefix bytes before reading from reader.
+// Use it when some leading bytes of a frame were already consumed, e.g. after inspecting a header.
+func PrefixReader(prefix []byte, reader io.Reader) io.Reader {
+	if len(prefix) == 0 {
+		return reader
+	}
+	return io.MultiReader(bytes.NewReader(prefix), reader)
+}
+
+// ReadFrameWithPrefix reads a frame whose leading bytes are already held in prefix and whose
+// remaining bytes come from reader. The prefix must not extend past the end of the frame;
+// wrap the stream once with PrefixReader when it may hold more than one frame.
+func (fp *FrameParser) ReadFrameWithPrefix(prefix []byte, reader io.Reader) (*domain.Frame, error) {
+	return fp.ReadFrame(PrefixReader(prefix, reader))
+}
+
+// allocatePayload sizes frame.Payload for the announced length, drawing from the
+// buffer pool when the parser has one and CopyPayload is not set
+func (fp *FrameParser) allocatePayload(frame *domain.Frame) {
+	if fp.pool == nil || fp.CopyPayload {
+		frame.Payload = make([]byte, frame.PayloadLen)
+		return
+	}
+
+	buf, _ := fp.pool.Get().(*pooledBuffer)
+	if buf == nil {
+		buf = &pooledBuffer{pool: fp.pool}
+		buf.releaseFunc = buf.release
+	}
+	if uint64(cap(buf.data)) < frame.PayloadLen {
+		buf.data = make([]byte, frame.PayloadLen)
+	}
+	frame.Payload = buf.data[:frame.PayloadLen]
+	frame.SetRelease(buf.releaseFunc)
+}
+
+// pooledBuffer is a payload buffer owned by a parser's pool. The release method value is
+// created once per buffer so handing a frame its release hook does not allocate.
+type pooledBuffer struct {
+	data        []byte
+	pool        *sync.Pool
+	releaseFunc func()
+}
+
+// release returns the buffer to its pool
+func (b *pooledBuffer) release() {
+	b.pool.Put(b)
 }
 
 // parsePayloadLength parses the payload length based on the initial length value
@@ -111,7 +396,8 @@ func (fp *FrameParser) parsePayloadLength(reader io.Reader, initialLen uint64) (
 		if _, err := io.ReadFull(reader, buf); err != nil {
 			return 0, err
 		}
-		return uint64(binary.BigEndian.Uint16(buf)), nil
+		length := uint64(binary.BigEndian.Uint16(buf))
+		return length, fp.checkLengthEncoding(initialLen, length)
 
 	case protocol.PayloadLen64Bit:
 		// 64-bit extended payload length
@@ -119,7 +405,8 @@ func (fp *FrameParser) parsePayloadLength(reader io.Reader, initialLen uint64) (
 		if _, err := io.ReadFull(reader, buf); err != nil {
 			return 0, err
 		}
-		return binary.BigEndian.Uint64(buf), nil
+		length := binary.BigEndian.Uint64(buf)
+		return length, fp.checkLengthEncoding(initialLen, length)
 
 	default:
 		// 7-bit payload length
@@ -127,69 +414,123 @@ func (fp *FrameParser) parsePayloadLength(reader io.Reader, initialLen uint64) (
 	}
 }
 
+// checkLengthEncoding rejects a 64-bit length with its most significant bit set and, in
+// strict mode, an extended length field carrying a value that fits a shorter form
+func (fp *FrameParser) checkLengthEncoding(initialLen, length uint64) error {
+	// RFC 6455 requires the top bit of a 64-bit length to be 0
+	if initialLen == protocol.PayloadLen64Bit && length&(1<<63) != 0 {
+		return domain.ErrInvalidFrameStructure
+	}
+
+	if !fp.Strict {
+		return nil
+	}
+	switch {
+	case initialLen == protocol.PayloadLen16Bit && length <= protocol.MaxControlFramePayloadSize:
+		return domain.ErrInvalidFrameStructure
+	case initialLen == protocol.PayloadLen64Bit && length <= 0xFFFF:
+		return domain.ErrInvalidFrameStructure
+	}
+	return nil
+}
+
 // UnmaskPayload unmasks the payload using the masking key
 func (fp *FrameParser) UnmaskPayload(payload []byte, maskingKey [4]byte) {
-	for i := range payload {
-		payload[i] ^= maskingKey[i%4]
+	maskBytes(maskingKey, 0, payload)
+}
+
+// maskBytes XORs b with the masking key, starting at key offset pos, and returns the
+// offset for the byte following b so a payload can be masked in several pieces. The
+// bulk of b is processed eight bytes at a time.
+func maskBytes(key [4]byte, pos int, b []byte) int {
+	if len(b) >= 8 {
+		// Rotate the key to pos and repeat it across a 64-bit word; since 8 is a
+		// multiple of 4, the offset is the same at every word boundary
+		var word [8]byte
+		for i := range word {
+			word[i] = key[(pos+i)&3]
+		}
+		mask := binary.LittleEndian.Uint64(word[:])
+
+		for len(b) >= 8 {
+			binary.LittleEndian.PutUint64(b, binary.LittleEndian.Uint64(b)^mask)
+			b = b[8:]
+		}
+	}
+
+	for i := range b {
+		b[i] ^= key[pos&3]
+		pos++
 	}
+	return pos & 3
 }
 
-// WriteFrame writes a WebSocket frame to the writer
+// WriteFrame writes a WebSocket frame to the writer. When the writer is a net.Conn and
+// the frame is unmasked, header and payload go out in a single vectored write through
+// net.Buffers, without copying the payload.
 func (fp *FrameParser) WriteFrame(writer io.Writer, frame *domain.Frame) error {
-	// Validate frame before writing
-	if err := frame.Validate(); err != nil {
+	if err := fp.encodeFrame(writer, frame); err != nil {
+		fp.reportError(err)
 		return err
 	}
+	return nil
+}
 
-	// Build frame header
-	header := make([]byte, 0, 14) // Max header size
+// encodeFrame does the work of WriteFrame
+func (fp *FrameParser) encodeFrame(writer io.Writer, frame *domain.Frame) error {
+	// Validate frame before writing, leaving an RSV1 bit to the extension that uses it
+	if err := frame.ValidateWithExtensions([3]bool{fp.allowsRSV1(frame), false, false}); err != nil {
+		return err
+	}
 
-	// First byte: FIN, RSV1-3, Opcode
-	firstByte := byte(frame.Opcode)
-	if frame.FIN {
-		firstByte |= 0x80
+	if fp.Checksum && frame.IsDataFrame() {
+		frame = appendChecksum(frame)
 	}
-	if frame.RSV1 {
-		firstByte |= 0x40
+
+	// Client-role parsers mask every frame with a fresh key, leaving the caller's frame untouched
+	if fp.maskFrames && !frame.Masked {
+		masked, err := fp.withMaskingKey(frame)
+		if err != nil {
+			return err
+		}
+		frame = masked
 	}
-	if frame.RSV2 {
-		firstByte |= 0x20
+
+	if fp.MaxOutboundFrameSize > 0 && frame.PayloadLen > fp.MaxOutboundFrameSize {
+		return fmt.Errorf("%w: %d-byte frame exceeds outbound limit of %d bytes",
+			domain.ErrPayloadTooLarge, frame.PayloadLen, fp.MaxOutboundFrameSize)
 	}
-	if frame.RSV3 {
-		firstByte |= 0x10
+
+	if fp.ValidateFrame != nil {
+		if err := fp.ValidateFrame(frame); err != nil {
+			return err
+		}
 	}
-	header = append(header, firstByte)
 
-	// Second byte: MASK, Payload length
-	payloadLen := frame.PayloadLen
-	secondByte := byte(0)
-	if frame.Masked {
-		secondByte |= 0x80
-	}
-
-	// Determine payload length encoding
-	if payloadLen <= 125 {
-		secondByte |= byte(payloadLen)
-		header = append(header, secondByte)
-	} else if payloadLen <= 65535 {
-		secondByte |= protocol.PayloadLen16Bit
-		header = append(header, secondByte)
-		// Add 16-bit extended length
-		extLen := make([]byte, 2)
-		binary.BigEndian.PutUint16(extLen, uint16(payloadLen))
-		header = append(header, extLen...)
-	} else {
-		secondByte |= protocol.PayloadLen64Bit
-		header = append(header, secondByte)
-		// Add 64-bit extended length
-		extLen := make([]byte, 8)
-		binary.BigEndian.PutUint64(extLen, payloadLen)
-		header = append(header, extLen...)
+	header, err := frame.Header()
+	if err != nil {
+		return err
 	}
 
-	// Add masking key if masked
-	if frame.Masked {
-		header = append(header, frame.MaskingKey[:]...)
+	if err := fp.writeEncoded(writer, header, frame); err != nil {
+		return err
+	}
+
+	if fp.Metrics != nil {
+		fp.Metrics.OnFrameWritten(frame.Opcode, int(frame.PayloadLen))
+	}
+	return nil
+}
+
+// writeEncoded writes an encoded header followed by the frame's payload, masking a copy of
+// the payload when the frame is masked
+func (fp *FrameParser) writeEncoded(writer io.Writer, header []byte, frame *domain.Frame) error {
+	// An unmasked frame written straight to a connection goes out in one vectored write,
+	// without copying the payload
+	if conn, ok := writer.(net.Conn); ok && !frame.Masked {
+		buffers := net.Buffers{header, frame.Payload}
+		_, err := buffers.WriteTo(conn)
+		return err
 	}
 
 	// Write header
@@ -210,6 +551,203 @@ func (fp *FrameParser) WriteFrame(writer io.Writer, frame *domain.Frame) error {
 			return err
 		}
 	}
+	return nil
+}
+
+// reportRead tells Metrics about a frame whose header was read
+func (fp *FrameParser) reportRead(frame *domain.Frame) {
+	if fp.Metrics != nil {
+		fp.Metrics.OnFrameRead(frame.Opcode, int(frame.PayloadLen))
+	}
+}
+
+// reportError tells Metrics about an error that failed a read or write
+func (fp *FrameParser) reportError(err error) {
+	if fp.Metrics != nil && err != io.EOF {
+		fp.Metrics.OnError(err)
+	}
+}
+
+// WriteMaskedFrame writes frame masked with a fresh random key from Rand, as RFC 6455
+// requires of every client frame. The caller's frame is left untouched.
+func (fp *FrameParser) WriteMaskedFrame(writer io.Writer, frame *domain.Frame) error {
+	masked, err := fp.withMaskingKey(frame)
+	if err != nil {
+		return err
+	}
+	return fp.WriteFrame(writer, masked)
+}
+
+// WritePing writes a ping control frame carrying data
+func (fp *FrameParser) WritePing(writer io.Writer, data []byte) error {
+	return fp.writeControl(writer, domain.OpcodePing, data)
+}
+
+// WritePong writes a pong control frame carrying data
+func (fp *FrameParser) WritePong(writer io.Writer, data []byte) error {
+	return fp.writeControl(writer, domain.OpcodePong, data)
+}
+
+// writeControl writes a control frame, rejecting payloads over the 125-byte limit rather
+// than truncating them
+func (fp *FrameParser) writeControl(writer io.Writer, opcode domain.Opcode, data []byte) error {
+	if len(data) > protocol.MaxControlFramePayloadSize {
+		err := fmt.Errorf("%w: %d-byte %s payload exceeds the %d-byte control frame limit",
+			domain.ErrInvalidFrameStructure, len(data), opcode, protocol.MaxControlFramePayloadSize)
+		fp.reportError(err)
+		return err
+	}
+	return fp.WriteFrame(writer, domain.NewFrame(opcode, data))
+}
+
+// MaskFrame marks frame as masked with a fresh random key read from Rand. The payload is
+// left as is: WriteFrame applies the key as it writes, so the frame stays readable. Do
+// not mask a frame shared with other goroutines; mask a Clone instead.
+func (fp *FrameParser) MaskFrame(frame *domain.Frame) error {
+	source := fp.Rand
+	if source == nil {
+		source = rand.Reader
+	}
+
+	var key [4]byte
+	if _, err := io.ReadFull(source, key[:]); err != nil {
+		return fmt.Errorf("failed to generate masking key: %w", err)
+	}
+	frame.MaskingKey = key
+	frame.Masked = true
+	return nil
+}
+
+// withMaskingKey returns a masked copy of frame carrying a new random key
+func (fp *FrameParser) withMaskingKey(frame *domain.Frame) (*domain.Frame, error) {
+	masked := *frame
+	if err := fp.MaskFrame(&masked); err != nil {
+		return nil, err
+	}
+	return &masked, nil
+}
+
+// Marshal returns the wire encoding of frame, exactly as WriteFrame would write it
+func (fp *FrameParser) Marshal(frame *domain.Frame) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(frame.Payload) + 14)
+	if err := fp.WriteFrame(&buf, frame); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalMessage returns the wire encoding of an unfragmented message
+func (fp *FrameParser) MarshalMessage(msg *domain.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(msg.Payload) + 14)
+	if err := fp.WriteMessage(&buf, msg, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sharesWireFormat reports whether the parser writes messages exactly as a default
+// server parser would, so bytes marshaled once can be reused for it verbatim
+func (fp *FrameParser) sharesWireFormat() bool {
+	return !fp.maskFrames && !fp.Checksum && !fp.Deflate && fp.MaxOutboundFrameSize == 0 && fp.ValidateFrame == nil
+}
+
+// WriteMessage writes a data message, splitting its payload into frames of at most
+// fragmentSize bytes. The first frame carries the message opcode and later frames are
+// continuations; only the last has FIN set. A fragmentSize of 0 sends a single frame
+// unless MaxOutboundFrameSize forces fragmentation. With Deflate set the payload is
+// compressed first and fragment sizes apply to the compressed bytes. Only text and binary
+// messages are accepted: control frames must never be fragmented, so they go through
+// WriteFrame.
+func (fp *FrameParser) WriteMessage(writer io.Writer, msg *domain.Message, fragmentSize int) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+	if fragmentSize < 0 {
+		return fmt.Errorf("%w: negative fragment size %d", domain.ErrInvalidConfig, fragmentSize)
+	}
+
+	// Never emit a frame larger than the negotiated outbound limit
+	limit, err := fp.dataPayloadLimit()
+	if err != nil {
+		return err
+	}
+	if limit > 0 && (fragmentSize == 0 || uint64(fragmentSize) > limit) {
+		fragmentSize = int(limit)
+	}
+
+	payload := msg.Payload
+	if fp.Deflate {
+		compressed, err := compressPayload(payload)
+		if err != nil {
+			return err
+		}
+		payload = compressed
+	}
+
+	if fragmentSize == 0 || len(payload) <= fragmentSize {
+		frame := domain.NewFrame(msg.ToOpcode(), payload)
+		frame.RSV1 = fp.Deflate
+		return fp.WriteFrame(writer, frame)
+	}
+
+	fragmentSize, err = fp.checkFragmentCount(len(payload), fragmentSize)
+	if err != nil {
+		return err
+	}
+
+	opcode := msg.ToOpcode()
+	for offset := 0; offset < len(payload); offset += fragmentSize {
+		end := min(offset+fragmentSize, len(payload))
+		frame := domain.NewFrame(opcode, payload[offset:end])
+		frame.FIN = end == len(payload)
+		// Only the first frame of a compressed message carries RSV1
+		frame.RSV1 = fp.Deflate && offset == 0
+		if err := fp.WriteFrame(writer, frame); err != nil {
+			return err
+		}
+		opcode = domain.OpcodeContinuation
+	}
 
 	return nil
 }
+
+// dataPayloadLimit returns how much message data a frame may carry under
+// MaxOutboundFrameSize once the checksum trailer is accounted for, 0 meaning no limit
+func (fp *FrameParser) dataPayloadLimit() (uint64, error) {
+	limit := fp.MaxOutboundFrameSize
+	if limit == 0 || !fp.Checksum {
+		return limit, nil
+	}
+	if limit <= checksumSize {
+		return 0, fmt.Errorf("%w: outbound frame limit of %d bytes leaves no room for the checksum",
+			domain.ErrInvalidConfig, limit)
+	}
+	return limit - checksumSize, nil
+}
+
+// checkFragmentCount enforces MaxOutboundFragments, returning the fragment size to use
+func (fp *FrameParser) checkFragmentCount(payloadLen, fragmentSize int) (int, error) {
+	maxFragments := fp.MaxOutboundFragments
+	if maxFragments <= 0 {
+		maxFragments = protocol.MaxOutboundFragments
+	}
+
+	fragments := (payloadLen + fragmentSize - 1) / fragmentSize
+	if fragments <= maxFragments {
+		return fragmentSize, nil
+	}
+	if fp.ClampFragments {
+		clamped := (payloadLen + maxFragments - 1) / maxFragments
+		limit, err := fp.dataPayloadLimit()
+		if err != nil {
+			return 0, err
+		}
+		if limit == 0 || uint64(clamped) <= limit {
+			return clamped, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %d-byte fragments would split a %d-byte message into %d frames (limit %d)",
+		domain.ErrInvalidConfig, fragmentSize, payloadLen, fragments, maxFragments)
+}
@@ -1,7 +1,14 @@
 package infrastructure
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sync"
 	"testing"
 
 	"github.com/leanovate/gopter"
@@ -403,7 +410,7 @@ func TestProperty_InvalidFrameRejection(t *testing.T) {
 			}
 
 			// Verify it's one of the expected errors
-			if err != domain.ErrInvalidOpcode &&
+			if !errors.Is(err, domain.ErrInvalidOpcode) &&
 				err != domain.ErrReservedBitsSet &&
 				err != domain.ErrInvalidFrameStructure {
 				t.Logf("Unexpected error type: %v", err)
@@ -597,3 +604,1185 @@ func TestFrameParser_PongFrame(t *testing.T) {
 		t.Errorf("Payload mismatch")
 	}
 }
+
+func TestFrameParser_ReadFrameWithPrefix(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	payload := []byte("split across prefix and reader")
+
+	var buf bytes.Buffer
+	if err := parser.WriteFrame(&buf, domain.NewFrame(domain.OpcodeText, payload)); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	// Split inside the header, at the header boundary, and inside the payload
+	for _, split := range []int{1, 2, 5, len(encoded) - 1} {
+		prefix := encoded[:split]
+		rest := bytes.NewReader(encoded[split:])
+
+		frame, err := parser.ReadFrameWithPrefix(prefix, rest)
+		if err != nil {
+			t.Fatalf("split %d: Failed to read frame: %v", split, err)
+		}
+		if frame.Opcode != domain.OpcodeText {
+			t.Errorf("split %d: Expected opcode Text, got %v", split, frame.Opcode)
+		}
+		if !bytes.Equal(frame.Payload, payload) {
+			t.Errorf("split %d: Payload mismatch", split)
+		}
+	}
+}
+
+func TestPrefixReader_MultipleFrames(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+
+	var buf bytes.Buffer
+	_ = parser.WriteFrame(&buf, domain.NewFrame(domain.OpcodeText, []byte("first")))
+	_ = parser.WriteFrame(&buf, domain.NewFrame(domain.OpcodeBinary, []byte("second")))
+	encoded := buf.Bytes()
+
+	// The prefix holds the whole first frame and part of the second
+	reader := PrefixReader(encoded[:10], bytes.NewReader(encoded[10:]))
+
+	first, err := parser.ReadFrame(reader)
+	if err != nil || string(first.Payload) != "first" {
+		t.Fatalf("Failed to read first frame: %v", err)
+	}
+	second, err := parser.ReadFrame(reader)
+	if err != nil || string(second.Payload) != "second" {
+		t.Fatalf("Failed to read second frame: %v", err)
+	}
+}
+
+func TestFrameParser_WriteMessageFragmentLimit(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xAB}, 10000)
+	msg := domain.NewBinaryMessage(payload)
+
+	t.Run("rejects too many fragments", func(t *testing.T) {
+		parser := NewFrameParser(protocol.MaxPayloadSize)
+		parser.MaxOutboundFragments = 100
+
+		var buf bytes.Buffer
+		err := parser.WriteMessage(&buf, msg, 10)
+		if !errors.Is(err, domain.ErrInvalidConfig) {
+			t.Fatalf("Expected ErrInvalidConfig, got %v", err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("Expected nothing to be written, got %d bytes", buf.Len())
+		}
+	})
+
+	t.Run("clamps fragment size", func(t *testing.T) {
+		parser := NewFrameParser(protocol.MaxPayloadSize)
+		parser.MaxOutboundFragments = 100
+		parser.ClampFragments = true
+
+		var buf bytes.Buffer
+		if err := parser.WriteMessage(&buf, msg, 10); err != nil {
+			t.Fatalf("WriteMessage failed: %v", err)
+		}
+
+		frames := 0
+		var reassembled []byte
+		for buf.Len() > 0 {
+			frame, err := parser.ReadFrame(&buf)
+			if err != nil {
+				t.Fatalf("Failed to read frame: %v", err)
+			}
+			frames++
+			reassembled = append(reassembled, frame.Payload...)
+		}
+		if frames != 100 {
+			t.Errorf("Expected 100 frames, got %d", frames)
+		}
+		if !bytes.Equal(reassembled, payload) {
+			t.Errorf("Payload mismatch after clamped fragmentation")
+		}
+	})
+
+	t.Run("clamping never exceeds the outbound frame limit", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			checksum bool
+			limit    uint64
+		}{
+			{"frame limit", false, uint64(len(payload)/100) - 1},
+			{"frame limit with checksum", true, uint64(len(payload)/100) + 2},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				parser := NewFrameParser(protocol.MaxPayloadSize)
+				parser.MaxOutboundFragments = 100
+				parser.ClampFragments = true
+				parser.Checksum = tt.checksum
+				parser.MaxOutboundFrameSize = tt.limit
+
+				var buf bytes.Buffer
+				err := parser.WriteMessage(&buf, msg, 0)
+				if !errors.Is(err, domain.ErrInvalidConfig) {
+					t.Fatalf("Expected ErrInvalidConfig, got %v", err)
+				}
+				if buf.Len() != 0 {
+					t.Errorf("Expected nothing to be written, got %d bytes", buf.Len())
+				}
+			})
+		}
+	})
+}
+
+func TestFrameParser_MaxOutboundFrameSize(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	parser.MaxOutboundFrameSize = 100
+	payload := bytes.Repeat([]byte("x"), 250)
+
+	t.Run("WriteFrame rejects oversized frame", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := parser.WriteFrame(&buf, domain.NewFrame(domain.OpcodeText, payload))
+		if !errors.Is(err, domain.ErrPayloadTooLarge) {
+			t.Fatalf("Expected ErrPayloadTooLarge, got %v", err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("Expected nothing to be written, got %d bytes", buf.Len())
+		}
+	})
+
+	t.Run("WriteMessage splits oversized message", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := parser.WriteMessage(&buf, domain.NewTextMessage(payload), 0); err != nil {
+			t.Fatalf("WriteMessage failed: %v", err)
+		}
+
+		var sizes []uint64
+		for buf.Len() > 0 {
+			frame, err := parser.ReadFrame(&buf)
+			if err != nil {
+				t.Fatalf("Failed to read frame: %v", err)
+			}
+			sizes = append(sizes, frame.PayloadLen)
+		}
+		if len(sizes) != 3 || sizes[0] != 100 || sizes[1] != 100 || sizes[2] != 50 {
+			t.Errorf("Expected frame sizes [100 100 50], got %v", sizes)
+		}
+	})
+}
+
+func TestFrameParser_ValidateFrameHook(t *testing.T) {
+	errBinaryNotAllowed := errors.New("binary frames are not accepted")
+
+	writer := NewFrameParser(protocol.MaxPayloadSize)
+	var buf bytes.Buffer
+	_ = writer.WriteFrame(&buf, domain.NewFrame(domain.OpcodeText, []byte("text ok")))
+	_ = writer.WriteFrame(&buf, domain.NewFrame(domain.OpcodeBinary, []byte{0x01}))
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	parser.ValidateFrame = func(frame *domain.Frame) error {
+		if frame.Opcode == domain.OpcodeBinary {
+			return errBinaryNotAllowed
+		}
+		return nil
+	}
+
+	frame, err := parser.ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("Expected text frame to pass, got %v", err)
+	}
+	if string(frame.Payload) != "text ok" {
+		t.Errorf("Payload mismatch")
+	}
+
+	if _, err := parser.ReadFrame(&buf); !errors.Is(err, errBinaryNotAllowed) {
+		t.Errorf("Expected hook error on read, got %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := parser.WriteFrame(&out, domain.NewFrame(domain.OpcodeBinary, []byte{0x02})); !errors.Is(err, errBinaryNotAllowed) {
+		t.Errorf("Expected hook error on write, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected nothing to be written, got %d bytes", out.Len())
+	}
+}
+
+func TestPooledFrameParser_ReusesReleasedBuffers(t *testing.T) {
+	parser := NewPooledFrameParser(protocol.MaxPayloadSize)
+
+	var buf bytes.Buffer
+	_ = parser.WriteFrame(&buf, domain.NewFrame(domain.OpcodeBinary, []byte("first payload")))
+	_ = parser.WriteFrame(&buf, domain.NewFrame(domain.OpcodeBinary, []byte("second")))
+
+	first, err := parser.ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("Failed to read first frame: %v", err)
+	}
+	if string(first.Payload) != "first payload" {
+		t.Fatalf("Payload mismatch: %q", first.Payload)
+	}
+	first.Release()
+	if first.Payload != nil {
+		t.Error("Expected payload to be cleared by Release")
+	}
+
+	second, err := parser.ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("Failed to read second frame: %v", err)
+	}
+	if string(second.Payload) != "second" {
+		t.Errorf("Payload mismatch: %q", second.Payload)
+	}
+	second.Release()
+}
+
+func TestPooledFrameParser_CopyPayload(t *testing.T) {
+	parser := NewPooledFrameParser(protocol.MaxPayloadSize)
+	parser.CopyPayload = true
+
+	var buf bytes.Buffer
+	_ = parser.WriteFrame(&buf, domain.NewFrame(domain.OpcodeBinary, []byte("keep me around")))
+	_ = parser.WriteFrame(&buf, domain.NewFrame(domain.OpcodeBinary, []byte("overwrite!!!!!")))
+
+	first, err := parser.ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("Failed to read first frame: %v", err)
+	}
+	// Releasing an owned frame is harmless and must not recycle its payload
+	first.Release()
+
+	if _, err := parser.ReadFrame(&buf); err != nil {
+		t.Fatalf("Failed to read second frame: %v", err)
+	}
+	if string(first.Payload) != "keep me around" {
+		t.Errorf("Expected retained payload to survive the next read, got %q", first.Payload)
+	}
+}
+
+func TestFrameParser_SetMaxPayloadSize(t *testing.T) {
+	writer := NewFrameParser(protocol.MaxPayloadSize)
+	wire, err := writer.Marshal(domain.NewFrame(domain.OpcodeBinary, make([]byte, 100)))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	parser := NewFrameParser(1000)
+	if _, err := parser.ReadFrame(bytes.NewReader(wire)); err != nil {
+		t.Fatalf("expected the frame within the limit, got %v", err)
+	}
+
+	parser.SetMaxPayloadSize(50)
+	if _, err := parser.ReadFrame(bytes.NewReader(wire)); !errors.Is(err, domain.ErrPayloadTooLarge) {
+		t.Fatalf("expected ErrPayloadTooLarge after lowering the limit, got %v", err)
+	}
+
+	parser.SetMaxPayloadSize(0)
+	if got := parser.MaxPayloadSize(); got != protocol.MaxPayloadSize {
+		t.Errorf("expected zero to restore the default %d, got %d", protocol.MaxPayloadSize, got)
+	}
+
+	// Changing the limit while other goroutines read must be race-free
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, _ = parser.ReadFrame(bytes.NewReader(wire))
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		parser.SetMaxPayloadSize(uint64(50 + j))
+	}
+	wg.Wait()
+}
+
+func TestFrameParser_ReadFrameFromBufferedReader(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	first, _ := parser.Marshal(domain.NewFrame(domain.OpcodeText, []byte("pipelined")))
+	second, _ := parser.Marshal(domain.NewFrame(domain.OpcodeBinary, []byte("split across reads")))
+
+	// The first write carries a whole frame and half of the next, which the bufio.Reader
+	// buffers; the rest of the second frame only arrives on the connection afterwards
+	go func() {
+		_, _ = client.Write(append(append([]byte(nil), first...), second[:6]...))
+		_, _ = client.Write(second[6:])
+	}()
+
+	br := bufio.NewReader(server)
+	for _, expected := range []string{"pipelined", "split across reads"} {
+		frame, err := parser.ReadFrame(br)
+		if err != nil {
+			t.Fatalf("ReadFrame failed: %v", err)
+		}
+		if string(frame.Payload) != expected {
+			t.Errorf("expected payload %q, got %q", expected, frame.Payload)
+		}
+	}
+}
+
+func TestFrameParser_AllowLargeControlFrames(t *testing.T) {
+	// A 200-byte ping, as sent by a nonconforming peer
+	wire := append([]byte{0x89, 0x7E, 0x00, 0xC8}, bytes.Repeat([]byte("p"), 200)...)
+
+	strict := NewFrameParser(protocol.MaxPayloadSize)
+	if _, err := strict.ReadFrame(bytes.NewReader(wire)); !errors.Is(err, domain.ErrInvalidFrameStructure) {
+		t.Fatalf("expected the default parser to reject the ping, got %v", err)
+	}
+
+	lenient := NewFrameParser(protocol.MaxPayloadSize)
+	lenient.AllowLargeControlFrames = true
+	frame, err := lenient.ReadFrame(bytes.NewReader(wire))
+	if err != nil {
+		t.Fatalf("expected the lenient parser to accept the ping, got %v", err)
+	}
+	if frame.Opcode != domain.OpcodePing || len(frame.Payload) != 200 {
+		t.Errorf("unexpected frame: %s with %d bytes", frame.Opcode, len(frame.Payload))
+	}
+
+	// The payload limit still applies
+	lenient.SetMaxPayloadSize(100)
+	if _, err := lenient.ReadFrame(bytes.NewReader(wire)); !errors.Is(err, domain.ErrPayloadTooLarge) {
+		t.Errorf("expected ErrPayloadTooLarge, got %v", err)
+	}
+}
+
+// FuzzReadFrame checks that arbitrary input only ever produces an error or a frame that
+// satisfies the RFC 6455 invariants, never a panic or an oversized allocation
+func FuzzReadFrame(f *testing.F) {
+	writer := NewFrameParser(protocol.MaxPayloadSize)
+	for _, frame := range []*domain.Frame{
+		domain.NewFrame(domain.OpcodeText, []byte("Hello")),
+		domain.NewFrame(domain.OpcodeBinary, make([]byte, 200)),
+		domain.NewFrame(domain.OpcodePing, []byte("ping")),
+		newCloseFrame(f, protocol.StatusNormalClosure, "bye"),
+	} {
+		wire, err := writer.Marshal(frame)
+		if err != nil {
+			f.Fatalf("Marshal failed: %v", err)
+		}
+		f.Add(wire)
+	}
+	f.Add([]byte{0x82, 0xFF, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00})
+	f.Add([]byte{0x81, 0xFE, 0x00})
+	f.Add([]byte{0x89, 0x7E, 0x01, 0x00})
+
+	const limit = 4096
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, parser := range []*FrameParser{NewFrameParser(limit), NewServerFrameParser(limit)} {
+			frame, err := parser.ReadFrame(bytes.NewReader(data))
+			if err != nil {
+				continue
+			}
+			if frame.PayloadLen > limit || uint64(len(frame.Payload)) != frame.PayloadLen {
+				t.Fatalf("frame payload of %d bytes declared as %d", len(frame.Payload), frame.PayloadLen)
+			}
+			if frame.Opcode.IsControl() && (frame.PayloadLen > protocol.MaxControlFramePayloadSize || !frame.FIN) {
+				t.Fatalf("invalid control frame accepted: %+v", frame)
+			}
+			if frame.Opcode.IsReserved() || frame.RSV1 || frame.RSV2 || frame.RSV3 {
+				t.Fatalf("reserved opcode or bits accepted: %+v", frame)
+			}
+		}
+	})
+}
+
+// recordingMetrics counts the events a FrameParser reports
+type recordingMetrics struct {
+	read, written map[domain.Opcode]int
+	readBytes     int
+	writtenBytes  int
+	errs          []error
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{read: map[domain.Opcode]int{}, written: map[domain.Opcode]int{}}
+}
+
+func (m *recordingMetrics) OnFrameRead(opcode domain.Opcode, size int) {
+	m.read[opcode]++
+	m.readBytes += size
+}
+
+func (m *recordingMetrics) OnFrameWritten(opcode domain.Opcode, size int) {
+	m.written[opcode]++
+	m.writtenBytes += size
+}
+
+func (m *recordingMetrics) OnError(err error) {
+	m.errs = append(m.errs, err)
+}
+
+func TestFrameParser_Metrics(t *testing.T) {
+	metrics := newRecordingMetrics()
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	parser.Metrics = metrics
+
+	var buf bytes.Buffer
+	if err := parser.WriteFrame(&buf, domain.NewFrame(domain.OpcodeText, []byte("hello"))); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	if err := parser.WritePing(&buf, []byte("hi")); err != nil {
+		t.Fatalf("WritePing failed: %v", err)
+	}
+	if metrics.written[domain.OpcodeText] != 1 || metrics.written[domain.OpcodePing] != 1 || metrics.writtenBytes != 7 {
+		t.Errorf("unexpected write metrics: %v, %d bytes", metrics.written, metrics.writtenBytes)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := parser.ReadFrame(&buf); err != nil {
+			t.Fatalf("ReadFrame failed: %v", err)
+		}
+	}
+	if metrics.read[domain.OpcodeText] != 1 || metrics.read[domain.OpcodePing] != 1 || metrics.readBytes != 7 {
+		t.Errorf("unexpected read metrics: %v, %d bytes", metrics.read, metrics.readBytes)
+	}
+
+	// A clean end of stream is not an error, but protocol violations are
+	if _, err := parser.ReadFrame(&buf); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if len(metrics.errs) != 0 {
+		t.Fatalf("expected no errors reported for a clean EOF, got %v", metrics.errs)
+	}
+	if _, err := parser.ReadFrame(bytes.NewReader([]byte{0x83, 0x00})); !errors.Is(err, domain.ErrInvalidOpcode) {
+		t.Fatalf("expected ErrInvalidOpcode, got %v", err)
+	}
+	if err := parser.WritePong(&buf, make([]byte, 126)); err == nil {
+		t.Fatal("expected an oversized pong to fail")
+	}
+	if len(metrics.errs) != 2 || !errors.Is(metrics.errs[0], domain.ErrInvalidOpcode) {
+		t.Errorf("expected the read and write failures reported, got %v", metrics.errs)
+	}
+}
+
+func TestFrameParser_StrictLengthEncoding(t *testing.T) {
+	// frameWithLength builds an unmasked binary frame whose length uses the given form
+	frameWithLength := func(indicator byte, length int) []byte {
+		wire := []byte{0x82, indicator}
+		switch indicator {
+		case protocol.PayloadLen16Bit:
+			wire = binary.BigEndian.AppendUint16(wire, uint16(length))
+		case protocol.PayloadLen64Bit:
+			wire = binary.BigEndian.AppendUint64(wire, uint64(length))
+		}
+		return append(wire, make([]byte, length)...)
+	}
+
+	tests := []struct {
+		name    string
+		wire    []byte
+		wantErr error
+	}{
+		{"7-bit length", frameWithLength(10, 10), nil},
+		{"16-bit length holding 10", frameWithLength(protocol.PayloadLen16Bit, 10), domain.ErrInvalidFrameStructure},
+		{"16-bit length holding 125", frameWithLength(protocol.PayloadLen16Bit, 125), domain.ErrInvalidFrameStructure},
+		{"16-bit length holding 126", frameWithLength(protocol.PayloadLen16Bit, 126), nil},
+		{"64-bit length holding 10", frameWithLength(protocol.PayloadLen64Bit, 10), domain.ErrInvalidFrameStructure},
+		{"64-bit length holding 65535", frameWithLength(protocol.PayloadLen64Bit, 65535), domain.ErrInvalidFrameStructure},
+		{"64-bit length holding 65536", frameWithLength(protocol.PayloadLen64Bit, 65536), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strict := NewFrameParser(protocol.MaxPayloadSize)
+			strict.Strict = true
+
+			if _, err := strict.ReadFrame(bytes.NewReader(tt.wire)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ReadFrame: expected %v, got %v", tt.wantErr, err)
+			}
+			rr := NewRingFrameReader(strict, bytes.NewReader(tt.wire), 1<<17)
+			if _, err := rr.Next(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("RingFrameReader: expected %v, got %v", tt.wantErr, err)
+			}
+
+			// Lenient parsers accept every encoding
+			lenient := NewFrameParser(protocol.MaxPayloadSize)
+			if _, err := lenient.ReadFrame(bytes.NewReader(tt.wire)); err != nil {
+				t.Errorf("lenient ReadFrame: unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestFrameParser_RejectsLengthWithHighBitSet(t *testing.T) {
+	wire := []byte{0x82, protocol.PayloadLen64Bit, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+	// The top bit is checked even when the remaining bits would pass the size limit
+	parser := NewFrameParser(^uint64(0))
+	if _, err := parser.ReadFrame(bytes.NewReader(wire)); !errors.Is(err, domain.ErrInvalidFrameStructure) {
+		t.Errorf("ReadFrame: expected ErrInvalidFrameStructure, got %v", err)
+	}
+	rr := NewRingFrameReader(parser, bytes.NewReader(wire), 64)
+	if _, err := rr.Next(); !errors.Is(err, domain.ErrInvalidFrameStructure) {
+		t.Errorf("RingFrameReader: expected ErrInvalidFrameStructure, got %v", err)
+	}
+}
+
+func TestFrameParser_FrameHeaderScatterWrite(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	payload := bytes.Repeat([]byte("zero-copy "), 20)
+	frame := domain.NewFrame(domain.OpcodeBinary, payload)
+
+	var want bytes.Buffer
+	if err := parser.WriteFrame(&want, frame); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+
+	header, err := frame.Header()
+	if err != nil {
+		t.Fatalf("Header failed: %v", err)
+	}
+	var got bytes.Buffer
+	buffers := net.Buffers{header, payload}
+	if _, err := buffers.WriteTo(&got); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Error("expected header plus payload to match WriteFrame's output")
+	}
+}
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(tb testing.TB) (client, server net.Conn) {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	client, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		tb.Fatalf("Dial failed: %v", err)
+	}
+	server = <-accepted
+	if server == nil {
+		tb.Fatal("Accept failed")
+	}
+	tb.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestFrameParser_WriteFrameToNetConn(t *testing.T) {
+	client, server := tcpPair(t)
+	writer := NewFrameParser(protocol.MaxPayloadSize)
+	reader := NewFrameParser(protocol.MaxPayloadSize)
+
+	masked := domain.NewFrame(domain.OpcodeText, []byte("masked"))
+	masked.Masked = true
+	masked.MaskingKey = [4]byte{0x0A, 0x0B, 0x0C, 0x0D}
+	frames := []*domain.Frame{
+		domain.NewFrame(domain.OpcodeBinary, bytes.Repeat([]byte{0x5A}, 70000)),
+		domain.NewFrame(domain.OpcodeText, nil),
+		masked,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		for _, frame := range frames {
+			if err := writer.WriteFrame(server, frame); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+
+	for _, want := range frames {
+		got, err := reader.ReadFrame(client)
+		if err != nil {
+			t.Fatalf("ReadFrame failed: %v", err)
+		}
+		if got.Opcode != want.Opcode || !bytes.Equal(got.Payload, want.Payload) {
+			t.Errorf("expected %s frame of %d bytes, got %s frame of %d bytes",
+				want.Opcode, len(want.Payload), got.Opcode, len(got.Payload))
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	if string(masked.Payload) != "masked" {
+		t.Error("expected the masked frame's payload left untouched")
+	}
+}
+
+func BenchmarkFrameParser_WriteFrame64KB(b *testing.B) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	frame := domain.NewFrame(domain.OpcodeBinary, make([]byte, 64<<10))
+
+	run := func(b *testing.B, wrap func(net.Conn) io.Writer) {
+		client, server := tcpPair(b)
+		go io.Copy(io.Discard, client)
+		writer := wrap(server)
+
+		b.ReportAllocs()
+		b.SetBytes(int64(len(frame.Payload)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := parser.WriteFrame(writer, frame); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("net.Buffers", func(b *testing.B) {
+		run(b, func(conn net.Conn) io.Writer { return conn })
+	})
+	b.Run("two writes", func(b *testing.B) {
+		run(b, func(conn net.Conn) io.Writer { return struct{ io.Writer }{conn} })
+	})
+}
+
+func TestFrameParser_WriteFrameRSV1RoundTrip(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	parser.Deflate = true
+
+	frame := domain.NewFrame(domain.OpcodeBinary, []byte("compressed bytes"))
+	frame.RSV1 = true
+	var buf bytes.Buffer
+	if err := parser.WriteFrame(&buf, frame); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	if buf.Bytes()[0] != 0xC2 {
+		t.Errorf("expected FIN and RSV1 on a binary frame (0xC2), got %#x", buf.Bytes()[0])
+	}
+
+	read, err := parser.ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if !read.RSV1 || string(read.Payload) != "compressed bytes" {
+		t.Errorf("expected RSV1 and the payload preserved, got RSV1=%v %q", read.RSV1, read.Payload)
+	}
+
+	// permessage-deflate gives RSV1 no meaning on control frames
+	ping := domain.NewFrame(domain.OpcodePing, nil)
+	ping.RSV1 = true
+	if err := parser.WriteFrame(&buf, ping); !errors.Is(err, domain.ErrReservedBitsSet) {
+		t.Errorf("expected ErrReservedBitsSet for RSV1 on a ping, got %v", err)
+	}
+}
+
+func TestFrameParser_AllowRSV1(t *testing.T) {
+	allow := NewFrameParser(protocol.MaxPayloadSize)
+	allow.AllowRSV1 = true
+
+	tests := []struct {
+		name    string
+		parser  *FrameParser
+		wire    []byte
+		wantErr error
+	}{
+		{"RSV1 rejected by default", NewFrameParser(protocol.MaxPayloadSize), []byte{0xC2, 0x00}, domain.ErrReservedBitsSet},
+		{"RSV1 on binary", allow, []byte{0xC2, 0x00}, nil},
+		{"RSV1 on continuation", allow, []byte{0xC0, 0x00}, nil},
+		{"RSV1 on ping", allow, []byte{0xC9, 0x00}, nil},
+		{"RSV2 still rejected", allow, []byte{0xA2, 0x00}, domain.ErrReservedBitsSet},
+		{"RSV3 still rejected", allow, []byte{0x92, 0x00}, domain.ErrReservedBitsSet},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := tt.parser.ReadFrame(bytes.NewReader(tt.wire))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			// Frames read with RSV1 can be written back unchanged
+			if !frame.RSV1 {
+				t.Error("expected RSV1 to be reported")
+			}
+			var out bytes.Buffer
+			if err := tt.parser.WriteFrame(&out, frame); err != nil {
+				t.Fatalf("WriteFrame failed: %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), tt.wire) {
+				t.Errorf("expected %x, got %x", tt.wire, out.Bytes())
+			}
+		})
+	}
+
+	// Without AllowRSV1 writes are validated as before
+	frame := domain.NewFrame(domain.OpcodeBinary, nil)
+	frame.RSV1 = true
+	if err := NewFrameParser(protocol.MaxPayloadSize).WriteFrame(io.Discard, frame); !errors.Is(err, domain.ErrReservedBitsSet) {
+		t.Errorf("expected ErrReservedBitsSet writing RSV1, got %v", err)
+	}
+}
+
+func TestFrameParser_RejectionsMapToCloseCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		wire     []byte
+		expected uint16
+	}{
+		{"unmasked client frame", []byte{0x81, 0x00}, protocol.StatusProtocolError},
+		{"reserved bits", []byte{0xF1, 0x80, 0, 0, 0, 0}, protocol.StatusProtocolError},
+		{"reserved opcode", []byte{0x83, 0x80, 0, 0, 0, 0}, protocol.StatusProtocolError},
+		{"payload too large", []byte{0x82, 0x80 | protocol.PayloadLen64Bit, 0, 0, 0, 1, 0, 0, 0, 0}, protocol.StatusMessageTooBig},
+	}
+
+	parser := NewServerFrameParser(protocol.MaxPayloadSize)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parser.ReadFrame(bytes.NewReader(tt.wire))
+			if err == nil {
+				t.Fatal("expected the frame to be rejected")
+			}
+			if code := domain.CloseCodeForError(err); code != tt.expected {
+				t.Errorf("expected close code %d for %v, got %d", tt.expected, err, code)
+			}
+		})
+	}
+}
+
+// benchmarkReadFrame reads 4KB binary frames from a steady stream, releasing each one
+func benchmarkReadFrame(b *testing.B, parser *FrameParser) {
+	var wire bytes.Buffer
+	if err := parser.WriteFrame(&wire, domain.NewFrame(domain.OpcodeBinary, make([]byte, 4096))); err != nil {
+		b.Fatal(err)
+	}
+	reader := &repeatReader{data: wire.Bytes()}
+
+	b.ReportAllocs()
+	b.SetBytes(4096)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		frame, err := parser.ReadFrame(reader)
+		if err != nil {
+			b.Fatal(err)
+		}
+		frame.Release()
+	}
+}
+
+func BenchmarkFrameParser_ReadFrame4KB(b *testing.B) {
+	benchmarkReadFrame(b, NewFrameParser(protocol.MaxPayloadSize))
+}
+
+func BenchmarkPooledFrameParser_ReadFrame4KB(b *testing.B) {
+	benchmarkReadFrame(b, NewPooledFrameParser(protocol.MaxPayloadSize))
+}
+
+func TestFrameParser_MarshalMatchesWriteFrame(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	frame := domain.NewFrame(domain.OpcodeBinary, bytes.Repeat([]byte{0xAB}, 300))
+
+	var buf bytes.Buffer
+	if err := parser.WriteFrame(&buf, frame); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	data, err := parser.Marshal(frame)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !bytes.Equal(data, buf.Bytes()) {
+		t.Errorf("Marshal output differs from WriteFrame")
+	}
+
+	if _, err := parser.Marshal(domain.NewFrame(domain.OpcodePing, make([]byte, 126))); !errors.Is(err, domain.ErrInvalidFrameStructure) {
+		t.Errorf("Expected invalid frames to be rejected, got %v", err)
+	}
+
+	// A declared length out of sync with the payload fails instead of sizing the buffer
+	for _, payloadLen := range []uint64{1 << 40, math.MaxUint64} {
+		frame := domain.NewFrame(domain.OpcodeBinary, []byte("short"))
+		frame.PayloadLen = payloadLen
+		if _, err := parser.Marshal(frame); err == nil {
+			t.Errorf("Expected an error for PayloadLen %d with a 5-byte payload", payloadLen)
+		}
+	}
+}
+
+func TestFrameParser_ReservedOpcodeReported(t *testing.T) {
+	tests := []struct {
+		opcode   domain.Opcode
+		expected string
+	}{
+		{0x5, "invalid opcode 0x5"},
+		{0x3, "invalid opcode 0x3"},
+		{0xB, "invalid opcode 0xB"},
+		{0xF, "invalid opcode 0xF"},
+	}
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			_, err := parser.ReadFrame(bytes.NewReader([]byte{0x80 | byte(tt.opcode), 0x00}))
+			if !errors.Is(err, domain.ErrInvalidOpcode) {
+				t.Fatalf("Expected ErrInvalidOpcode, got %v", err)
+			}
+
+			var frameErr *domain.FrameError
+			if !errors.As(err, &frameErr) || frameErr.Opcode != tt.opcode {
+				t.Fatalf("Expected FrameError carrying opcode 0x%X, got %#v", byte(tt.opcode), err)
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestServerFrameParser_RequiresMaskedFrames(t *testing.T) {
+	client, err := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+	if err != nil {
+		t.Fatalf("NewFrameParserWithConfig failed: %v", err)
+	}
+	server := NewServerFrameParser(protocol.MaxPayloadSize)
+
+	tests := []struct {
+		name    string
+		writer  *FrameParser
+		wantErr error
+	}{
+		{"masked client frame accepted", client, nil},
+		{"unmasked frame rejected", NewFrameParser(protocol.MaxPayloadSize), domain.ErrUnmaskedClientFrame},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.writer.WriteFrame(&buf, domain.NewFrame(domain.OpcodeText, []byte("hello"))); err != nil {
+				t.Fatalf("WriteFrame failed: %v", err)
+			}
+
+			frame, err := server.ReadFrame(&buf)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && string(frame.Payload) != "hello" {
+				t.Errorf("Payload mismatch: %q", frame.Payload)
+			}
+		})
+	}
+}
+
+func TestFrameParser_WriteMaskedFrame(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	parser.Rand = bytes.NewReader([]byte{0x37, 0xFA, 0x21, 0x3D})
+
+	frame := domain.NewFrame(domain.OpcodeText, []byte("Hello"))
+	var buf bytes.Buffer
+	if err := parser.WriteMaskedFrame(&buf, frame); err != nil {
+		t.Fatalf("WriteMaskedFrame failed: %v", err)
+	}
+	if frame.Masked {
+		t.Error("WriteMaskedFrame must not modify the caller's frame")
+	}
+
+	// RFC 6455 section 5.7: a single-frame masked text message containing "Hello"
+	expected := []byte{0x81, 0x85, 0x37, 0xFA, 0x21, 0x3D, 0x7F, 0x9F, 0x4D, 0x51, 0x58}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Expected %x, got %x", expected, buf.Bytes())
+	}
+
+	// An exhausted randomness source must fail the write rather than reuse a key
+	if err := parser.WriteMaskedFrame(&buf, frame); err == nil {
+		t.Error("Expected an error once the randomness source is exhausted")
+	}
+}
+
+func TestFrameParser_ZeroLengthFrames(t *testing.T) {
+	tests := []struct {
+		name   string
+		parser *FrameParser
+		wire   []byte
+	}{
+		{"empty text", NewFrameParser(protocol.MaxPayloadSize), []byte{0x81, 0x00}},
+		{"empty continuation", NewFrameParser(protocol.MaxPayloadSize), []byte{0x00, 0x00}},
+		{"empty ping", NewFrameParser(protocol.MaxPayloadSize), []byte{0x89, 0x00}},
+		{"empty masked binary", NewServerFrameParser(protocol.MaxPayloadSize), []byte{0x82, 0x80, 0x01, 0x02, 0x03, 0x04}},
+		{"empty pooled text", NewPooledFrameParser(protocol.MaxPayloadSize), []byte{0x81, 0x00}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := tt.parser.ReadFrame(bytes.NewReader(tt.wire))
+			if err != nil {
+				t.Fatalf("ReadFrame failed: %v", err)
+			}
+			if frame.Payload == nil || len(frame.Payload) != 0 || frame.PayloadLen != 0 {
+				t.Errorf("expected an empty non-nil payload, got %#v (len %d)", frame.Payload, frame.PayloadLen)
+			}
+		})
+	}
+}
+
+func TestFrameParser_AllowedOpcodes(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	parser.AllowedOpcodes = []domain.Opcode{domain.OpcodeBinary}
+
+	tests := []struct {
+		name    string
+		frame   *domain.Frame
+		wantErr error
+	}{
+		{"binary allowed", domain.NewFrame(domain.OpcodeBinary, []byte("ok")), nil},
+		{"text rejected", domain.NewFrame(domain.OpcodeText, []byte("no")), domain.ErrInvalidOpcode},
+		{"continuation always allowed", fragment(domain.OpcodeContinuation, "more", true), nil},
+		{"close always allowed", newCloseFrame(t, protocol.StatusNormalClosure, ""), nil},
+		{"ping always allowed", domain.NewFrame(domain.OpcodePing, nil), nil},
+		{"pong always allowed", domain.NewFrame(domain.OpcodePong, nil), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := NewFrameParser(protocol.MaxPayloadSize).WriteFrame(&buf, tt.frame); err != nil {
+				t.Fatalf("WriteFrame failed: %v", err)
+			}
+			_, err := parser.ReadFrame(&buf)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadFrame() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var frameErr *domain.FrameError
+			if tt.wantErr != nil && (!errors.As(err, &frameErr) || frameErr.Opcode != tt.frame.Opcode) {
+				t.Errorf("expected a FrameError naming opcode %s, got %v", tt.frame.Opcode, err)
+			}
+		})
+	}
+}
+
+func TestFrameParser_MaskFrame(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	parser.Rand = bytes.NewReader([]byte{0x37, 0xFA, 0x21, 0x3D})
+
+	frame := domain.NewFrame(domain.OpcodeText, []byte("Hello"))
+	if err := parser.MaskFrame(frame); err != nil {
+		t.Fatalf("MaskFrame failed: %v", err)
+	}
+	if !frame.Masked || frame.MaskingKey != [4]byte{0x37, 0xFA, 0x21, 0x3D} {
+		t.Fatalf("expected the key from Rand, got masked=%v key=%x", frame.Masked, frame.MaskingKey)
+	}
+	if string(frame.Payload) != "Hello" {
+		t.Errorf("MaskFrame must leave the payload for WriteFrame to mask, got %q", frame.Payload)
+	}
+
+	// RFC 6455 section 5.7: a single-frame masked text message containing "Hello"
+	wire, err := parser.Marshal(frame)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	expected := []byte{0x81, 0x85, 0x37, 0xFA, 0x21, 0x3D, 0x7F, 0x9F, 0x4D, 0x51, 0x58}
+	if !bytes.Equal(wire, expected) {
+		t.Errorf("Expected %x, got %x", expected, wire)
+	}
+
+	// The default source is crypto/rand; an exhausted source fails instead
+	if err := parser.MaskFrame(frame); err == nil {
+		t.Error("Expected an error once the randomness source is exhausted")
+	}
+	parser.Rand = nil
+	if err := parser.MaskFrame(frame); err != nil {
+		t.Errorf("MaskFrame with crypto/rand failed: %v", err)
+	}
+}
+
+func TestFrameParser_WritePingPong(t *testing.T) {
+	tests := []struct {
+		name    string
+		write   func(*FrameParser, io.Writer, []byte) error
+		opcode  domain.Opcode
+		data    []byte
+		wantErr bool
+	}{
+		{"ping", (*FrameParser).WritePing, domain.OpcodePing, []byte("are you there"), false},
+		{"empty pong", (*FrameParser).WritePong, domain.OpcodePong, nil, false},
+		{"ping at limit", (*FrameParser).WritePing, domain.OpcodePing, bytes.Repeat([]byte("a"), 125), false},
+		{"oversized ping", (*FrameParser).WritePing, domain.OpcodePing, bytes.Repeat([]byte("a"), 126), true},
+		{"oversized pong", (*FrameParser).WritePong, domain.OpcodePong, bytes.Repeat([]byte("a"), 200), true},
+	}
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := tt.write(parser, &buf, tt.data)
+			if tt.wantErr {
+				if !errors.Is(err, domain.ErrInvalidFrameStructure) {
+					t.Fatalf("expected ErrInvalidFrameStructure, got %v", err)
+				}
+				if buf.Len() != 0 {
+					t.Errorf("expected nothing written, got %d bytes", buf.Len())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+
+			frame, err := parser.ReadFrame(&buf)
+			if err != nil {
+				t.Fatalf("ReadFrame failed: %v", err)
+			}
+			if frame.Opcode != tt.opcode || !frame.FIN || !bytes.Equal(frame.Payload, tt.data) {
+				t.Errorf("got %s frame (FIN=%v) with payload %q", frame.Opcode, frame.FIN, frame.Payload)
+			}
+		})
+	}
+}
+
+func TestFrameParser_WriteMessageFragments(t *testing.T) {
+	tests := []struct {
+		name         string
+		payloadLen   int
+		fragmentSize int
+		sizes        []uint64
+	}{
+		{"single frame when fragment size is zero", 25, 0, []uint64{25}},
+		{"single frame when payload fits", 8, 10, []uint64{8}},
+		{"uneven split", 25, 10, []uint64{10, 10, 5}},
+		{"even split", 20, 10, []uint64{10, 10}},
+		{"empty payload", 0, 10, []uint64{0}},
+	}
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := bytes.Repeat([]byte{0x5A}, tt.payloadLen)
+			var buf bytes.Buffer
+			if err := parser.WriteMessage(&buf, domain.NewBinaryMessage(payload), tt.fragmentSize); err != nil {
+				t.Fatalf("WriteMessage failed: %v", err)
+			}
+			wire := bytes.NewReader(buf.Bytes())
+
+			for i, size := range tt.sizes {
+				frame, err := parser.ReadFrame(wire)
+				if err != nil {
+					t.Fatalf("Failed to read frame %d: %v", i, err)
+				}
+
+				expectedOpcode := domain.OpcodeContinuation
+				if i == 0 {
+					expectedOpcode = domain.OpcodeBinary
+				}
+				last := i == len(tt.sizes)-1
+				if frame.Opcode != expectedOpcode || frame.FIN != last || frame.PayloadLen != size {
+					t.Errorf("Frame %d: got opcode=%s FIN=%v len=%d, want opcode=%s FIN=%v len=%d",
+						i, frame.Opcode, frame.FIN, frame.PayloadLen, expectedOpcode, last, size)
+				}
+			}
+			if wire.Len() != 0 {
+				t.Errorf("Expected exactly %d frames, %d bytes left over", len(tt.sizes), wire.Len())
+			}
+
+			// The fragments reassemble into the original message
+			msg, err := NewMessageReader(parser, bytes.NewReader(buf.Bytes()), 0).ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage failed: %v", err)
+			}
+			if !bytes.Equal(msg.Payload, payload) {
+				t.Errorf("Reassembled payload mismatch")
+			}
+		})
+	}
+}
+
+func TestFrameParser_WriteMessageRejectsInvalidInput(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+
+	tests := []struct {
+		name         string
+		msg          *domain.Message
+		fragmentSize int
+		wantErr      error
+	}{
+		// Messages only carry data; control frames are never built by WriteMessage
+		{"non-data message type", &domain.Message{Type: domain.MessageType(99), Payload: []byte("x")}, 0, domain.ErrInvalidMessageType},
+		{"negative fragment size", domain.NewBinaryMessage([]byte("x")), -1, domain.ErrInvalidConfig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := parser.WriteMessage(&buf, tt.msg, tt.fragmentSize); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("Expected nothing to be written, got %d bytes", buf.Len())
+			}
+		})
+	}
+}
+
+// maskBytesByteWise is the straightforward reference implementation of maskBytes
+func maskBytesByteWise(key [4]byte, pos int, b []byte) int {
+	for i := range b {
+		b[i] ^= key[pos&3]
+		pos++
+	}
+	return pos & 3
+}
+
+func TestMaskBytes_MatchesByteWise(t *testing.T) {
+	key := [4]byte{0x12, 0x34, 0x56, 0x78}
+	for length := 0; length <= 40; length++ {
+		for pos := 0; pos < 4; pos++ {
+			payload := make([]byte, length)
+			for i := range payload {
+				payload[i] = byte(i * 7)
+			}
+			expected := append([]byte(nil), payload...)
+
+			wantPos := maskBytesByteWise(key, pos, expected)
+			gotPos := maskBytes(key, pos, payload)
+			if !bytes.Equal(payload, expected) || gotPos != wantPos {
+				t.Fatalf("length %d, offset %d: got %x (next %d), want %x (next %d)",
+					length, pos, payload, gotPos, expected, wantPos)
+			}
+		}
+	}
+}
+
+func TestMaskBytes_InPieces(t *testing.T) {
+	key := [4]byte{0xDE, 0xAD, 0xBE, 0xEF}
+	payload := bytes.Repeat([]byte("split across calls "), 5)
+
+	whole := append([]byte(nil), payload...)
+	maskBytes(key, 0, whole)
+
+	// Masking in unevenly sized pieces must carry the key offset across calls
+	rebuilt := append([]byte(nil), payload...)
+	pos, offset := 0, 0
+	for _, n := range []int{3, 9, 1, 17, len(rebuilt) - 30} {
+		pos = maskBytes(key, pos, rebuilt[offset:offset+n])
+		offset += n
+	}
+	if !bytes.Equal(rebuilt, whole) {
+		t.Errorf("piecewise masking differs from masking the whole payload")
+	}
+}
+
+func BenchmarkUnmaskPayload_1MB(b *testing.B) {
+	key := [4]byte{0x37, 0xFA, 0x21, 0x3D}
+	payload := make([]byte, 1<<20)
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+
+	b.Run("word-wise", func(b *testing.B) {
+		b.SetBytes(int64(len(payload)))
+		for i := 0; i < b.N; i++ {
+			parser.UnmaskPayload(payload, key)
+		}
+	})
+	b.Run("byte-wise", func(b *testing.B) {
+		b.SetBytes(int64(len(payload)))
+		for i := 0; i < b.N; i++ {
+			maskBytesByteWise(key, 0, payload)
+		}
+	})
+}
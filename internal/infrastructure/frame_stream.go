@@ -0,0 +1,60 @@
+package infrastructure
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"websocket-server/internal/domain"
+)
+
+// readDeadliner is implemented by readers whose blocking reads can be interrupted, such as net.Conn
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// StreamFrames reads frames from reader on a dedicated goroutine and delivers them on the
+// returned frame channel for select-based pipelines. The goroutine stops at the first read
+// error, which is sent on the error channel, or when ctx is cancelled, in which case
+// ctx.Err() is sent. Both channels are closed when it exits.
+//
+// When reader implements SetReadDeadline (e.g. net.Conn), cancelling ctx also interrupts a
+// read in progress. Other readers are only observed between frames, so the goroutine exits
+// once its current read returns.
+func (fp *FrameParser) StreamFrames(ctx context.Context, reader io.Reader) (<-chan *domain.Frame, <-chan error) {
+	frames := make(chan *domain.Frame)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(frames)
+
+		if dr, ok := reader.(readDeadliner); ok {
+			stop := context.AfterFunc(ctx, func() {
+				dr.SetReadDeadline(time.Now())
+			})
+			defer stop()
+		}
+
+		for {
+			frame, err := fp.ReadFrame(reader)
+			if err != nil {
+				// A read interrupted by cancellation reports the cancellation, not the deadline
+				if ctx.Err() != nil {
+					err = ctx.Err()
+				}
+				errs <- err
+				return
+			}
+
+			select {
+			case frames <- frame:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return frames, errs
+}
@@ -0,0 +1,77 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+func TestFrameParser_StreamFramesStopsOnCancel(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	frames, errs := parser.StreamFrames(ctx, server)
+
+	go parser.WriteFrame(client, domain.NewFrame(domain.OpcodeText, []byte("first")))
+
+	select {
+	case frame := <-frames:
+		if string(frame.Payload) != "first" {
+			t.Errorf("Expected payload 'first', got %q", frame.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for frame")
+	}
+
+	// The stream is now blocked reading the next frame; cancelling must unblock it
+	cancel()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stream did not stop after cancel")
+	}
+
+	if _, ok := <-frames; ok {
+		t.Error("Expected frame channel to be closed")
+	}
+	if _, ok := <-errs; ok {
+		t.Error("Expected error channel to be closed")
+	}
+}
+
+func TestFrameParser_StreamFramesReportsReadError(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	frames, errs := parser.StreamFrames(context.Background(), server)
+
+	client.Close()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("Expected io.EOF, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stream did not report the read error")
+	}
+	if _, ok := <-frames; ok {
+		t.Error("Expected frame channel to be closed")
+	}
+}
@@ -1,17 +1,74 @@
 package infrastructure
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
 	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
 
+	"websocket-server/internal/domain"
 	"websocket-server/pkg/protocol"
 )
 
+// DefaultHandshakeReadBufferSize is the size of the buffer UpgradeConn reads the
+// handshake request through when HandshakeReadBufferSize is zero
+const DefaultHandshakeReadBufferSize = 4096
+
 // HandshakeValidator validates WebSocket handshake requests and performs upgrades
-type HandshakeValidator struct{}
+type HandshakeValidator struct {
+	RequireSecure  bool     // Reject handshakes that did not arrive over TLS (wss only)
+	ForwardHeaders []string // Request headers copied onto the upgraded Connection
+
+	// NotWebSocketHandler, when set, serves requests that carry no Upgrade header at all
+	// instead of answering 400, e.g. http.NotFoundHandler() or the application's regular
+	// HTTP mux. Malformed upgrade attempts still get 400.
+	NotWebSocketHandler http.Handler
+
+	// CheckOrigin, when set, decides whether the request's Origin may upgrade; returning
+	// false answers 403 Forbidden. When nil every origin is accepted.
+	CheckOrigin func(req *http.Request) bool
+
+	// EnableCompression makes PerformUpgrade, Hijack and UpgradeConn accept
+	// permessage-deflate when the client offers it (see NegotiateDeflate). Check the
+	// outcome with DeflateNegotiated(w.Header()), or HandshakeResult.Compression, and set
+	// FrameParser.Deflate accordingly.
+	EnableCompression bool
+
+	// SupportedVersions lists the Sec-WebSocket-Version values accepted, defaulting to
+	// just "13". Rejected requests are told the supported versions in the 400 response.
+	SupportedVersions []string
+
+	// Subprotocols lists the subprotocols the server speaks, most preferred first. The
+	// first one the client also offers in Sec-WebSocket-Protocol is selected.
+	Subprotocols []string
+
+	// ProtocolSelector, when set, chooses the subprotocol instead of Subprotocols, e.g.
+	// by authenticated user or path. It receives the protocols the client offered, in
+	// order, and returns one of them, or "" for none. Any other value is ignored.
+	ProtocolSelector func(offered []string, req *http.Request) string
+
+	// RemoteAddrFunc, when set, returns the client address recorded as the Connection's
+	// RemoteAddr, e.g. one taken from proxy headers with ForwardedRemoteAddr. An empty
+	// result falls back to req.RemoteAddr, the default.
+	RemoteAddrFunc func(req *http.Request) string
+
+	// HandshakeReadBufferSize sizes the buffer UpgradeConn reads the request through,
+	// zero meaning DefaultHandshakeReadBufferSize. Headers longer than the buffer still
+	// parse, over more reads; raise it when clients routinely send large cookies or
+	// tokens. Upgrades through net/http use the server's own buffers instead.
+	HandshakeReadBufferSize int
+}
 
 // NewHandshakeValidator creates a new HandshakeValidator
 func NewHandshakeValidator() *HandshakeValidator {
@@ -20,16 +77,21 @@ func NewHandshakeValidator() *HandshakeValidator {
 
 // ValidateRequest validates that the HTTP request contains all required WebSocket handshake headers
 func (h *HandshakeValidator) ValidateRequest(req *http.Request) error {
-	// Validate Upgrade header
-	upgrade := req.Header.Get(protocol.HeaderUpgrade)
-	if !strings.EqualFold(upgrade, protocol.HeaderValueWebSocket) {
-		return fmt.Errorf("missing or invalid Upgrade header: expected 'websocket', got '%s'", upgrade)
+	// Without any Upgrade header this is a plain HTTP request, not a broken handshake
+	if !IsUpgradeRequest(req) {
+		return fmt.Errorf("%w: no Upgrade header", domain.ErrNotWebSocketRequest)
 	}
 
-	// Validate Connection header
-	connection := req.Header.Get(protocol.HeaderConnection)
-	if !containsToken(connection, protocol.HeaderValueUpgrade) {
-		return fmt.Errorf("missing or invalid Connection header: expected 'Upgrade', got '%s'", connection)
+	// Validate Upgrade header: a comma-separated list that must include websocket
+	if !headerContainsToken(req.Header, protocol.HeaderUpgrade, protocol.HeaderValueWebSocket) {
+		return fmt.Errorf("missing or invalid Upgrade header: expected 'websocket', got '%s'",
+			strings.Join(req.Header.Values(protocol.HeaderUpgrade), ", "))
+	}
+
+	// Validate Connection header: proxies often add tokens such as keep-alive
+	if !headerContainsToken(req.Header, protocol.HeaderConnection, protocol.HeaderValueUpgrade) {
+		return fmt.Errorf("missing or invalid Connection header: expected 'Upgrade', got '%s'",
+			strings.Join(req.Header.Values(protocol.HeaderConnection), ", "))
 	}
 
 	// Validate Sec-WebSocket-Key header
@@ -37,16 +99,39 @@ func (h *HandshakeValidator) ValidateRequest(req *http.Request) error {
 	if key == "" {
 		return fmt.Errorf("missing Sec-WebSocket-Key header")
 	}
+	// RFC 6455 requires the key to be a base64-encoded 16-byte nonce
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fmt.Errorf("invalid Sec-WebSocket-Key header: expected base64 of 16 bytes, got '%s'", key)
+	}
 
-	// Validate Sec-WebSocket-Version header
+	// Validate Sec-WebSocket-Version header, which may list several versions
 	version := req.Header.Get(protocol.HeaderSecWebSocketVersion)
-	if version != protocol.WebSocketVersion {
-		return fmt.Errorf("unsupported WebSocket version: expected '%s', got '%s'", protocol.WebSocketVersion, version)
+	if !h.supportsVersion(version) {
+		return fmt.Errorf("%w: expected '%s', got '%s'", domain.ErrUnsupportedVersion, strings.Join(h.supportedVersions(), ", "), version)
 	}
 
 	return nil
 }
 
+// supportedVersions returns SupportedVersions or the default of just "13"
+func (h *HandshakeValidator) supportedVersions() []string {
+	if len(h.SupportedVersions) == 0 {
+		return []string{protocol.WebSocketVersion}
+	}
+	return h.SupportedVersions
+}
+
+// supportsVersion reports whether the comma-separated versions offered by the client
+// include a supported one
+func (h *HandshakeValidator) supportsVersion(offered string) bool {
+	for _, version := range h.supportedVersions() {
+		if containsToken(offered, version) {
+			return true
+		}
+	}
+	return false
+}
+
 // GenerateAcceptKey generates the Sec-WebSocket-Accept value from the client's key
 // According to RFC 6455: base64(SHA1(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
 func (h *HandshakeValidator) GenerateAcceptKey(key string) string {
@@ -60,12 +145,103 @@ func (h *HandshakeValidator) GenerateAcceptKey(key string) string {
 	return base64.StdEncoding.EncodeToString(hash[:])
 }
 
+// VerifyAcceptKey reports whether serverAccept, the Sec-WebSocket-Accept a server
+// answered with, matches the clientKey sent in the request. A client uses it to
+// authenticate the handshake; the comparison runs in constant time.
+func (h *HandshakeValidator) VerifyAcceptKey(clientKey, serverAccept string) bool {
+	expected := h.GenerateAcceptKey(clientKey)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(serverAccept)) == 1
+}
+
+// BuildClientRequest builds the opening handshake request a client sends to rawURL, a
+// ws:// or wss:// URL (http and https are accepted too). It returns the request, ready
+// for an http.Client or to write to a raw connection, and the random Sec-WebSocket-Key
+// it carries, which ValidateServerResponse needs to check the answer. The first of
+// SupportedVersions is requested.
+func (h *HandshakeValidator) BuildClientRequest(rawURL string) (*http.Request, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	case "http", "https":
+	default:
+		return nil, "", fmt.Errorf("unsupported URL scheme %q: expected ws or wss", u.Scheme)
+	}
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, "", err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set(protocol.HeaderUpgrade, protocol.HeaderValueWebSocket)
+	req.Header.Set(protocol.HeaderConnection, protocol.HeaderValueUpgrade)
+	req.Header.Set(protocol.HeaderSecWebSocketKey, key)
+	req.Header.Set(protocol.HeaderSecWebSocketVersion, h.supportedVersions()[0])
+	return req, key, nil
+}
+
+// ValidateServerResponse checks a server's answer to a request from BuildClientRequest:
+// it must be 101 Switching Protocols with the Upgrade and Connection headers and a
+// Sec-WebSocket-Accept matching expectedKey. Failures wrap domain.ErrBadHandshake.
+func (h *HandshakeValidator) ValidateServerResponse(resp *http.Response, expectedKey string) error {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("%w: expected status 101, got %d", domain.ErrBadHandshake, resp.StatusCode)
+	}
+	if !headerContainsToken(resp.Header, protocol.HeaderUpgrade, protocol.HeaderValueWebSocket) {
+		return fmt.Errorf("%w: missing or invalid Upgrade header: got '%s'", domain.ErrBadHandshake,
+			strings.Join(resp.Header.Values(protocol.HeaderUpgrade), ", "))
+	}
+	if !headerContainsToken(resp.Header, protocol.HeaderConnection, protocol.HeaderValueUpgrade) {
+		return fmt.Errorf("%w: missing or invalid Connection header: got '%s'", domain.ErrBadHandshake,
+			strings.Join(resp.Header.Values(protocol.HeaderConnection), ", "))
+	}
+	if !h.VerifyAcceptKey(expectedKey, resp.Header.Get(protocol.HeaderSecWebSocketAccept)) {
+		return fmt.Errorf("%w: Sec-WebSocket-Accept does not match the key sent", domain.ErrBadHandshake)
+	}
+	return nil
+}
+
+// checkTransport enforces RequireSecure for a handshake received through net/http
+func (h *HandshakeValidator) checkTransport(req *http.Request) error {
+	if h.RequireSecure && req.TLS == nil {
+		return fmt.Errorf("%w: plaintext handshake rejected", domain.ErrInsecureTransport)
+	}
+	return nil
+}
+
+// checkOrigin applies the CheckOrigin callback, if any
+func (h *HandshakeValidator) checkOrigin(req *http.Request) error {
+	if h.CheckOrigin != nil && !h.CheckOrigin(req) {
+		return fmt.Errorf("%w: %q", domain.ErrOriginNotAllowed, req.Header.Get("Origin"))
+	}
+	return nil
+}
+
 // PerformUpgrade performs the WebSocket upgrade handshake
 func (h *HandshakeValidator) PerformUpgrade(w http.ResponseWriter, req *http.Request) error {
+	// Reject plaintext handshakes on a secure-only listener
+	if err := h.checkTransport(req); err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return err
+	}
+
 	// Validate the request
 	if err := h.ValidateRequest(req); err != nil {
-		// Send HTTP 400 Bad Request for invalid handshakes
-		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		h.reject(w, req, err)
+		return err
+	}
+	if err := h.checkOrigin(req); err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
 		return err
 	}
 
@@ -75,6 +251,11 @@ func (h *HandshakeValidator) PerformUpgrade(w http.ResponseWriter, req *http.Req
 	// Generate the accept key
 	acceptKey := h.GenerateAcceptKey(key)
 
+	if h.EnableCompression {
+		NegotiateDeflate(w, req)
+	}
+	h.negotiateSubprotocol(w.Header(), req)
+
 	// Send HTTP 101 Switching Protocols response
 	w.Header().Set(protocol.HeaderUpgrade, protocol.HeaderValueWebSocket)
 	w.Header().Set(protocol.HeaderConnection, protocol.HeaderValueUpgrade)
@@ -84,11 +265,323 @@ func (h *HandshakeValidator) PerformUpgrade(w http.ResponseWriter, req *http.Req
 	return nil
 }
 
-// containsToken checks if a comma-separated header value contains a specific token (case-insensitive)
+// Hijack performs the opening handshake and takes over the underlying connection so that
+// frames can be exchanged on it; the 101 response is written straight to the connection,
+// carrying any headers already set on w, e.g. by NegotiateChecksum. The returned reader
+// holds bytes the client sent right after its request, so frames must be read from it
+// rather than from the bare connection.
+// ResponseWriters wrapped by middleware are unwrapped through http.ResponseController, so
+// any wrapper exposing an Unwrap method still upgrades if the writer beneath it can hijack.
+func (h *HandshakeValidator) Hijack(w http.ResponseWriter, req *http.Request) (net.Conn, *bufio.ReadWriter, error) {
+	if err := h.checkTransport(req); err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return nil, nil, err
+	}
+	if err := h.ValidateRequest(req); err != nil {
+		h.reject(w, req, err)
+		return nil, nil, err
+	}
+	if err := h.checkOrigin(req); err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return nil, nil, err
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		if errors.Is(err, http.ErrNotSupported) {
+			http.Error(w, "Internal Server Error: connection cannot be upgraded", http.StatusInternalServerError)
+			return nil, nil, fmt.Errorf("response writer does not support hijacking: %w", err)
+		}
+		return nil, nil, fmt.Errorf("failed to hijack connection: %w", err)
+	}
+
+	if h.EnableCompression {
+		NegotiateDeflate(w, req)
+	}
+	h.negotiateSubprotocol(w.Header(), req)
+	acceptKey := h.GenerateAcceptKey(req.Header.Get(protocol.HeaderSecWebSocketKey))
+	if err := writeHandshakeResponse(brw.Writer, acceptKey, w.Header()); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, brw, nil
+}
+
+// UpgradeConn performs the server side of the opening handshake directly on a raw
+// connection, without net/http: it reads the HTTP request from conn, validates it and
+// writes the 101 response, negotiating permessage-deflate when EnableCompression is set.
+// The returned Connection is already open. A rejected handshake is answered as Hijack
+// would, with 403 Forbidden for a plaintext connection under RequireSecure or a rejected
+// origin and 400 Bad Request otherwise; closing conn is left to the caller.
+func (h *HandshakeValidator) UpgradeConn(conn net.Conn) (*domain.Connection, error) {
+	connection, _, err := h.UpgradeConnWithResult(conn)
+	return connection, err
+}
+
+// UpgradeConnWithResult is UpgradeConn that also reports the request path and query and
+// the negotiated subprotocol and compression, since the request itself never reaches
+// the caller
+func (h *HandshakeValidator) UpgradeConnWithResult(conn net.Conn) (*domain.Connection, *HandshakeResult, error) {
+	br := bufio.NewReaderSize(conn, h.handshakeReadBufferSize())
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read handshake request: %w", err)
+	}
+
+	// The request is read first so the client gets a response rather than a reset
+	if _, ok := conn.(*tls.Conn); h.RequireSecure && !ok {
+		err := fmt.Errorf("%w: plaintext handshake rejected", domain.ErrInsecureTransport)
+		_ = writeErrorResponse(conn, http.StatusForbidden, err, nil)
+		return nil, nil, err
+	}
+
+	if err := h.ValidateRequest(req); err != nil {
+		header := http.Header{}
+		if errors.Is(err, domain.ErrUnsupportedVersion) {
+			header.Set(protocol.HeaderSecWebSocketVersion, strings.Join(h.supportedVersions(), ", "))
+		}
+		_ = writeErrorResponse(conn, http.StatusBadRequest, err, header)
+		return nil, nil, err
+	}
+	if err := h.checkOrigin(req); err != nil {
+		_ = writeErrorResponse(conn, http.StatusForbidden, err, nil)
+		return nil, nil, err
+	}
+
+	// RFC 6455 requires the client to wait for the handshake response before sending
+	// frames, so buffered bytes here are a protocol violation rather than data to keep
+	if br.Buffered() > 0 {
+		err := fmt.Errorf("%w: client sent data before the handshake completed", domain.ErrProtocolViolation)
+		_ = writeErrorResponse(conn, http.StatusBadRequest, err, nil)
+		return nil, nil, err
+	}
+
+	header := http.Header{}
+	if h.EnableCompression {
+		negotiateDeflate(header, req)
+	}
+	h.negotiateSubprotocol(header, req)
+	acceptKey := h.GenerateAcceptKey(req.Header.Get(protocol.HeaderSecWebSocketKey))
+	if err := writeHandshakeResponse(conn, acceptKey, header); err != nil {
+		return nil, nil, err
+	}
+
+	req.RemoteAddr = conn.RemoteAddr().String()
+	connection := domain.NewConnection(newConnectionID(), h.remoteAddr(req))
+	connection.Headers = h.CaptureHeaders(req)
+	if err := connection.TransitionTo(domain.StateOpen); err != nil {
+		return nil, nil, err
+	}
+	return connection, NewHandshakeResult(req, header), nil
+}
+
+// handshakeReadBufferSize returns HandshakeReadBufferSize or its default
+func (h *HandshakeValidator) handshakeReadBufferSize() int {
+	if h.HandshakeReadBufferSize <= 0 {
+		return DefaultHandshakeReadBufferSize
+	}
+	return h.HandshakeReadBufferSize
+}
+
+// remoteAddr returns the client address to record for req, see RemoteAddrFunc
+func (h *HandshakeValidator) remoteAddr(req *http.Request) string {
+	if h.RemoteAddrFunc != nil {
+		if addr := h.RemoteAddrFunc(req); addr != "" {
+			return addr
+		}
+	}
+	return req.RemoteAddr
+}
+
+// HandshakeResult describes an accepted handshake, letting the caller route the
+// connection after the upgrade, e.g. by path, without keeping the request around
+type HandshakeResult struct {
+	Path        string     // Request path, e.g. "/ws/chat"
+	Query       url.Values // Parsed query parameters
+	Subprotocol string     // Negotiated subprotocol, "" for none
+	Compression bool       // permessage-deflate was negotiated
+}
+
+// NewHandshakeResult summarizes an upgrade from its request and the response headers
+// sent, e.g. w.Header() after PerformUpgrade
+func NewHandshakeResult(req *http.Request, responseHeader http.Header) *HandshakeResult {
+	return &HandshakeResult{
+		Path:        req.URL.Path,
+		Query:       req.URL.Query(),
+		Subprotocol: responseHeader.Get(protocol.HeaderSecWebSocketProtocol),
+		Compression: DeflateNegotiated(responseHeader),
+	}
+}
+
+// negotiateSubprotocol sets the subprotocol selected for req, if any, on the response
+// headers
+func (h *HandshakeValidator) negotiateSubprotocol(header http.Header, req *http.Request) {
+	if chosen := h.selectSubprotocol(req); chosen != "" {
+		header.Set(protocol.HeaderSecWebSocketProtocol, chosen)
+	}
+}
+
+// selectSubprotocol picks one of the subprotocols offered by req, or "" for none
+func (h *HandshakeValidator) selectSubprotocol(req *http.Request) string {
+	offered := offeredSubprotocols(req)
+	if len(offered) == 0 {
+		return ""
+	}
+
+	if h.ProtocolSelector != nil {
+		chosen := h.ProtocolSelector(offered, req)
+		for _, p := range offered {
+			if p == chosen {
+				return chosen
+			}
+		}
+		return ""
+	}
+
+	for _, supported := range h.Subprotocols {
+		for _, p := range offered {
+			if p == supported {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// offeredSubprotocols returns the tokens of every Sec-WebSocket-Protocol line of req, in
+// order. Subprotocol names are case-sensitive, unlike other header tokens.
+func offeredSubprotocols(req *http.Request) []string {
+	var offered []string
+	for _, value := range req.Header.Values(protocol.HeaderSecWebSocketProtocol) {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.Trim(token, " \t"); token != "" {
+				offered = append(offered, token)
+			}
+		}
+	}
+	return offered
+}
+
+// IsUpgradeRequest reports whether req attempts a protocol upgrade at all, letting
+// callers route plain HTTP requests elsewhere before validating the handshake
+func IsUpgradeRequest(req *http.Request) bool {
+	return req.Header.Get(protocol.HeaderUpgrade) != ""
+}
+
+// reject answers a request that failed validation
+func (h *HandshakeValidator) reject(w http.ResponseWriter, req *http.Request, err error) {
+	if h.NotWebSocketHandler != nil && errors.Is(err, domain.ErrNotWebSocketRequest) {
+		h.NotWebSocketHandler.ServeHTTP(w, req)
+		return
+	}
+	// Tell the client which versions to retry with (RFC 6455 section 4.4)
+	if errors.Is(err, domain.ErrUnsupportedVersion) {
+		w.Header().Set(protocol.HeaderSecWebSocketVersion, strings.Join(h.supportedVersions(), ", "))
+	}
+	// Send HTTP 400 Bad Request for invalid handshakes
+	http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+}
+
+// CaptureHeaders copies the ForwardHeaders allowlist out of the upgrade request, so the
+// values stay available after the request is gone. Headers absent from the request are
+// omitted; the result is nil when nothing is configured.
+func (h *HandshakeValidator) CaptureHeaders(req *http.Request) http.Header {
+	if len(h.ForwardHeaders) == 0 {
+		return nil
+	}
+	headers := make(http.Header, len(h.ForwardHeaders))
+	for _, name := range h.ForwardHeaders {
+		if values := req.Header.Values(name); len(values) > 0 {
+			headers[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+		}
+	}
+	return headers
+}
+
+// writeHandshakeResponse writes a raw 101 Switching Protocols response, followed by any
+// extra headers such as negotiated extensions. Extra values for the handshake's own
+// headers are skipped so they cannot contradict the upgrade.
+func writeHandshakeResponse(w io.Writer, acceptKey string, extra http.Header) error {
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		protocol.HeaderUpgrade + ": " + protocol.HeaderValueWebSocket + "\r\n" +
+		protocol.HeaderConnection + ": " + protocol.HeaderValueUpgrade + "\r\n" +
+		protocol.HeaderSecWebSocketAccept + ": " + acceptKey + "\r\n")
+
+	headers := extra.Clone()
+	for _, name := range []string{protocol.HeaderUpgrade, protocol.HeaderConnection, protocol.HeaderSecWebSocketAccept} {
+		headers.Del(name)
+	}
+	if err := headers.Write(&b); err != nil {
+		return err
+	}
+	b.WriteString("\r\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeErrorResponse writes a plain-text HTTP error response for a rejected handshake,
+// in the format http.Error uses, to a connection not managed by net/http
+func writeErrorResponse(w io.Writer, status int, err error, header http.Header) error {
+	body := http.StatusText(status) + ": " + err.Error() + "\n"
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	resp := &http.Response{
+		StatusCode:    status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         true,
+	}
+
+	// Send the response in one write, like writeHandshakeResponse
+	var b bytes.Buffer
+	if err := resp.Write(&b); err != nil {
+		return err
+	}
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// newConnectionID returns a random identifier for a new connection
+func newConnectionID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// containsToken checks if a comma-separated header value contains a specific token
+// (case-insensitive). Elements are split on commas and stripped of the optional
+// whitespace (spaces and tabs) RFC 7230 allows around them; empty elements are ignored.
 func containsToken(header, token string) bool {
 	tokens := strings.Split(header, ",")
 	for _, t := range tokens {
-		if strings.EqualFold(strings.TrimSpace(t), token) {
+		if strings.EqualFold(strings.Trim(t, " \t"), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerContainsToken checks every field line of a header for token, since a list-valued
+// header may be split across several lines, e.g. by a proxy appending its own Connection
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		if containsToken(value, token) {
 			return true
 		}
 	}
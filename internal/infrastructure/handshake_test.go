@@ -1,14 +1,25 @@
 package infrastructure
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/leanovate/gopter"
 	"github.com/leanovate/gopter/gen"
 	"github.com/leanovate/gopter/prop"
 
+	"websocket-server/internal/domain"
 	"websocket-server/pkg/protocol"
 )
 
@@ -157,7 +168,7 @@ func TestProperty_HandshakeValidationCompleteness(t *testing.T) {
 			err := validator.ValidateRequest(req)
 			return err == nil // Should pass validation
 		},
-		gen.Identifier(),
+		genWebSocketKey(),
 	))
 
 	properties.TestingRun(t)
@@ -302,7 +313,7 @@ func TestProperty_ValidHandshakeResponse(t *testing.T) {
 
 			return true
 		},
-		gen.Identifier(),
+		genWebSocketKey(),
 	))
 
 	properties.TestingRun(t)
@@ -493,3 +504,854 @@ func TestProperty_InvalidHandshakeResponse(t *testing.T) {
 
 	properties.TestingRun(t)
 }
+
+// genWebSocketKey generates well-formed Sec-WebSocket-Key values: base64 of 16 random bytes
+func genWebSocketKey() gopter.Gen {
+	return gen.SliceOfN(16, gen.UInt8()).Map(func(nonce []uint8) string {
+		return base64.StdEncoding.EncodeToString(nonce)
+	})
+}
+
+// newHandshakeRequest builds a valid handshake request using the RFC 6455 sample key
+func newHandshakeRequest() *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(protocol.HeaderUpgrade, protocol.HeaderValueWebSocket)
+	req.Header.Set(protocol.HeaderConnection, protocol.HeaderValueUpgrade)
+	req.Header.Set(protocol.HeaderSecWebSocketKey, "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set(protocol.HeaderSecWebSocketVersion, protocol.WebSocketVersion)
+	return req
+}
+
+func TestHandshakeValidator_UpgradeHeaderTokens(t *testing.T) {
+	tests := []struct {
+		upgrade string
+		valid   bool
+	}{
+		{"websocket", true},
+		{"WebSocket", true},
+		{"websocket, h2c", true},
+		{"h2c,websocket", true},
+		{"h2c", false},
+		{"websocketx", false},
+		{"", false},
+	}
+
+	validator := NewHandshakeValidator()
+	for _, tt := range tests {
+		t.Run(tt.upgrade, func(t *testing.T) {
+			req := newHandshakeRequest()
+			req.Header.Set(protocol.HeaderUpgrade, tt.upgrade)
+
+			err := validator.ValidateRequest(req)
+			if tt.valid && err != nil {
+				t.Errorf("expected %q to be accepted, got %v", tt.upgrade, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("expected %q to be rejected", tt.upgrade)
+			}
+		})
+	}
+}
+
+func TestHandshakeValidator_ConnectionHeaderTokens(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection []string
+		valid      bool
+	}{
+		{"single token", []string{"Upgrade"}, true},
+		{"lower case", []string{"upgrade"}, true},
+		{"after keep-alive", []string{"keep-alive, Upgrade"}, true},
+		{"no whitespace", []string{"Keep-Alive,Upgrade"}, true},
+		{"tab separated", []string{"keep-alive,\tUpgrade\t"}, true},
+		{"empty elements", []string{", , Upgrade,"}, true},
+		{"split across lines", []string{"keep-alive", "Upgrade"}, true},
+		{"keep-alive only", []string{"keep-alive"}, false},
+		{"substring only", []string{"Upgrades"}, false},
+		{"missing", nil, false},
+	}
+
+	validator := NewHandshakeValidator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newHandshakeRequest()
+			req.Header.Del(protocol.HeaderConnection)
+			for _, value := range tt.connection {
+				req.Header.Add(protocol.HeaderConnection, value)
+			}
+
+			err := validator.ValidateRequest(req)
+			if tt.valid && err != nil {
+				t.Errorf("expected %q to be accepted, got %v", tt.connection, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("expected %q to be rejected", tt.connection)
+			}
+		})
+	}
+}
+
+func TestHandshakeValidator_Subprotocols(t *testing.T) {
+	byPath := func(offered []string, req *http.Request) string {
+		if req.URL.Path == "/" {
+			return offered[len(offered)-1]
+		}
+		return ""
+	}
+
+	tests := []struct {
+		name      string
+		validator *HandshakeValidator
+		offered   []string
+		expected  string
+	}{
+		{"nothing configured", &HandshakeValidator{}, []string{"chat"}, ""},
+		{"server preference wins", &HandshakeValidator{Subprotocols: []string{"v2", "v1"}}, []string{"v1, v2"}, "v2"},
+		{"split across lines", &HandshakeValidator{Subprotocols: []string{"v1"}}, []string{"v2", "v1"}, "v1"},
+		{"case-sensitive", &HandshakeValidator{Subprotocols: []string{"Chat"}}, []string{"chat"}, ""},
+		{"nothing offered", &HandshakeValidator{Subprotocols: []string{"v1"}}, nil, ""},
+		{"selector overrides list", &HandshakeValidator{Subprotocols: []string{"v1"}, ProtocolSelector: byPath}, []string{"v1,\tv2"}, "v2"},
+		{"selector picks none", &HandshakeValidator{ProtocolSelector: func([]string, *http.Request) string { return "" }}, []string{"v1"}, ""},
+		{"selector choice not offered", &HandshakeValidator{ProtocolSelector: func([]string, *http.Request) string { return "v9" }}, []string{"v1"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newHandshakeRequest()
+			for _, value := range tt.offered {
+				req.Header.Add(protocol.HeaderSecWebSocketProtocol, value)
+			}
+			w := httptest.NewRecorder()
+
+			if err := tt.validator.PerformUpgrade(w, req); err != nil {
+				t.Fatalf("PerformUpgrade failed: %v", err)
+			}
+			got := w.Header().Values(protocol.HeaderSecWebSocketProtocol)
+			if (tt.expected == "" && len(got) != 0) || (tt.expected != "" && (len(got) != 1 || got[0] != tt.expected)) {
+				t.Errorf("expected subprotocol %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// hijackableRecorder is a ResponseWriter that hands out one end of a net.Pipe on Hijack
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (r *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return r.conn, bufio.NewReadWriter(bufio.NewReader(r.conn), bufio.NewWriter(r.conn)), nil
+}
+
+// middlewareWriter hides the Hijack method of the writer it wraps, like typical logging middleware
+type middlewareWriter struct {
+	http.ResponseWriter
+}
+
+func (w *middlewareWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestHandshakeValidator_HijackThroughWrapper(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	w := &middlewareWriter{&hijackableRecorder{httptest.NewRecorder(), server}}
+	if _, ok := http.ResponseWriter(w).(http.Hijacker); ok {
+		t.Fatal("test wrapper must not implement http.Hijacker directly")
+	}
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		if err != nil {
+			t.Errorf("failed to read response: %v", err)
+		}
+		responses <- resp
+	}()
+
+	conn, _, err := NewHandshakeValidator().Hijack(w, newHandshakeRequest())
+	if err != nil {
+		t.Fatalf("Hijack failed: %v", err)
+	}
+	defer conn.Close()
+
+	resp := <-responses
+	if resp == nil {
+		t.FailNow()
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("expected 101, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get(protocol.HeaderSecWebSocketAccept); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("unexpected accept key %q", got)
+	}
+}
+
+func TestHandshakeValidator_HijackNotSupported(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	_, _, err := NewHandshakeValidator().Hijack(w, newHandshakeRequest())
+	if !errors.Is(err, http.ErrNotSupported) {
+		t.Fatalf("expected http.ErrNotSupported, got %v", err)
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", w.Code)
+	}
+}
+
+func TestHandshakeValidator_RequireSecure(t *testing.T) {
+	tests := []struct {
+		name         string
+		secure       bool
+		expectedCode int
+	}{
+		{"plaintext rejected", false, http.StatusForbidden},
+		{"tls accepted", true, http.StatusSwitchingProtocols},
+	}
+
+	validator := &HandshakeValidator{RequireSecure: true}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newHandshakeRequest()
+			if tt.secure {
+				req.TLS = &tls.ConnectionState{HandshakeComplete: true}
+			}
+			w := httptest.NewRecorder()
+
+			err := validator.PerformUpgrade(w, req)
+			if tt.secure && err != nil {
+				t.Fatalf("expected TLS handshake to pass, got %v", err)
+			}
+			if !tt.secure && !errors.Is(err, domain.ErrInsecureTransport) {
+				t.Fatalf("expected ErrInsecureTransport, got %v", err)
+			}
+			if w.Code != tt.expectedCode {
+				t.Errorf("expected %d, got %d", tt.expectedCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandshakeValidator_CaptureHeaders(t *testing.T) {
+	validator := &HandshakeValidator{ForwardHeaders: []string{"authorization", "X-Tenant-ID", "Cookie"}}
+
+	req := newHandshakeRequest()
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Add("X-Tenant-Id", "acme")
+	req.Header.Set("X-Not-Forwarded", "secret")
+
+	headers := validator.CaptureHeaders(req)
+	if got := headers.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("expected Authorization to be forwarded, got %q", got)
+	}
+	if got := headers.Get("X-Tenant-ID"); got != "acme" {
+		t.Errorf("expected X-Tenant-ID to be forwarded, got %q", got)
+	}
+	if _, ok := headers["Cookie"]; ok {
+		t.Error("expected absent headers to be omitted")
+	}
+	if headers.Get("X-Not-Forwarded") != "" {
+		t.Error("expected headers outside the allowlist to be dropped")
+	}
+
+	// Later changes to the request must not leak into the captured copy
+	req.Header.Set("Authorization", "changed")
+	if got := headers.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("expected captured headers to be a copy, got %q", got)
+	}
+}
+
+func TestHandshakeValidator_UpgradeConnForwardsHeaders(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go func() {
+		request := strings.Replace(rawHandshakeRequest, "\r\n\r\n", "\r\nX-Tenant-ID: acme\r\n\r\n", 1)
+		_, _ = client.Write([]byte(request))
+		_, _ = http.ReadResponse(bufio.NewReader(client), nil)
+	}()
+
+	validator := &HandshakeValidator{ForwardHeaders: []string{"X-Tenant-ID"}}
+	conn, err := validator.UpgradeConn(server)
+	if err != nil {
+		t.Fatalf("UpgradeConn failed: %v", err)
+	}
+	if got := conn.Headers.Get("X-Tenant-ID"); got != "acme" {
+		t.Errorf("expected forwarded header on the connection, got %q", got)
+	}
+}
+
+func TestHandshakeValidator_HandshakeReadBufferSize(t *testing.T) {
+	// A cookie several times the default buffer, as sent behind some SSO proxies
+	cookie := "session=" + strings.Repeat("a", 3*DefaultHandshakeReadBufferSize)
+
+	for _, size := range []int{0, 16, 64 << 10} {
+		t.Run(fmt.Sprintf("buffer %d", size), func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+
+			go func() {
+				request := strings.Replace(rawHandshakeRequest, "\r\n\r\n", "\r\nCookie: "+cookie+"\r\n\r\n", 1)
+				_, _ = client.Write([]byte(request))
+				_, _ = http.ReadResponse(bufio.NewReader(client), nil)
+			}()
+
+			validator := &HandshakeValidator{HandshakeReadBufferSize: size, ForwardHeaders: []string{"Cookie"}}
+			conn, err := validator.UpgradeConn(server)
+			if err != nil {
+				t.Fatalf("UpgradeConn failed: %v", err)
+			}
+			if got := conn.Headers.Get("Cookie"); got != cookie {
+				t.Errorf("expected the %d-byte cookie intact, got %d bytes", len(cookie), len(got))
+			}
+		})
+	}
+}
+
+func TestHandshakeValidator_RemoteAddrFunc(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go func() {
+		request := strings.Replace(rawHandshakeRequest, "\r\n\r\n", "\r\nX-Real-IP: 198.51.100.4\r\n\r\n", 1)
+		_, _ = client.Write([]byte(request))
+		_, _ = http.ReadResponse(bufio.NewReader(client), nil)
+	}()
+
+	var seen string
+	validator := &HandshakeValidator{RemoteAddrFunc: func(req *http.Request) string {
+		seen = req.RemoteAddr
+		return req.Header.Get("X-Real-IP")
+	}}
+	conn, err := validator.UpgradeConn(server)
+	if err != nil {
+		t.Fatalf("UpgradeConn failed: %v", err)
+	}
+	if conn.RemoteAddr != "198.51.100.4" {
+		t.Errorf("expected the address from RemoteAddrFunc, got %q", conn.RemoteAddr)
+	}
+	if seen != server.RemoteAddr().String() {
+		t.Errorf("expected RemoteAddrFunc to see the connection's address, got %q", seen)
+	}
+}
+
+func TestHandshakeValidator_UpgradeConnRejections(t *testing.T) {
+	tests := []struct {
+		name           string
+		validator      *HandshakeValidator
+		request        string
+		expectedStatus int
+		expectedErr    error
+	}{
+		{
+			name:           "unsupported version",
+			validator:      NewHandshakeValidator(),
+			request:        strings.Replace(rawHandshakeRequest, "Sec-WebSocket-Version: 13", "Sec-WebSocket-Version: 8", 1),
+			expectedStatus: http.StatusBadRequest,
+			expectedErr:    domain.ErrUnsupportedVersion,
+		},
+		{
+			name:           "rejected origin",
+			validator:      &HandshakeValidator{CheckOrigin: func(*http.Request) bool { return false }},
+			request:        rawHandshakeRequest,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "plaintext under RequireSecure",
+			validator:      &HandshakeValidator{RequireSecure: true},
+			request:        rawHandshakeRequest,
+			expectedStatus: http.StatusForbidden,
+			expectedErr:    domain.ErrInsecureTransport,
+		},
+		{
+			name:           "data before the handshake completed",
+			validator:      NewHandshakeValidator(),
+			request:        rawHandshakeRequest + "\x81\x00",
+			expectedStatus: http.StatusBadRequest,
+			expectedErr:    domain.ErrProtocolViolation,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+
+			responses := make(chan *http.Response, 1)
+			go func() {
+				_, _ = client.Write([]byte(tt.request))
+				resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+				if err == nil {
+					_, _ = io.ReadAll(resp.Body)
+				}
+				responses <- resp
+			}()
+
+			_, err := tt.validator.UpgradeConn(server)
+			if err == nil || (tt.expectedErr != nil && !errors.Is(err, tt.expectedErr)) {
+				t.Fatalf("expected the handshake to fail with %v, got %v", tt.expectedErr, err)
+			}
+			server.Close()
+
+			resp := <-responses
+			if resp == nil {
+				t.Fatal("expected an HTTP error response on the connection")
+			}
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+			if !resp.Close {
+				t.Error("expected the error response to close the connection")
+			}
+			if errors.Is(tt.expectedErr, domain.ErrUnsupportedVersion) && resp.Header.Get(protocol.HeaderSecWebSocketVersion) != protocol.WebSocketVersion {
+				t.Errorf("expected the supported versions advertised, got %q", resp.Header.Get(protocol.HeaderSecWebSocketVersion))
+			}
+		})
+	}
+}
+
+func TestHandshakeValidator_UpgradeConnWithResult(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	go func() {
+		request := strings.Replace(rawHandshakeRequest, "GET /chat ", "GET /ws/chat?room=42&room=7 ", 1)
+		request = strings.Replace(request, "\r\n\r\n", "\r\n"+protocol.HeaderSecWebSocketProtocol+": v1, v2\r\n\r\n", 1)
+		_, _ = client.Write([]byte(request))
+		_, _ = http.ReadResponse(bufio.NewReader(client), nil)
+	}()
+
+	validator := &HandshakeValidator{Subprotocols: []string{"v2"}}
+	_, result, err := validator.UpgradeConnWithResult(server)
+	if err != nil {
+		t.Fatalf("UpgradeConnWithResult failed: %v", err)
+	}
+	if result.Path != "/ws/chat" {
+		t.Errorf("expected path /ws/chat, got %q", result.Path)
+	}
+	if rooms := result.Query["room"]; len(rooms) != 2 || rooms[0] != "42" || rooms[1] != "7" {
+		t.Errorf("expected both room parameters, got %v", rooms)
+	}
+	if result.Subprotocol != "v2" || result.Compression {
+		t.Errorf("expected subprotocol v2 without compression, got %+v", result)
+	}
+}
+
+func TestHandshakeValidator_UpgradeConnCompression(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		expected bool
+	}{
+		{"enabled", true, true},
+		{"disabled", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+
+			responses := make(chan *http.Response, 1)
+			go func() {
+				offer := protocol.HeaderSecWebSocketExtensions + ": " + protocol.ExtensionPermessageDeflate
+				_, _ = client.Write([]byte(strings.Replace(rawHandshakeRequest, "\r\n\r\n", "\r\n"+offer+"\r\n\r\n", 1)))
+				resp, _ := http.ReadResponse(bufio.NewReader(client), nil)
+				responses <- resp
+			}()
+
+			validator := &HandshakeValidator{EnableCompression: tt.enabled}
+			_, result, err := validator.UpgradeConnWithResult(server)
+			if err != nil {
+				t.Fatalf("UpgradeConnWithResult failed: %v", err)
+			}
+			if result.Compression != tt.expected {
+				t.Errorf("expected Compression %v, got %v", tt.expected, result.Compression)
+			}
+			resp := <-responses
+			if resp == nil {
+				t.Fatal("expected a handshake response")
+			}
+			if got := DeflateNegotiated(resp.Header); got != tt.expected {
+				t.Errorf("expected permessage-deflate in the response %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestHandshakeValidator_NotWebSocketRequest(t *testing.T) {
+	plain := httptest.NewRequest("GET", "/index.html", nil)
+	broken := newHandshakeRequest()
+	broken.Header.Del(protocol.HeaderSecWebSocketKey)
+
+	validator := NewHandshakeValidator()
+	if err := validator.ValidateRequest(plain); !errors.Is(err, domain.ErrNotWebSocketRequest) {
+		t.Errorf("expected a plain GET to report ErrNotWebSocketRequest, got %v", err)
+	}
+	if err := validator.ValidateRequest(broken); err == nil || errors.Is(err, domain.ErrNotWebSocketRequest) {
+		t.Errorf("expected a broken upgrade to fail as a malformed handshake, got %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		req          *http.Request
+		expectedCode int
+	}{
+		{"plain request routed to handler", plain, http.StatusNotFound},
+		{"broken upgrade still 400", broken, http.StatusBadRequest},
+	}
+
+	validator.NotWebSocketHandler = http.NotFoundHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if err := validator.PerformUpgrade(w, tt.req); err == nil {
+				t.Fatal("expected PerformUpgrade to fail")
+			}
+			if w.Code != tt.expectedCode {
+				t.Errorf("expected %d, got %d", tt.expectedCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandshakeValidator_CheckOrigin(t *testing.T) {
+	allowOnly := func(origin string) func(*http.Request) bool {
+		return func(req *http.Request) bool {
+			return req.Header.Get("Origin") == origin
+		}
+	}
+
+	tests := []struct {
+		name         string
+		checkOrigin  func(*http.Request) bool
+		origin       string
+		expectedCode int
+	}{
+		{"no callback accepts any origin", nil, "https://evil.example", http.StatusSwitchingProtocols},
+		{"allowed origin", allowOnly("https://app.example"), "https://app.example", http.StatusSwitchingProtocols},
+		{"rejected origin", allowOnly("https://app.example"), "https://evil.example", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := &HandshakeValidator{CheckOrigin: tt.checkOrigin}
+			req := newHandshakeRequest()
+			req.Header.Set("Origin", tt.origin)
+			w := httptest.NewRecorder()
+
+			err := validator.PerformUpgrade(w, req)
+			if w.Code != tt.expectedCode {
+				t.Errorf("expected %d, got %d", tt.expectedCode, w.Code)
+			}
+			if tt.expectedCode == http.StatusForbidden && !errors.Is(err, domain.ErrOriginNotAllowed) {
+				t.Errorf("expected ErrOriginNotAllowed, got %v", err)
+			}
+			if tt.expectedCode != http.StatusForbidden && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestHandshakeValidator_HijackOverHTTPServer(t *testing.T) {
+	validator := NewHandshakeValidator()
+	received := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		NegotiateChecksum(w, req)
+		conn, brw, err := validator.Hijack(w, req)
+		if err != nil {
+			t.Errorf("Hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		// The frame was sent along with the request, so it may already sit in brw
+		frame, err := NewServerFrameParser(protocol.MaxPayloadSize).ReadFrame(brw)
+		if err != nil {
+			t.Errorf("ReadFrame failed: %v", err)
+			return
+		}
+		received <- string(frame.Payload)
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+	request := strings.Replace(rawHandshakeRequest, "\r\n\r\n",
+		"\r\n"+protocol.HeaderSecWebSocketExtensions+": "+protocol.ExtensionFrameChecksum+"\r\n\r\n", 1)
+	var out bytes.Buffer
+	out.WriteString(request)
+	_ = client.WriteFrame(&out, domain.NewFrame(domain.OpcodeText, []byte("early")))
+	if _, err := conn.Write(out.Bytes()); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get(protocol.HeaderSecWebSocketExtensions); got != protocol.ExtensionFrameChecksum {
+		t.Errorf("expected negotiated extension in the response, got %q", got)
+	}
+
+	select {
+	case payload := <-received:
+		if payload != "early" {
+			t.Errorf("expected buffered frame payload %q, got %q", "early", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not read the frame sent with the handshake")
+	}
+}
+
+func TestHandshakeValidator_KeyMustDecodeTo16Bytes(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		valid bool
+	}{
+		{"RFC 6455 sample key", "dGhlIHNhbXBsZSBub25jZQ==", true},
+		{"not base64", "not-a-valid-key!", false},
+		{"15 bytes", base64.StdEncoding.EncodeToString(make([]byte, 15)), false},
+		{"17 bytes", base64.StdEncoding.EncodeToString(make([]byte, 17)), false},
+		{"unpadded", "dGhlIHNhbXBsZSBub25jZQ", false},
+	}
+
+	validator := NewHandshakeValidator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newHandshakeRequest()
+			req.Header.Set(protocol.HeaderSecWebSocketKey, tt.key)
+			w := httptest.NewRecorder()
+
+			err := validator.PerformUpgrade(w, req)
+			if tt.valid && (err != nil || w.Code != http.StatusSwitchingProtocols) {
+				t.Errorf("expected key to be accepted, got %v (status %d)", err, w.Code)
+			}
+			if !tt.valid && (err == nil || w.Code != http.StatusBadRequest) {
+				t.Errorf("expected 400 for key %q, got %v (status %d)", tt.key, err, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandshakeValidator_SupportedVersions(t *testing.T) {
+	tests := []struct {
+		name          string
+		supported     []string
+		offered       string
+		accepted      bool
+		advertisement string
+	}{
+		{"default accepts 13", nil, "13", true, ""},
+		{"default rejects 8", nil, "8", false, "13"},
+		{"client version list", nil, "8, 13", true, ""},
+		{"configured older version", []string{"13", "8"}, "8", true, ""},
+		{"configured set rejects others", []string{"13", "8"}, "7", false, "13, 8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := NewHandshakeValidator()
+			validator.SupportedVersions = tt.supported
+
+			req := newHandshakeRequest()
+			req.Header.Set(protocol.HeaderSecWebSocketVersion, tt.offered)
+			w := httptest.NewRecorder()
+
+			err := validator.PerformUpgrade(w, req)
+			if tt.accepted {
+				if err != nil || w.Code != http.StatusSwitchingProtocols {
+					t.Fatalf("expected 101, got %d: %v", w.Code, err)
+				}
+				return
+			}
+
+			if !errors.Is(err, domain.ErrUnsupportedVersion) {
+				t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+			}
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d", w.Code)
+			}
+			if got := w.Header().Get(protocol.HeaderSecWebSocketVersion); got != tt.advertisement {
+				t.Errorf("expected Sec-WebSocket-Version %q, got %q", tt.advertisement, got)
+			}
+		})
+	}
+}
+
+func TestHandshakeValidator_VerifyAcceptKey(t *testing.T) {
+	validator := NewHandshakeValidator()
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+
+	tests := []struct {
+		name     string
+		accept   string
+		expected bool
+	}{
+		{"RFC 6455 example", "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", true},
+		{"one character off", "s3pPLMBiTxaQ9kYGzzhZRbK+xOp=", false},
+		{"truncated", "s3pPLMBiTxaQ9kYGzzhZRbK+xOo", false},
+		{"empty", "", false},
+		{"accept for another key", validator.GenerateAcceptKey("x3JJHMbDL1EzLkh9GBhXDw=="), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validator.VerifyAcceptKey(key, tt.accept); got != tt.expected {
+				t.Errorf("VerifyAcceptKey(%q) = %v, want %v", tt.accept, got, tt.expected)
+			}
+		})
+	}
+}
+
+func BenchmarkHandshakeValidator_GenerateAcceptKey(b *testing.B) {
+	validator := NewHandshakeValidator()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		validator.GenerateAcceptKey("dGhlIHNhbXBsZSBub25jZQ==")
+	}
+}
+
+func BenchmarkHandshakeValidator_VerifyAcceptKey(b *testing.B) {
+	validator := NewHandshakeValidator()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		validator.VerifyAcceptKey("dGhlIHNhbXBsZSBub25jZQ==", "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+	}
+}
+
+func TestHandshakeValidator_ClientHandshake(t *testing.T) {
+	server := httptest.NewServer(&EchoHandler{})
+	t.Cleanup(server.Close)
+	validator := NewHandshakeValidator()
+
+	req, key, err := validator.BuildClientRequest("ws://" + server.Listener.Addr().String() + "/chat?room=1")
+	if err != nil {
+		t.Fatalf("BuildClientRequest failed: %v", err)
+	}
+	if err := validator.ValidateRequest(req); err != nil {
+		t.Fatalf("expected the built request to pass server validation, got %v", err)
+	}
+
+	conn, err := net.Dial("tcp", req.URL.Host)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("ReadResponse failed: %v", err)
+	}
+	if err := validator.ValidateServerResponse(resp, key); err != nil {
+		t.Fatalf("ValidateServerResponse failed: %v", err)
+	}
+
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodeText, []byte("hello")))
+	expectFrame(t, br, domain.OpcodeText, []byte("hello"))
+
+	// A second request carries a fresh key
+	if _, key2, _ := validator.BuildClientRequest("wss://example.com/"); key2 == key {
+		t.Error("expected a new random key for every request")
+	}
+}
+
+func TestHandshakeValidator_BuildClientRequestURLs(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		expected string
+		wantErr  bool
+	}{
+		{"ws", "ws://example.com/socket", "http://example.com/socket", false},
+		{"wss with query", "wss://example.com:8443/socket?token=abc", "https://example.com:8443/socket?token=abc", false},
+		{"http", "http://example.com/", "http://example.com/", false},
+		{"unsupported scheme", "ftp://example.com/", "", true},
+		{"malformed", "ws://[::1", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, key, err := NewHandshakeValidator().BuildClientRequest(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BuildClientRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if req.URL.String() != tt.expected {
+				t.Errorf("expected URL %q, got %q", tt.expected, req.URL.String())
+			}
+			if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+				t.Errorf("expected a base64 16-byte key, got %q", key)
+			}
+			if req.Header.Get("Sec-WebSocket-Key") != key || req.Header.Get("Sec-WebSocket-Version") != "13" {
+				t.Errorf("unexpected handshake headers %v", req.Header)
+			}
+		})
+	}
+}
+
+func TestHandshakeValidator_ValidateServerResponse(t *testing.T) {
+	validator := NewHandshakeValidator()
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	accept := "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+
+	response := func(status int, upgrade, connection, accept string) *http.Response {
+		header := http.Header{}
+		header.Set("Upgrade", upgrade)
+		header.Set("Connection", connection)
+		header.Set("Sec-WebSocket-Accept", accept)
+		return &http.Response{StatusCode: status, Header: header}
+	}
+
+	tests := []struct {
+		name    string
+		resp    *http.Response
+		wantErr bool
+	}{
+		{"valid", response(http.StatusSwitchingProtocols, "websocket", "Upgrade", accept), false},
+		{"tokens in lists", response(http.StatusSwitchingProtocols, "WebSocket", "keep-alive, upgrade", accept), false},
+		{"not switching protocols", response(http.StatusBadRequest, "websocket", "Upgrade", accept), true},
+		{"missing upgrade", response(http.StatusSwitchingProtocols, "", "Upgrade", accept), true},
+		{"missing connection", response(http.StatusSwitchingProtocols, "websocket", "close", accept), true},
+		{"wrong accept", response(http.StatusSwitchingProtocols, "websocket", "Upgrade", "AAAAAAAAAAAAAAAAAAAAAAAAAAA="), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateServerResponse(tt.resp, key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateServerResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, domain.ErrBadHandshake) {
+				t.Errorf("expected ErrBadHandshake, got %v", err)
+			}
+		})
+	}
+}
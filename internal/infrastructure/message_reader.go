@@ -0,0 +1,202 @@
+package infrastructure
+
+import (
+	"fmt"
+	"io"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+// MessageReader reassembles WebSocket messages from the frames read off a stream
+type MessageReader struct {
+	parser         *FrameParser
+	reader         io.Reader
+	maxMessageSize uint64
+	onFrame        func() // Called for every frame header read, e.g. to record activity
+
+	// MaxFragments caps the number of frames a single message may span, bounding the
+	// work a peer can cause with floods of tiny or empty continuation frames. Zero means
+	// no limit.
+	MaxFragments int
+
+	// RateLimiter, when set, is consulted before each frame's payload is read; a refusal
+	// fails the read with ErrRateLimited. Nil means unlimited.
+	RateLimiter RateLimiter
+
+	// ControlHandler, when set, is called with every ping, pong and close frame read,
+	// including those interleaved between the fragments of a message, e.g. to answer
+	// pings. A non-nil error aborts ReadMessage and is returned unchanged.
+	ControlHandler func(*domain.Frame) error
+
+	// OnPing is called with the payload of every ping read. When nil and PongWriter is
+	// set, the default replies with a pong echoing the payload, as RFC 6455 requires.
+	OnPing func(payload []byte) error
+
+	// OnPong, when set, is called with the payload of every pong read. Pongs need not
+	// answer a ping: RFC 6455 allows unsolicited pongs as a unidirectional heartbeat, so
+	// without OnPong they are simply consumed.
+	OnPong func(payload []byte) error
+
+	// PongWriter receives the default pong replies. It must not be written to
+	// concurrently by other goroutines while ReadMessage runs.
+	PongWriter io.Writer
+}
+
+// NewMessageReader creates a MessageReader that reads frames from reader using parser.
+// Reassembled messages larger than maxMessageSize are rejected.
+func NewMessageReader(parser *FrameParser, reader io.Reader, maxMessageSize uint64) *MessageReader {
+	if maxMessageSize == 0 {
+		maxMessageSize = protocol.MaxMessageSize
+	}
+	return &MessageReader{
+		parser:         parser,
+		reader:         reader,
+		maxMessageSize: maxMessageSize,
+	}
+}
+
+// ReadMessage reads frames until a complete data message has been assembled.
+// Ping and pong frames are consumed, passing them to ControlHandler and answering pings
+// (see OnPing); a close frame ends the stream with a *domain.CloseError carrying the
+// peer's code and reason, which matches ErrConnectionClosed.
+func (mr *MessageReader) ReadMessage() (*domain.Message, error) {
+	var (
+		msgType    domain.MessageType
+		payload    []byte
+		started    bool
+		compressed bool
+		fragments  int
+	)
+
+	for {
+		frame, err := mr.readHeader()
+		if err != nil {
+			return nil, err
+		}
+
+		if frame.Opcode.IsControl() {
+			if err := mr.handleControl(frame); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Data frames must form a single start frame followed by continuations
+		if frame.Opcode == domain.OpcodeContinuation && !started {
+			return nil, fmt.Errorf("%w: continuation frame without a message in progress", domain.ErrProtocolViolation)
+		}
+		if frame.Opcode != domain.OpcodeContinuation && started {
+			return nil, fmt.Errorf("%w: new %s frame while a fragmented message is in progress", domain.ErrProtocolViolation, frame.Opcode)
+		}
+
+		fragments++
+		if err := mr.checkFragments(fragments); err != nil {
+			return nil, err
+		}
+
+		// Reject on the declared length, before the payload is allocated
+		if uint64(len(payload))+frame.PayloadLen > mr.maxMessageSize {
+			return nil, domain.ErrPayloadTooLarge
+		}
+
+		if err := mr.parser.readPayload(mr.reader, frame); err != nil {
+			return nil, err
+		}
+
+		// The start frame decides the message type; continuations carry opcode 0
+		if !started {
+			started = true
+			msgType = domain.MessageTypeBinary
+			if frame.Opcode == domain.OpcodeText {
+				msgType = domain.MessageTypeText
+			}
+			payload = frame.Payload
+			compressed = frame.RSV1
+		} else {
+			payload = append(payload, frame.Payload...)
+		}
+
+		if frame.FIN {
+			if compressed {
+				if payload, err = decompressPayload(payload, mr.maxMessageSize); err != nil {
+					return nil, err
+				}
+			}
+			msg := &domain.Message{Type: msgType, Payload: payload}
+			// Validate the whole message, since a rune may be split across fragments
+			if err := msg.ValidateText(); err != nil {
+				return nil, fmt.Errorf("%w: text message is not valid UTF-8", err)
+			}
+			return msg, nil
+		}
+	}
+}
+
+// readHeader reads the next frame header, applying the RateLimiter
+func (mr *MessageReader) readHeader() (*domain.Frame, error) {
+	frame, err := mr.parser.readHeader(mr.reader)
+	if err != nil {
+		return nil, err
+	}
+	if mr.onFrame != nil {
+		mr.onFrame()
+	}
+	if mr.RateLimiter != nil && !mr.RateLimiter.Allow(int(frame.PayloadLen)) {
+		return nil, domain.ErrRateLimited
+	}
+	return frame, nil
+}
+
+// checkFragments rejects a message once it has spanned more than MaxFragments frames
+func (mr *MessageReader) checkFragments(fragments int) error {
+	if mr.MaxFragments > 0 && fragments > mr.MaxFragments {
+		return fmt.Errorf("%w: message exceeds %d fragments", domain.ErrProtocolViolation, mr.MaxFragments)
+	}
+	return nil
+}
+
+// handleControl reads the payload of a control frame whose header was just read and
+// processes it, returning a *domain.CloseError for a close frame
+func (mr *MessageReader) handleControl(frame *domain.Frame) error {
+	if err := mr.parser.readPayload(mr.reader, frame); err != nil {
+		return err
+	}
+	if mr.ControlHandler != nil {
+		if err := mr.ControlHandler(frame); err != nil {
+			return err
+		}
+	}
+	if frame.Opcode == domain.OpcodePing {
+		if err := mr.handlePing(frame.Payload); err != nil {
+			return err
+		}
+	}
+	if frame.Opcode == domain.OpcodePong && mr.OnPong != nil {
+		if err := mr.OnPong(frame.Payload); err != nil {
+			return err
+		}
+	}
+	if frame.Opcode == domain.OpcodeClose {
+		code, reason, err := domain.ParseClosePayload(frame.Payload)
+		if err != nil {
+			return err
+		}
+		return &domain.CloseError{Code: code, Reason: reason}
+	}
+	return nil
+}
+
+// handlePing dispatches a ping payload to OnPing or the default pong reply
+func (mr *MessageReader) handlePing(payload []byte) error {
+	if mr.OnPing != nil {
+		return mr.OnPing(payload)
+	}
+	if mr.PongWriter == nil {
+		return nil
+	}
+	// Copy the payload, which may live in a pooled buffer; control frame limits already
+	// keep it within 125 bytes
+	pong := domain.NewFrame(domain.OpcodePong, append([]byte(nil), payload...))
+	return mr.parser.WriteFrame(mr.PongWriter, pong)
+}
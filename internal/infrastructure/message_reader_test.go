@@ -0,0 +1,397 @@
+package infrastructure
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+// writeFrames serializes frames into a buffer for MessageReader tests
+func writeFrames(t *testing.T, parser *FrameParser, frames ...*domain.Frame) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, frame := range frames {
+		if err := parser.WriteFrame(&buf, frame); err != nil {
+			t.Fatalf("Failed to write frame: %v", err)
+		}
+	}
+	return &buf
+}
+
+// fragment builds a data frame with an explicit FIN bit
+func fragment(opcode domain.Opcode, payload string, fin bool) *domain.Frame {
+	frame := domain.NewFrame(opcode, []byte(payload))
+	frame.FIN = fin
+	return frame
+}
+
+func TestMessageReader_ReassemblesFragments(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	buf := writeFrames(t, parser,
+		fragment(domain.OpcodeBinary, "hel", false),
+		fragment(domain.OpcodeContinuation, "lo ", false),
+		fragment(domain.OpcodeContinuation, "world", true),
+	)
+
+	msg, err := NewMessageReader(parser, buf, 0).ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if msg.Type != domain.MessageTypeBinary {
+		t.Errorf("Expected Binary message, got %v", msg.Type)
+	}
+	if string(msg.Payload) != "hello world" {
+		t.Errorf("Expected payload 'hello world', got %q", msg.Payload)
+	}
+}
+
+func TestMessageReader_FragmentedMessageTypeFromFirstFrame(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	buf := writeFrames(t, parser,
+		fragment(domain.OpcodeText, "one ", false),
+		fragment(domain.OpcodeContinuation, "two ", false),
+		fragment(domain.OpcodeContinuation, "three ", false),
+		fragment(domain.OpcodeContinuation, "four", true),
+		fragment(domain.OpcodeBinary, "bin", false),
+		fragment(domain.OpcodeContinuation, "ary", true),
+	)
+	reader := NewMessageReader(parser, buf, 0)
+
+	expected := []struct {
+		msgType domain.MessageType
+		payload string
+	}{
+		{domain.MessageTypeText, "one two three four"},
+		{domain.MessageTypeBinary, "binary"},
+	}
+	for _, want := range expected {
+		msg, err := reader.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage failed: %v", err)
+		}
+		if msg.Type != want.msgType || string(msg.Payload) != want.payload {
+			t.Errorf("expected %s %q, got %s %q", want.msgType, want.payload, msg.Type, msg.Payload)
+		}
+	}
+}
+
+func TestMessageReader_RejectsSingleFrameOverMessageLimit(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+
+	// Header only: declares 1000 bytes but no payload follows. If the reader tried to
+	// allocate and read the payload it would fail with an EOF instead.
+	header := []byte{0x82, 126, 0x03, 0xE8}
+
+	_, err := NewMessageReader(parser, bytes.NewReader(header), 512).ReadMessage()
+	if !errors.Is(err, domain.ErrPayloadTooLarge) {
+		t.Fatalf("Expected ErrPayloadTooLarge, got %v", err)
+	}
+	if code := domain.CloseCodeForError(err); code != protocol.StatusMessageTooBig {
+		t.Errorf("Expected close code %d, got %d", protocol.StatusMessageTooBig, code)
+	}
+}
+
+func TestMessageReader_RejectsFragmentsOverMessageLimit(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	buf := writeFrames(t, parser,
+		fragment(domain.OpcodeText, "0123456789", false),
+		fragment(domain.OpcodeContinuation, "0123456789", true),
+	)
+
+	_, err := NewMessageReader(parser, buf, 15).ReadMessage()
+	if !errors.Is(err, domain.ErrPayloadTooLarge) {
+		t.Fatalf("Expected ErrPayloadTooLarge, got %v", err)
+	}
+}
+
+func TestMessageReader_EmptyMessages(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	buf := writeFrames(t, parser,
+		fragment(domain.OpcodeText, "", true),
+		fragment(domain.OpcodeBinary, "", false),
+		fragment(domain.OpcodeContinuation, "", false),
+		fragment(domain.OpcodeContinuation, "", true),
+		fragment(domain.OpcodeText, "", false),
+		fragment(domain.OpcodeContinuation, "after empty start", true),
+	)
+	reader := NewMessageReader(parser, buf, 0)
+
+	expected := []struct {
+		msgType domain.MessageType
+		payload string
+	}{
+		{domain.MessageTypeText, ""},
+		{domain.MessageTypeBinary, ""},
+		{domain.MessageTypeText, "after empty start"},
+	}
+	for _, want := range expected {
+		msg, err := reader.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage failed: %v", err)
+		}
+		if msg.Type != want.msgType || string(msg.Payload) != want.payload || msg.Payload == nil {
+			t.Errorf("expected %s %q with a non-nil payload, got %s %#v", want.msgType, want.payload, msg.Type, msg.Payload)
+		}
+	}
+}
+
+func TestMessageReader_MaxFragments(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+
+	// A start frame followed by empty continuations, four frames in total
+	frames := func() *bytes.Buffer {
+		return writeFrames(t, parser,
+			fragment(domain.OpcodeBinary, "data", false),
+			fragment(domain.OpcodeContinuation, "", false),
+			fragment(domain.OpcodeContinuation, "", false),
+			fragment(domain.OpcodeContinuation, "", true),
+		)
+	}
+
+	reader := NewMessageReader(parser, frames(), 0)
+	reader.MaxFragments = 4
+	if msg, err := reader.ReadMessage(); err != nil || string(msg.Payload) != "data" {
+		t.Fatalf("expected a message at the fragment limit, got %v", err)
+	}
+
+	// Empty fragments count toward the limit too
+	reader = NewMessageReader(parser, frames(), 0)
+	reader.MaxFragments = 3
+	_, err := reader.ReadMessage()
+	if !errors.Is(err, domain.ErrProtocolViolation) {
+		t.Fatalf("Expected ErrProtocolViolation, got %v", err)
+	}
+	if code := domain.CloseCodeForError(err); code != protocol.StatusProtocolError {
+		t.Errorf("Expected close code %d, got %d", protocol.StatusProtocolError, code)
+	}
+}
+
+func TestMessageReader_InterleavedControlFrames(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	buf := writeFrames(t, parser,
+		fragment(domain.OpcodeText, "frag", false),
+		domain.NewFrame(domain.OpcodePing, []byte("p1")),
+		fragment(domain.OpcodeContinuation, "men", false),
+		domain.NewFrame(domain.OpcodePong, []byte("p2")),
+		fragment(domain.OpcodeContinuation, "ted", true),
+		domain.NewFrame(domain.OpcodeClose, nil),
+	)
+
+	var controls []domain.Opcode
+	reader := NewMessageReader(parser, buf, 0)
+	reader.ControlHandler = func(frame *domain.Frame) error {
+		controls = append(controls, frame.Opcode)
+		return nil
+	}
+
+	msg, err := reader.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if msg.Type != domain.MessageTypeText || string(msg.Payload) != "fragmented" {
+		t.Errorf("Expected text message 'fragmented', got %v %q", msg.Type, msg.Payload)
+	}
+
+	if _, err := reader.ReadMessage(); !errors.Is(err, domain.ErrConnectionClosed) {
+		t.Errorf("Expected ErrConnectionClosed after close frame, got %v", err)
+	}
+
+	expected := []domain.Opcode{domain.OpcodePing, domain.OpcodePong, domain.OpcodeClose}
+	if len(controls) != len(expected) {
+		t.Fatalf("Expected control frames %v, got %v", expected, controls)
+	}
+	for i := range expected {
+		if controls[i] != expected[i] {
+			t.Errorf("Expected control frames %v, got %v", expected, controls)
+		}
+	}
+}
+
+func TestMessageReader_FragmentSequenceViolations(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []*domain.Frame
+	}{
+		{"continuation without start", []*domain.Frame{
+			fragment(domain.OpcodeContinuation, "orphan", true),
+		}},
+		{"continuation after a complete message", []*domain.Frame{
+			fragment(domain.OpcodeText, "done", true),
+			fragment(domain.OpcodeContinuation, "late", true),
+		}},
+		{"new data frame mid-message", []*domain.Frame{
+			fragment(domain.OpcodeText, "first", false),
+			fragment(domain.OpcodeBinary, "second", true),
+		}},
+	}
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := NewMessageReader(parser, writeFrames(t, parser, tt.frames...), 0)
+			var err error
+			for err == nil {
+				_, err = reader.ReadMessage()
+			}
+			if !errors.Is(err, domain.ErrProtocolViolation) {
+				t.Errorf("Expected ErrProtocolViolation, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMessageReader_ValidatesUTF8AcrossFragments(t *testing.T) {
+	euro := "€" // 0xE2 0x82 0xAC
+
+	tests := []struct {
+		name    string
+		frames  []*domain.Frame
+		wantErr error
+	}{
+		{"rune split across fragments", []*domain.Frame{
+			fragment(domain.OpcodeText, "price "+euro[:1], false),
+			fragment(domain.OpcodeContinuation, euro[1:]+"5", true),
+		}, nil},
+		{"truncated rune at end of message", []*domain.Frame{
+			fragment(domain.OpcodeText, "price ", false),
+			fragment(domain.OpcodeContinuation, euro[:2], true),
+		}, domain.ErrInvalidFramePayloadData},
+		{"invalid byte in single frame", []*domain.Frame{
+			fragment(domain.OpcodeText, "bad\xff", true),
+		}, domain.ErrInvalidFramePayloadData},
+		{"binary message not checked", []*domain.Frame{
+			fragment(domain.OpcodeBinary, "bad\xff", true),
+		}, nil},
+	}
+
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := writeFrames(t, parser, tt.frames...)
+			_, err := NewMessageReader(parser, buf, 0).ReadMessage()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMessageReader_AnswersPingsWithPongs(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	ping := bytes.Repeat([]byte{0xA5}, protocol.MaxControlFramePayloadSize)
+	buf := writeFrames(t, parser,
+		domain.NewFrame(domain.OpcodePing, []byte("first")),
+		fragment(domain.OpcodeText, "hel", false),
+		domain.NewFrame(domain.OpcodePing, ping),
+		fragment(domain.OpcodeContinuation, "lo", true),
+	)
+
+	var pongs bytes.Buffer
+	reader := NewMessageReader(parser, buf, 0)
+	reader.PongWriter = &pongs
+
+	msg, err := reader.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if string(msg.Payload) != "hello" {
+		t.Errorf("Expected only the data message to surface, got %q", msg.Payload)
+	}
+
+	for _, expected := range [][]byte{[]byte("first"), ping} {
+		pong, err := parser.ReadFrame(&pongs)
+		if err != nil {
+			t.Fatalf("Failed to read pong: %v", err)
+		}
+		if pong.Opcode != domain.OpcodePong || !bytes.Equal(pong.Payload, expected) {
+			t.Errorf("Expected pong echoing %d bytes, got %s with %d bytes", len(expected), pong.Opcode, len(pong.Payload))
+		}
+	}
+	if pongs.Len() != 0 {
+		t.Errorf("Expected exactly two pongs, %d bytes left over", pongs.Len())
+	}
+}
+
+func TestMessageReader_OnPingOverridesDefault(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	buf := writeFrames(t, parser,
+		domain.NewFrame(domain.OpcodePing, []byte("custom")),
+		fragment(domain.OpcodeBinary, "data", true),
+	)
+
+	var pongs bytes.Buffer
+	var seen []string
+	reader := NewMessageReader(parser, buf, 0)
+	reader.PongWriter = &pongs
+	reader.OnPing = func(payload []byte) error {
+		seen = append(seen, string(payload))
+		return nil
+	}
+
+	if _, err := reader.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if len(seen) != 1 || seen[0] != "custom" {
+		t.Errorf("Expected OnPing to see the ping payload, got %v", seen)
+	}
+	if pongs.Len() != 0 {
+		t.Error("Expected OnPing to replace the default pong reply")
+	}
+}
+
+func TestMessageReader_UnsolicitedPong(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	frames := func() *bytes.Buffer {
+		return writeFrames(t, parser,
+			domain.NewFrame(domain.OpcodePong, []byte("heartbeat")),
+			fragment(domain.OpcodeText, "still delivered", true),
+		)
+	}
+
+	// Without OnPong a lone pong is consumed silently
+	msg, err := NewMessageReader(parser, frames(), 0).ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if string(msg.Payload) != "still delivered" {
+		t.Errorf("Expected the data message after the pong, got %q", msg.Payload)
+	}
+
+	var seen []string
+	reader := NewMessageReader(parser, frames(), 0)
+	reader.OnPong = func(payload []byte) error {
+		seen = append(seen, string(payload))
+		return nil
+	}
+	if _, err := reader.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if len(seen) != 1 || seen[0] != "heartbeat" {
+		t.Errorf("Expected OnPong to see the pong payload, got %v", seen)
+	}
+}
+
+func TestMessageReader_CloseFrameReportsCodeAndReason(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	buf := writeFrames(t, parser,
+		newCloseFrame(t, protocol.StatusPolicyViolation, "quota exceeded"),
+		domain.NewFrame(domain.OpcodeClose, []byte{0x03, 0xEE}), // 1006 must not be sent
+	)
+	reader := NewMessageReader(parser, buf, 0)
+
+	_, err := reader.ReadMessage()
+	var closeErr *domain.CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("Expected CloseError, got %v", err)
+	}
+	if closeErr.Code != protocol.StatusPolicyViolation || closeErr.Reason != "quota exceeded" {
+		t.Errorf("Expected 1008 'quota exceeded', got %d %q", closeErr.Code, closeErr.Reason)
+	}
+
+	if _, err := reader.ReadMessage(); !errors.Is(err, domain.ErrProtocolViolation) {
+		t.Errorf("Expected ErrProtocolViolation for an illegal close code, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package infrastructure
+
+import (
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+
+	"websocket-server/internal/domain"
+)
+
+// MessageStream reads the payload of one incoming message as it arrives, without
+// buffering the whole message. Read pulls bytes across fragment boundaries on demand and
+// returns io.EOF once the final fragment is exhausted. Control frames interleaved between
+// fragments are handled as in ReadMessage and never appear in the byte stream, and
+// messages compressed with permessage-deflate are decompressed as they are read.
+//
+// A stream must be read to io.EOF before the MessageReader is used again. Payloads are
+// unmasked as they are read, so the parser's ValidateFrame hook is not applied and text
+// messages are not checked for valid UTF-8.
+type MessageStream struct {
+	// Type is the type of the message being streamed
+	Type domain.MessageType
+
+	mr        *MessageReader
+	frame     *domain.Frame // Header of the fragment being read
+	remaining uint64        // Unread payload bytes of frame
+	maskPos   int           // Masking key offset of the next payload byte
+	total     uint64        // Payload bytes announced so far across fragments
+	fragments int           // Data frames read so far
+	rawErr    error         // Sticky error from the wire, io.EOF once the payload is consumed
+
+	inflate  io.ReadCloser // Decompressor over the wire payload, nil when uncompressed
+	inflated uint64        // Bytes produced by inflate so far
+	err      error         // Sticky error of a compressed stream, io.EOF once complete
+}
+
+// rawPayload reads a stream's payload exactly as sent, for the decompressor
+type rawPayload struct {
+	s *MessageStream
+}
+
+// Read reads compressed payload bytes
+func (r rawPayload) Read(p []byte) (int, error) {
+	return r.s.readRaw(p)
+}
+
+// NextStream reads up to the start of the next data message and returns a stream over
+// its payload. Control frames read on the way are handled as in ReadMessage. The
+// message is still subject to the reader's maximum message size.
+func (mr *MessageReader) NextStream() (*MessageStream, error) {
+	if mr.parser.Checksum {
+		return nil, fmt.Errorf("%w: checksummed frames cannot be streamed", domain.ErrInvalidConfig)
+	}
+
+	frame, err := mr.nextDataHeader()
+	if err != nil {
+		return nil, err
+	}
+	if frame.Opcode == domain.OpcodeContinuation {
+		return nil, fmt.Errorf("%w: continuation frame without a message in progress", domain.ErrProtocolViolation)
+	}
+
+	s := &MessageStream{Type: domain.MessageTypeBinary, mr: mr}
+	if frame.Opcode == domain.OpcodeText {
+		s.Type = domain.MessageTypeText
+	}
+	if err := s.begin(frame); err != nil {
+		return nil, err
+	}
+	if frame.RSV1 {
+		s.inflate = newFlateReader(rawPayload{s})
+	}
+	return s, nil
+}
+
+// nextDataHeader reads frame headers, handling any control frames, until the header of
+// a data frame is read
+func (mr *MessageReader) nextDataHeader() (*domain.Frame, error) {
+	for {
+		frame, err := mr.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		if !frame.Opcode.IsControl() {
+			return frame, nil
+		}
+		if err := mr.handleControl(frame); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Read reads payload bytes of the message into p
+func (s *MessageStream) Read(p []byte) (int, error) {
+	if s.inflate == nil {
+		return s.readRaw(p)
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	n, err := s.inflate.Read(p)
+	s.inflated += uint64(n)
+
+	var corrupt flate.CorruptInputError
+	switch {
+	case s.inflated > s.mr.maxMessageSize:
+		err = domain.ErrPayloadTooLarge
+	case errors.As(err, &corrupt):
+		err = fmt.Errorf("%w: corrupt compressed payload: %v", domain.ErrInvalidFramePayloadData, err)
+	case err == io.EOF && s.rawErr != io.EOF:
+		err = fmt.Errorf("%w: compressed data ends before the message", domain.ErrInvalidFramePayloadData)
+	}
+	if err != nil {
+		s.err = err
+		flateReaderPool.Put(s.inflate)
+	}
+	return n, err
+}
+
+// readRaw reads payload bytes as they were sent into p
+func (s *MessageStream) readRaw(p []byte) (int, error) {
+	if s.rawErr != nil {
+		return 0, s.rawErr
+	}
+
+	for s.remaining == 0 {
+		if s.frame.FIN {
+			s.rawErr = io.EOF
+			return 0, s.rawErr
+		}
+		if err := s.advance(); err != nil {
+			s.rawErr = err
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.mr.reader.Read(p)
+	if s.frame.Masked {
+		s.maskPos = maskBytes(s.frame.MaskingKey, s.maskPos, p[:n])
+	}
+	s.remaining -= uint64(n)
+
+	if err == io.EOF {
+		// The underlying stream ending is only an error while payload is still owed
+		err = nil
+		if s.remaining > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if err != nil {
+		s.rawErr = err
+	}
+	return n, err
+}
+
+// advance moves on to the next fragment of the message
+func (s *MessageStream) advance() error {
+	frame, err := s.mr.nextDataHeader()
+	if err == io.EOF {
+		// Running out of input between fragments is not the end of the message
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if frame.Opcode != domain.OpcodeContinuation {
+		return fmt.Errorf("%w: new %s frame while a fragmented message is in progress", domain.ErrProtocolViolation, frame.Opcode)
+	}
+	return s.begin(frame)
+}
+
+// begin starts reading the payload of frame, rejecting it on the declared length if the
+// message would exceed the reader's limit, or if it spans too many fragments
+func (s *MessageStream) begin(frame *domain.Frame) error {
+	s.fragments++
+	if err := s.mr.checkFragments(s.fragments); err != nil {
+		return err
+	}
+	if s.total+frame.PayloadLen > s.mr.maxMessageSize {
+		return domain.ErrPayloadTooLarge
+	}
+	s.total += frame.PayloadLen
+	s.frame = frame
+	s.remaining = frame.PayloadLen
+	s.maskPos = 0
+	return nil
+}
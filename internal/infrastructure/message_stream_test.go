@@ -0,0 +1,170 @@
+package infrastructure
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+func TestMessageStream_ReadsAcrossFragments(t *testing.T) {
+	// Masked frames exercise unmasking at uneven read boundaries
+	client, err := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+	if err != nil {
+		t.Fatalf("Failed to create client parser: %v", err)
+	}
+	server := NewServerFrameParser(protocol.MaxPayloadSize)
+
+	first := bytes.Repeat([]byte("0123456789"), 30)
+	second := bytes.Repeat([]byte("abcdefg"), 11)
+	buf := writeFrames(t, client,
+		fragment(domain.OpcodeBinary, string(first), false),
+		domain.NewFrame(domain.OpcodePing, []byte("keepalive")),
+		fragment(domain.OpcodeContinuation, string(second), true),
+		domain.NewFrame(domain.OpcodeBinary, []byte("next")),
+	)
+
+	var pongs bytes.Buffer
+	reader := NewMessageReader(server, buf, 0)
+	reader.PongWriter = &pongs
+
+	stream, err := reader.NextStream()
+	if err != nil {
+		t.Fatalf("NextStream failed: %v", err)
+	}
+	if stream.Type != domain.MessageTypeBinary {
+		t.Errorf("Expected Binary stream, got %v", stream.Type)
+	}
+
+	got, err := io.ReadAll(iotest.HalfReader(stream))
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if want := append(first, second...); !bytes.Equal(got, want) {
+		t.Errorf("Stream payload mismatch: got %d bytes, want %d", len(got), len(want))
+	}
+
+	// The ping between fragments was answered without surfacing in the stream
+	pong, err := NewFrameParser(protocol.MaxPayloadSize).ReadFrame(&pongs)
+	if err != nil {
+		t.Fatalf("Failed to read pong: %v", err)
+	}
+	if pong.Opcode != domain.OpcodePong || string(pong.Payload) != "keepalive" {
+		t.Errorf("Expected pong echoing the ping, got %s %q", pong.Opcode, pong.Payload)
+	}
+
+	// The reader is positioned at the next message
+	msg, err := reader.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage after stream failed: %v", err)
+	}
+	if string(msg.Payload) != "next" {
+		t.Errorf("Expected next message, got %q", msg.Payload)
+	}
+}
+
+func TestMessageStream_Errors(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+
+	truncated := writeFrames(t, parser, fragment(domain.OpcodeBinary, "partial payload", true))
+	truncated.Truncate(truncated.Len() - 4)
+
+	tests := []struct {
+		name         string
+		frames       []*domain.Frame
+		wire         *bytes.Buffer
+		maxSize      uint64
+		maxFragments int
+		wantErr      error
+		streamErr    bool
+	}{
+		{
+			name:    "stream ends mid-payload",
+			wire:    truncated,
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "stream ends between fragments",
+			frames:  []*domain.Frame{fragment(domain.OpcodeBinary, "first", false)},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name: "new message while fragmented",
+			frames: []*domain.Frame{
+				fragment(domain.OpcodeBinary, "first", false),
+				fragment(domain.OpcodeText, "second", true),
+			},
+			wantErr: domain.ErrProtocolViolation,
+		},
+		{
+			name: "message over limit",
+			frames: []*domain.Frame{
+				fragment(domain.OpcodeBinary, "0123456789", false),
+				fragment(domain.OpcodeContinuation, "0123456789", true),
+			},
+			maxSize: 15,
+			wantErr: domain.ErrPayloadTooLarge,
+		},
+		{
+			name: "too many fragments",
+			frames: []*domain.Frame{
+				fragment(domain.OpcodeBinary, "data", false),
+				fragment(domain.OpcodeContinuation, "", false),
+				fragment(domain.OpcodeContinuation, "", true),
+			},
+			maxFragments: 2,
+			wantErr:      domain.ErrProtocolViolation,
+		},
+		{
+			name:      "continuation without message",
+			frames:    []*domain.Frame{fragment(domain.OpcodeContinuation, "orphan", true)},
+			wantErr:   domain.ErrProtocolViolation,
+			streamErr: true,
+		},
+		{
+			name:      "close before message",
+			frames:    []*domain.Frame{domain.NewFrame(domain.OpcodeClose, nil)},
+			wantErr:   domain.ErrConnectionClosed,
+			streamErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wire := tt.wire
+			if wire == nil {
+				wire = writeFrames(t, parser, tt.frames...)
+			}
+
+			reader := NewMessageReader(parser, wire, tt.maxSize)
+			reader.MaxFragments = tt.maxFragments
+			stream, err := reader.NextStream()
+			if tt.streamErr {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("NextStream: expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NextStream failed: %v", err)
+			}
+			if _, err := io.ReadAll(stream); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Read: expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMessageStream_RejectsChecksumParser(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	parser.Checksum = true
+	buf := writeFrames(t, parser, domain.NewFrame(domain.OpcodeBinary, []byte("data")))
+
+	if _, err := NewMessageReader(parser, buf, 0).NextStream(); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package infrastructure
+
+import (
+	"fmt"
+	"io"
+
+	"websocket-server/internal/domain"
+)
+
+// DefaultMessageWriterBufferSize is the fragment size used by a MessageWriter when none
+// is given
+const DefaultMessageWriterBufferSize = 4096
+
+// MessageWriter writes one outgoing message incrementally. Data is buffered and sent as
+// a frame whenever the buffer fills: the first frame carries the message opcode and later
+// frames are continuations. Close sends the final frame with FIN set, even when it
+// carries no data.
+//
+// Frames go through the parser's WriteFrame, so masking, checksums and
+// MaxOutboundFrameSize apply to each of them. MaxOutboundFragments does not apply, as the
+// message length is not known up front, and text is not checked for valid UTF-8.
+type MessageWriter struct {
+	parser *FrameParser
+	writer io.Writer
+	opcode domain.Opcode // Opcode of the next frame, Continuation after the first
+	buf    []byte        // Pending payload, flushed as a frame once full
+	err    error         // Sticky error from a failed write or Close
+}
+
+// NewMessageWriter creates a MessageWriter sending a message of msgType to writer in
+// frames of bufferSize bytes. A bufferSize of 0 means DefaultMessageWriterBufferSize;
+// either is lowered to fit the parser's MaxOutboundFrameSize, checksum included, when
+// that is smaller.
+func NewMessageWriter(parser *FrameParser, writer io.Writer, msgType domain.MessageType, bufferSize int) *MessageWriter {
+	if bufferSize <= 0 {
+		bufferSize = DefaultMessageWriterBufferSize
+	}
+	limit, limitErr := parser.dataPayloadLimit()
+	if limit > 0 && uint64(bufferSize) > limit {
+		bufferSize = int(limit)
+	}
+
+	mw := &MessageWriter{
+		parser: parser,
+		writer: writer,
+		opcode: (&domain.Message{Type: msgType}).ToOpcode(),
+		buf:    make([]byte, 0, bufferSize),
+	}
+	if msgType != domain.MessageTypeText && msgType != domain.MessageTypeBinary {
+		mw.err = domain.ErrInvalidMessageType
+	} else if limitErr != nil {
+		mw.err = limitErr
+	}
+	return mw
+}
+
+// Write buffers p, sending a frame each time the buffer fills
+func (mw *MessageWriter) Write(p []byte) (int, error) {
+	if mw.err != nil {
+		return 0, mw.err
+	}
+
+	n := 0
+	for len(p) > 0 {
+		copied := copy(mw.buf[len(mw.buf):cap(mw.buf)], p)
+		mw.buf = mw.buf[:len(mw.buf)+copied]
+		p = p[copied:]
+		n += copied
+
+		if len(mw.buf) == cap(mw.buf) {
+			if err := mw.flushFrame(false); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close sends the buffered data as the final frame of the message. Writes after Close
+// fail with ErrInvalidState.
+func (mw *MessageWriter) Close() error {
+	if mw.err != nil {
+		return mw.err
+	}
+	if err := mw.flushFrame(true); err != nil {
+		return err
+	}
+	mw.err = fmt.Errorf("%w: message writer is closed", domain.ErrInvalidState)
+	return nil
+}
+
+// flushFrame sends the buffered data as one frame and empties the buffer
+func (mw *MessageWriter) flushFrame(fin bool) error {
+	frame := domain.NewFrame(mw.opcode, mw.buf)
+	frame.FIN = fin
+	if err := mw.parser.WriteFrame(mw.writer, frame); err != nil {
+		mw.err = err
+		return err
+	}
+	mw.opcode = domain.OpcodeContinuation
+	mw.buf = mw.buf[:0]
+	return nil
+}
@@ -0,0 +1,138 @@
+package infrastructure
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+func TestMessageWriter_EmitsFragments(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+
+	var wire bytes.Buffer
+	mw := NewMessageWriter(parser, &wire, domain.MessageTypeText, 8)
+	for _, chunk := range []string{"hello", " streaming", " world"} {
+		if _, err := mw.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	expected := []struct {
+		opcode  domain.Opcode
+		payload string
+		fin     bool
+	}{
+		{domain.OpcodeText, "hello st", false},
+		{domain.OpcodeContinuation, "reaming ", false},
+		{domain.OpcodeContinuation, "world", true},
+	}
+	for i, want := range expected {
+		frame, err := parser.ReadFrame(&wire)
+		if err != nil {
+			t.Fatalf("Failed to read frame %d: %v", i, err)
+		}
+		if frame.Opcode != want.opcode || string(frame.Payload) != want.payload || frame.FIN != want.fin {
+			t.Errorf("Frame %d: got %s %q FIN=%v, want %s %q FIN=%v",
+				i, frame.Opcode, frame.Payload, frame.FIN, want.opcode, want.payload, want.fin)
+		}
+	}
+	if wire.Len() != 0 {
+		t.Errorf("Expected exactly %d frames, %d bytes left over", len(expected), wire.Len())
+	}
+}
+
+func TestMessageWriter_CloseSendsEmptyFinalFrame(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+
+	var wire bytes.Buffer
+	mw := NewMessageWriter(parser, &wire, domain.MessageTypeBinary, 4)
+	if _, err := mw.Write([]byte("full")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	msg, err := NewMessageReader(parser, &wire, 0).ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if msg.Type != domain.MessageTypeBinary || string(msg.Payload) != "full" {
+		t.Errorf("Expected binary message 'full', got %v %q", msg.Type, msg.Payload)
+	}
+
+	if _, err := mw.Write([]byte("late")); !errors.Is(err, domain.ErrInvalidState) {
+		t.Errorf("Expected ErrInvalidState writing after Close, got %v", err)
+	}
+}
+
+func TestMessageWriter_RoundTripsThroughMessageStream(t *testing.T) {
+	client, err := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+	if err != nil {
+		t.Fatalf("Failed to create client parser: %v", err)
+	}
+	client.MaxOutboundFrameSize = 100
+
+	payload := bytes.Repeat([]byte("streamed payload "), 200)
+	var wire bytes.Buffer
+	mw := NewMessageWriter(client, &wire, domain.MessageTypeBinary, 0)
+	if _, err := mw.Write(payload); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	msg, err := NewMessageReader(NewServerFrameParser(protocol.MaxPayloadSize), &wire, 0).ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if !bytes.Equal(msg.Payload, payload) {
+		t.Errorf("Round-tripped payload mismatch: got %d bytes, want %d", len(msg.Payload), len(payload))
+	}
+}
+
+func TestMessageWriter_ChecksumWithOutboundLimit(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	parser.Checksum = true
+	parser.MaxOutboundFrameSize = 100
+
+	payload := bytes.Repeat([]byte("x"), 250)
+	var wire bytes.Buffer
+	mw := NewMessageWriter(parser, &wire, domain.MessageTypeBinary, 0)
+	if _, err := mw.Write(payload); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	msg, err := NewMessageReader(parser, &wire, 0).ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+	if !bytes.Equal(msg.Payload, payload) {
+		t.Errorf("Round-tripped payload mismatch: got %d bytes, want %d", len(msg.Payload), len(payload))
+	}
+}
+
+func TestMessageWriter_RejectsInvalidType(t *testing.T) {
+	var wire bytes.Buffer
+	mw := NewMessageWriter(NewFrameParser(protocol.MaxPayloadSize), &wire, domain.MessageType(99), 0)
+
+	if _, err := mw.Write([]byte("data")); !errors.Is(err, domain.ErrInvalidMessageType) {
+		t.Errorf("Expected ErrInvalidMessageType from Write, got %v", err)
+	}
+	if err := mw.Close(); !errors.Is(err, domain.ErrInvalidMessageType) {
+		t.Errorf("Expected ErrInvalidMessageType from Close, got %v", err)
+	}
+	if wire.Len() != 0 {
+		t.Errorf("Expected nothing written, got %d bytes", wire.Len())
+	}
+}
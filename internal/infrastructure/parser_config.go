@@ -0,0 +1,76 @@
+package infrastructure
+
+import (
+	"fmt"
+
+	"websocket-server/internal/domain"
+)
+
+// Role identifies which side of a connection a FrameParser serves
+type Role int
+
+const (
+	// RoleServer reads masked client frames and writes unmasked frames
+	RoleServer Role = iota
+	// RoleClient writes masked frames and reads unmasked server frames
+	RoleClient
+)
+
+// String returns the string representation of the role
+func (r Role) String() string {
+	switch r {
+	case RoleServer:
+		return "Server"
+	case RoleClient:
+		return "Client"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(r))
+	}
+}
+
+// ParserConfig describes how a FrameParser is set up for one side of a connection
+type ParserConfig struct {
+	Role           Role   // Side of the connection the parser serves
+	MaxPayloadSize uint64 // Maximum accepted payload size, 0 for the default
+	MaskFrames     bool   // Mask outgoing frames with a random key
+	RequireMask    bool   // Reject incoming frames that are not masked
+}
+
+// Validate checks the configuration against the masking rules of RFC 6455: a server must
+// never mask the frames it sends and must require masked client frames, and a client
+// must do the opposite.
+func (c ParserConfig) Validate() error {
+	switch c.Role {
+	case RoleServer:
+		if c.MaskFrames {
+			return fmt.Errorf("%w: server role must not mask outgoing frames", domain.ErrInvalidConfig)
+		}
+		if !c.RequireMask {
+			return fmt.Errorf("%w: server role must require masked client frames", domain.ErrInvalidConfig)
+		}
+	case RoleClient:
+		if !c.MaskFrames {
+			return fmt.Errorf("%w: client role must mask outgoing frames", domain.ErrInvalidConfig)
+		}
+		if c.RequireMask {
+			return fmt.Errorf("%w: client role must not require masked server frames", domain.ErrInvalidConfig)
+		}
+	default:
+		return fmt.Errorf("%w: unknown role %s", domain.ErrInvalidConfig, c.Role)
+	}
+	return nil
+}
+
+// NewFrameParserWithConfig creates a FrameParser for the given role, rejecting
+// configurations that would violate RFC 6455
+func NewFrameParserWithConfig(cfg ParserConfig) (*FrameParser, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	fp := NewFrameParser(cfg.MaxPayloadSize)
+	fp.maskFrames = cfg.MaskFrames
+	fp.requireMask = cfg.RequireMask
+	fp.rejectMasked = cfg.Role == RoleClient
+	return fp, nil
+}
@@ -0,0 +1,98 @@
+package infrastructure
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"websocket-server/internal/domain"
+)
+
+func TestParserConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ParserConfig
+		wantErr bool
+	}{
+		{"server", ParserConfig{Role: RoleServer, RequireMask: true}, false},
+		{"client", ParserConfig{Role: RoleClient, MaskFrames: true}, false},
+		{"server masking frames", ParserConfig{Role: RoleServer, MaskFrames: true, RequireMask: true}, true},
+		{"server not requiring mask", ParserConfig{Role: RoleServer}, true},
+		{"client not masking", ParserConfig{Role: RoleClient}, true},
+		{"client requiring mask", ParserConfig{Role: RoleClient, MaskFrames: true, RequireMask: true}, true},
+		{"unknown role", ParserConfig{Role: Role(7), RequireMask: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr && !errors.Is(err, domain.ErrInvalidConfig) {
+				t.Errorf("expected ErrInvalidConfig, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewFrameParserWithConfig_RejectsMaskingServer(t *testing.T) {
+	parser, err := NewFrameParserWithConfig(ParserConfig{Role: RoleServer, MaskFrames: true, RequireMask: true})
+	if !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+	if parser != nil {
+		t.Error("expected no parser for an invalid configuration")
+	}
+}
+
+func TestNewFrameParserWithConfig_ClientServerRoundTrip(t *testing.T) {
+	server, err := NewFrameParserWithConfig(ParserConfig{Role: RoleServer, RequireMask: true})
+	if err != nil {
+		t.Fatalf("server config rejected: %v", err)
+	}
+	client, err := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+	if err != nil {
+		t.Fatalf("client config rejected: %v", err)
+	}
+
+	// Client to server: masked on the wire, accepted by the server
+	original := domain.NewFrame(domain.OpcodeText, []byte("from client"))
+	var buf bytes.Buffer
+	if err := client.WriteFrame(&buf, original); err != nil {
+		t.Fatalf("client write failed: %v", err)
+	}
+	if buf.Bytes()[1]&0x80 == 0 {
+		t.Error("expected client frame to be masked on the wire")
+	}
+	if original.Masked {
+		t.Error("expected caller's frame to be left untouched")
+	}
+	frame, err := server.ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("server read failed: %v", err)
+	}
+	if string(frame.Payload) != "from client" {
+		t.Errorf("payload mismatch: %q", frame.Payload)
+	}
+
+	// Server to client: unmasked frames are accepted, masked ones rejected
+	buf.Reset()
+	_ = server.WriteFrame(&buf, domain.NewFrame(domain.OpcodeText, []byte("from server")))
+	if _, err := client.ReadFrame(&buf); err != nil {
+		t.Fatalf("client read failed: %v", err)
+	}
+
+	buf.Reset()
+	_ = client.WriteFrame(&buf, domain.NewFrame(domain.OpcodeText, []byte("masked")))
+	if _, err := client.ReadFrame(&buf); !errors.Is(err, domain.ErrMaskedServerFrame) {
+		t.Errorf("expected ErrMaskedServerFrame, got %v", err)
+	}
+
+	// Unmasked frames never reach a server
+	buf.Reset()
+	_ = NewFrameParser(0).WriteFrame(&buf, domain.NewFrame(domain.OpcodeText, []byte("plain")))
+	if _, err := server.ReadFrame(&buf); !errors.Is(err, domain.ErrUnmaskedClientFrame) {
+		t.Errorf("expected ErrUnmaskedClientFrame, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package infrastructure
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter decides whether an inbound frame may be processed. MessageReader consults
+// it with the payload size of every frame read, control frames included, before the
+// payload is read; once it returns false the read fails with ErrRateLimited and Conn
+// closes the connection with StatusPolicyViolation.
+type RateLimiter interface {
+	Allow(bytes int) bool
+}
+
+// TokenBucket is a RateLimiter refilling at a steady rate up to a burst size. Created by
+// NewByteRateLimiter it meters payload bytes, by NewFrameRateLimiter whole frames. It is
+// safe for concurrent use, so one bucket may also cap several connections together.
+type TokenBucket struct {
+	mu       sync.Mutex
+	rate     float64 // Tokens added per second
+	burst    float64 // Bucket capacity
+	tokens   float64
+	last     time.Time
+	perFrame bool             // Each frame costs one token rather than its size
+	now      func() time.Time // Clock, replaced in tests
+}
+
+// NewByteRateLimiter allows bytesPerSecond payload bytes on average, with bursts of up
+// to burst bytes. A frame larger than burst is never allowed, so burst should be at
+// least the largest frame accepted.
+func NewByteRateLimiter(bytesPerSecond float64, burst int) *TokenBucket {
+	return newTokenBucket(bytesPerSecond, burst, false)
+}
+
+// NewFrameRateLimiter allows framesPerSecond frames on average whatever their size, with
+// bursts of up to burst frames
+func NewFrameRateLimiter(framesPerSecond float64, burst int) *TokenBucket {
+	return newTokenBucket(framesPerSecond, burst, true)
+}
+
+// newTokenBucket creates a full bucket
+func newTokenBucket(rate float64, burst int, perFrame bool) *TokenBucket {
+	tb := &TokenBucket{
+		rate:     rate,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		perFrame: perFrame,
+		now:      time.Now,
+	}
+	tb.last = tb.now()
+	return tb
+}
+
+// Allow takes the tokens for a frame of the given payload size, reporting false and
+// taking nothing when the bucket holds too few
+func (tb *TokenBucket) Allow(bytes int) bool {
+	cost := float64(bytes)
+	if tb.perFrame {
+		cost = 1
+	}
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	if cost > tb.tokens {
+		return false
+	}
+	tb.tokens -= cost
+	return true
+}
@@ -0,0 +1,120 @@
+package infrastructure
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+// fakeClock is a manually advanced time source for TokenBucket tests
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestTokenBucket_Bytes(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	tb := NewByteRateLimiter(100, 200)
+	tb.now, tb.last = clock.now, clock.now()
+
+	if !tb.Allow(150) || !tb.Allow(50) {
+		t.Fatal("expected the initial burst to be allowed")
+	}
+	if tb.Allow(1) {
+		t.Fatal("expected an empty bucket to refuse")
+	}
+
+	// Half a second refills 50 bytes
+	clock.advance(500 * time.Millisecond)
+	if tb.Allow(60) {
+		t.Error("expected a frame larger than the refill to be refused")
+	}
+	if !tb.Allow(50) {
+		t.Error("expected the refilled bytes to be allowed")
+	}
+
+	// Idle time never fills the bucket past its burst
+	clock.advance(time.Hour)
+	if tb.Allow(201) {
+		t.Error("expected a frame larger than the burst to be refused")
+	}
+	if !tb.Allow(200) {
+		t.Error("expected a full burst after idling")
+	}
+}
+
+func TestTokenBucket_Frames(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	tb := NewFrameRateLimiter(2, 2)
+	tb.now, tb.last = clock.now, clock.now()
+
+	// Size does not matter, only the number of frames
+	if !tb.Allow(1<<20) || !tb.Allow(0) {
+		t.Fatal("expected two frames to be allowed")
+	}
+	if tb.Allow(0) {
+		t.Fatal("expected a third frame to be refused")
+	}
+	clock.advance(500 * time.Millisecond)
+	if !tb.Allow(10) {
+		t.Error("expected one frame to be allowed after half a second")
+	}
+}
+
+func TestMessageReader_RateLimiter(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	buf := writeFrames(t, parser,
+		domain.NewFrame(domain.OpcodeText, []byte("first")),
+		domain.NewFrame(domain.OpcodePing, nil),
+		domain.NewFrame(domain.OpcodeText, []byte("second")),
+	)
+
+	reader := NewMessageReader(parser, buf, 0)
+	reader.RateLimiter = NewFrameRateLimiter(0, 2)
+	if _, err := reader.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+
+	// The ping takes the last token, so the next data frame is refused
+	_, err := reader.ReadMessage()
+	if !errors.Is(err, domain.ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if code := domain.CloseCodeForError(err); code != protocol.StatusPolicyViolation {
+		t.Errorf("expected close code %d, got %d", protocol.StatusPolicyViolation, code)
+	}
+}
+
+func TestConn_RateLimitClosesWithPolicyViolation(t *testing.T) {
+	server, result := upgradeServer(t, func(c *Conn) error {
+		c.SetRateLimiter(NewByteRateLimiter(0, 10))
+		for {
+			if _, err := c.ReadMessage(); err != nil {
+				return err
+			}
+		}
+	})
+	conn, br := dialWebSocket(t, server)
+	client, _ := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodeBinary, []byte("ok")))
+	_ = client.WriteFrame(conn, domain.NewFrame(domain.OpcodeBinary, []byte("way too much")))
+
+	frame, err := NewFrameParser(protocol.MaxPayloadSize).ReadFrame(br)
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if frame.Opcode != domain.OpcodeClose || binary.BigEndian.Uint16(frame.Payload) != protocol.StatusPolicyViolation {
+		t.Errorf("expected a 1008 close frame, got %s %x", frame.Opcode, frame.Payload)
+	}
+	if err := <-result; !errors.Is(err, domain.ErrRateLimited) {
+		t.Errorf("expected ReadMessage to fail with ErrRateLimited, got %v", err)
+	}
+}
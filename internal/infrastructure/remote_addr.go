@@ -0,0 +1,83 @@
+package infrastructure
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"websocket-server/internal/domain"
+)
+
+// Proxy headers carrying the client address
+const (
+	headerForwardedFor = "X-Forwarded-For"
+	headerRealIP       = "X-Real-IP"
+)
+
+// ForwardedRemoteAddr returns a RemoteAddrFunc that reports the client address given by
+// proxy headers, trusting them only on requests that arrive from one of trustedProxies,
+// each an IP address or CIDR prefix such as "10.0.0.0/8". X-Forwarded-For is read from
+// right to left, skipping trusted proxies, so a client cannot spoof its address by
+// sending the header itself; X-Real-IP is used when there is no X-Forwarded-For. Any
+// other request keeps req.RemoteAddr.
+func ForwardedRemoteAddr(trustedProxies ...string) (func(req *http.Request) string, error) {
+	prefixes := make([]netip.Prefix, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		prefix, err := parseProxy(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%w: trusted proxy %q is not an IP address or CIDR prefix", domain.ErrInvalidConfig, proxy)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	trusted := func(addr string) bool {
+		ip, err := netip.ParseAddr(strings.TrimSpace(addr))
+		if err != nil {
+			return false
+		}
+		ip = ip.Unmap()
+		for _, prefix := range prefixes {
+			if prefix.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(req *http.Request) string {
+		peer, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil || !trusted(peer) {
+			return req.RemoteAddr
+		}
+
+		if forwarded := req.Header.Values(headerForwardedFor); len(forwarded) > 0 {
+			hops := strings.Split(strings.Join(forwarded, ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := strings.TrimSpace(hops[i])
+				if i == 0 || !trusted(hop) {
+					return hop
+				}
+			}
+		}
+		if realIP := strings.TrimSpace(req.Header.Get(headerRealIP)); realIP != "" {
+			return realIP
+		}
+		return req.RemoteAddr
+	}, nil
+}
+
+// parseProxy parses a trusted proxy given as an IP address or CIDR prefix
+func parseProxy(proxy string) (netip.Prefix, error) {
+	if strings.Contains(proxy, "/") {
+		prefix, err := netip.ParsePrefix(proxy)
+		return prefix.Masked(), err
+	}
+	ip, err := netip.ParseAddr(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
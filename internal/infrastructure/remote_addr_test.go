@@ -0,0 +1,56 @@
+package infrastructure
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"websocket-server/internal/domain"
+)
+
+func TestForwardedRemoteAddr(t *testing.T) {
+	remoteAddr, err := ForwardedRemoteAddr("10.0.0.0/8", "192.168.1.1", "::1")
+	if err != nil {
+		t.Fatalf("ForwardedRemoteAddr failed: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string
+		realIP    string
+		expected  string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "", "203.0.113.7:5000"},
+		{"untrusted peer ignores headers", "203.0.113.7:5000", []string{"1.2.3.4"}, "5.6.7.8", "203.0.113.7:5000"},
+		{"trusted proxy", "10.1.2.3:80", []string{"198.51.100.4"}, "", "198.51.100.4"},
+		{"spoofed entry skipped", "10.1.2.3:80", []string{"1.2.3.4, 198.51.100.4"}, "", "198.51.100.4"},
+		{"proxy chain", "10.1.2.3:80", []string{"198.51.100.4, 192.168.1.1", "10.9.9.9"}, "", "198.51.100.4"},
+		{"all hops trusted", "10.1.2.3:80", []string{"10.0.0.1, 10.0.0.2"}, "", "10.0.0.1"},
+		{"real ip", "192.168.1.1:80", nil, "198.51.100.9", "198.51.100.9"},
+		{"forwarded wins over real ip", "192.168.1.1:80", []string{"198.51.100.4"}, "198.51.100.9", "198.51.100.4"},
+		{"ipv6 proxy", "[::1]:80", []string{"2001:db8::1"}, "", "2001:db8::1"},
+		{"trusted proxy without headers", "10.1.2.3:80", nil, "", "10.1.2.3:80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &http.Request{RemoteAddr: tt.peer, Header: http.Header{}}
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := remoteAddr(req); got != tt.expected {
+				t.Errorf("remote address = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestForwardedRemoteAddr_InvalidProxy(t *testing.T) {
+	if _, err := ForwardedRemoteAddr("10.0.0.0/8", "proxy.internal"); !errors.Is(err, domain.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
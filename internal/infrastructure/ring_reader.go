@@ -0,0 +1,176 @@
+package infrastructure
+
+import (
+	"encoding/binary"
+	"io"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+// RingFrameReader parses frames out of a fixed, reusable buffer refilled from the
+// underlying reader, so steady-state reads allocate nothing at all.
+//
+// This is an advanced API with strict lifetime rules: the frame returned by Next, and
+// the payload it points into, are only valid until the following call to Next. Copy
+// anything that must outlive that. Frames must fit in the buffer whole; larger frames
+// fail with ErrPayloadTooLarge. Use ReadFrame when these rules are inconvenient.
+type RingFrameReader struct {
+	parser *FrameParser
+	reader io.Reader
+	buf    []byte
+	start  int          // offset of the first unparsed byte
+	end    int          // offset one past the last buffered byte
+	frame  domain.Frame // reused for every frame returned
+}
+
+// NewRingFrameReader creates a RingFrameReader with a buffer of size bytes
+func NewRingFrameReader(parser *FrameParser, reader io.Reader, size int) *RingFrameReader {
+	if size < 14 {
+		size = 14 // Room for the largest frame header
+	}
+	return &RingFrameReader{
+		parser: parser,
+		reader: reader,
+		buf:    make([]byte, size),
+	}
+}
+
+// Next returns the next frame. Its payload is a sub-slice of the internal buffer and is
+// overwritten by the following call.
+func (rr *RingFrameReader) Next() (*domain.Frame, error) {
+	frame, err := rr.next()
+	if err != nil {
+		rr.parser.reportError(err)
+		return nil, err
+	}
+	rr.parser.reportRead(frame)
+	return frame, nil
+}
+
+// next does the work of Next
+func (rr *RingFrameReader) next() (*domain.Frame, error) {
+	for {
+		headerLen, err := rr.parseHeader(rr.buf[rr.start:rr.end])
+		if err != nil {
+			return nil, err
+		}
+
+		if headerLen > 0 {
+			frameLen := uint64(headerLen) + rr.frame.PayloadLen
+			if frameLen > uint64(len(rr.buf)) {
+				return nil, domain.ErrPayloadTooLarge
+			}
+			if uint64(rr.end-rr.start) >= frameLen {
+				return rr.complete(headerLen, int(frameLen))
+			}
+		}
+
+		if err := rr.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseHeader decodes the frame header at the start of b into rr.frame, returning its
+// length, or zero if b does not hold the whole header yet
+func (rr *RingFrameReader) parseHeader(b []byte) (int, error) {
+	if len(b) < 2 {
+		return 0, nil
+	}
+
+	frame := &rr.frame
+	*frame = domain.Frame{
+		FIN:    (b[0] & 0x80) != 0,
+		RSV1:   (b[0] & 0x40) != 0,
+		RSV2:   (b[0] & 0x20) != 0,
+		RSV3:   (b[0] & 0x10) != 0,
+		Opcode: domain.Opcode(b[0] & 0x0F),
+		Masked: (b[1] & 0x80) != 0,
+	}
+	if err := rr.parser.checkFirstByte(frame); err != nil {
+		return 0, err
+	}
+
+	n := 2
+	payloadLen := uint64(b[1] & 0x7F)
+	switch payloadLen {
+	case protocol.PayloadLen16Bit:
+		if len(b) < n+2 {
+			return 0, nil
+		}
+		frame.PayloadLen = uint64(binary.BigEndian.Uint16(b[n:]))
+		n += 2
+	case protocol.PayloadLen64Bit:
+		if len(b) < n+8 {
+			return 0, nil
+		}
+		frame.PayloadLen = binary.BigEndian.Uint64(b[n:])
+		n += 8
+	default:
+		frame.PayloadLen = payloadLen
+	}
+
+	if err := rr.parser.checkLengthEncoding(payloadLen, frame.PayloadLen); err != nil {
+		return 0, err
+	}
+	if err := rr.parser.checkLength(frame); err != nil {
+		return 0, err
+	}
+
+	if frame.Masked {
+		if len(b) < n+4 {
+			return 0, nil
+		}
+		copy(frame.MaskingKey[:], b[n:n+4])
+		n += 4
+	}
+
+	return n, nil
+}
+
+// complete points rr.frame at its payload in the buffer and consumes the frame
+func (rr *RingFrameReader) complete(headerLen, frameLen int) (*domain.Frame, error) {
+	frame := &rr.frame
+	frame.Payload = rr.buf[rr.start+headerLen : rr.start+frameLen : rr.start+frameLen]
+	rr.start += frameLen
+
+	// The buffer is ours, so the payload is unmasked in place
+	if frame.Masked {
+		rr.parser.UnmaskPayload(frame.Payload, frame.MaskingKey)
+	}
+
+	if rr.parser.Checksum && frame.IsDataFrame() {
+		if err := verifyChecksum(frame); err != nil {
+			return nil, err
+		}
+	}
+	if rr.parser.ValidateFrame != nil {
+		if err := rr.parser.ValidateFrame(frame); err != nil {
+			return nil, err
+		}
+	}
+
+	return frame, nil
+}
+
+// fill moves unparsed bytes to the front of the buffer and reads more after them
+func (rr *RingFrameReader) fill() error {
+	if rr.start > 0 {
+		rr.end = copy(rr.buf, rr.buf[rr.start:rr.end])
+		rr.start = 0
+	}
+
+	n, err := rr.reader.Read(rr.buf[rr.end:])
+	rr.end += n
+	if n > 0 {
+		return nil
+	}
+	if err == io.EOF && rr.end > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package infrastructure
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"websocket-server/internal/domain"
+	"websocket-server/pkg/protocol"
+)
+
+func TestRingFrameReader_ReadsFramesAcrossRefills(t *testing.T) {
+	payloads := [][]byte{
+		[]byte("small"),
+		bytes.Repeat([]byte("m"), 200),
+		{},
+		bytes.Repeat([]byte("l"), 1000),
+	}
+
+	writer, err := NewFrameParserWithConfig(ParserConfig{Role: RoleClient, MaskFrames: true})
+	if err != nil {
+		t.Fatalf("NewFrameParserWithConfig failed: %v", err)
+	}
+	var wire bytes.Buffer
+	for _, p := range payloads {
+		if err := writer.WriteFrame(&wire, domain.NewFrame(domain.OpcodeBinary, p)); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+
+	// Deliver one byte per Read so headers and payloads straddle refills
+	rr := NewRingFrameReader(NewFrameParser(protocol.MaxPayloadSize), iotest.OneByteReader(&wire), 1100)
+	for i, want := range payloads {
+		frame, err := rr.Next()
+		if err != nil {
+			t.Fatalf("frame %d: Next failed: %v", i, err)
+		}
+		if !bytes.Equal(frame.Payload, want) {
+			t.Errorf("frame %d: payload mismatch, got %d bytes", i, len(frame.Payload))
+		}
+	}
+
+	if _, err := rr.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF at a frame boundary, got %v", err)
+	}
+}
+
+func TestRingFrameReader_Errors(t *testing.T) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+
+	var oversized bytes.Buffer
+	_ = parser.WriteFrame(&oversized, domain.NewFrame(domain.OpcodeBinary, make([]byte, 100)))
+
+	var truncated bytes.Buffer
+	_ = parser.WriteFrame(&truncated, domain.NewFrame(domain.OpcodeText, []byte("cut short")))
+	truncated.Truncate(truncated.Len() - 3)
+
+	tests := []struct {
+		name    string
+		wire    []byte
+		size    int
+		wantErr error
+	}{
+		{"frame larger than buffer", oversized.Bytes(), 64, domain.ErrPayloadTooLarge},
+		{"stream ends mid-frame", truncated.Bytes(), 64, io.ErrUnexpectedEOF},
+		{"reserved opcode", []byte{0x83, 0x00}, 64, domain.ErrInvalidOpcode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := NewRingFrameReader(parser, bytes.NewReader(tt.wire), tt.size)
+			if _, err := rr.Next(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// repeatReader replays data forever, standing in for a socket delivering a steady stream
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.data[r.off:])
+		n += c
+		r.off = (r.off + c) % len(r.data)
+	}
+	return n, nil
+}
+
+// smallFrameStream returns 64 back-to-back 32-byte binary frames
+func smallFrameStream(b *testing.B) []byte {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	var wire bytes.Buffer
+	for i := 0; i < 64; i++ {
+		if err := parser.WriteFrame(&wire, domain.NewFrame(domain.OpcodeBinary, bytes.Repeat([]byte{byte(i)}, 32))); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return wire.Bytes()
+}
+
+func BenchmarkReadFrame_SmallFrames(b *testing.B) {
+	parser := NewFrameParser(protocol.MaxPayloadSize)
+	reader := &repeatReader{data: smallFrameStream(b)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parser.ReadFrame(reader); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRingFrameReader_SmallFrames(b *testing.B) {
+	rr := NewRingFrameReader(NewFrameParser(protocol.MaxPayloadSize), &repeatReader{data: smallFrameStream(b)}, 4096)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := rr.Next(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
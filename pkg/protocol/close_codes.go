@@ -0,0 +1,48 @@
+package protocol
+
+import "fmt"
+
+// IsValidCloseCode reports whether code may appear in a close frame sent by a peer.
+// Only 1000-1003, 1007-1013 and the 3000-4999 range for libraries and applications are
+// valid. Codes below 1000, the codes reserved for local use (1005, 1006, 1015), every
+// other code up to 2999, including 1014, and codes from 5000 up are invalid.
+func IsValidCloseCode(code uint16) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code >= StatusNormalClosure && code <= StatusUnsupportedData:
+		return true
+	case code >= StatusInvalidFramePayloadData && code <= StatusTryAgainLater:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeCodeNames maps the defined close status codes to their names
+var closeCodeNames = map[uint16]string{
+	StatusNormalClosure:           "NormalClosure",
+	StatusGoingAway:               "GoingAway",
+	StatusProtocolError:           "ProtocolError",
+	StatusUnsupportedData:         "UnsupportedData",
+	StatusNoStatusReceived:        "NoStatusReceived",
+	StatusAbnormalClosure:         "AbnormalClosure",
+	StatusInvalidFramePayloadData: "InvalidFramePayloadData",
+	StatusPolicyViolation:         "PolicyViolation",
+	StatusMessageTooBig:           "MessageTooBig",
+	StatusMandatoryExtension:      "MandatoryExtension",
+	StatusInternalServerError:     "InternalServerError",
+	StatusServiceRestart:          "ServiceRestart",
+	StatusTryAgainLater:           "TryAgainLater",
+	StatusBadGateway:              "BadGateway",
+	StatusTLSHandshake:            "TLSHandshake",
+}
+
+// CloseCodeName returns the name of a close status code, e.g. "MessageTooBig" for 1009,
+// or "Unknown(4001)" for codes without a defined constant such as application codes
+func CloseCodeName(code uint16) string {
+	if name, ok := closeCodeNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown(%d)", code)
+}
@@ -0,0 +1,65 @@
+package protocol
+
+import "testing"
+
+func TestIsValidCloseCode(t *testing.T) {
+	tests := []struct {
+		code  uint16
+		valid bool
+	}{
+		{0, false},
+		{999, false},
+		{StatusNormalClosure, true},
+		{StatusGoingAway, true},
+		{StatusProtocolError, true},
+		{StatusUnsupportedData, true},
+		{1004, false},
+		{StatusNoStatusReceived, false},
+		{StatusAbnormalClosure, false},
+		{StatusInvalidFramePayloadData, true},
+		{StatusPolicyViolation, true},
+		{StatusMessageTooBig, true},
+		{StatusMandatoryExtension, true},
+		{StatusInternalServerError, true},
+		{StatusServiceRestart, true},
+		{StatusTryAgainLater, true},
+		{StatusBadGateway, false},
+		{StatusTLSHandshake, false},
+		{1016, false},
+		{2999, false},
+		{3000, true},
+		{4999, true},
+		{5000, false},
+		{65535, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsValidCloseCode(tt.code); got != tt.valid {
+			t.Errorf("IsValidCloseCode(%d) = %v, want %v", tt.code, got, tt.valid)
+		}
+	}
+}
+
+func TestCloseCodeName(t *testing.T) {
+	tests := []struct {
+		code     uint16
+		expected string
+	}{
+		{StatusNormalClosure, "NormalClosure"},
+		{StatusGoingAway, "GoingAway"},
+		{StatusNoStatusReceived, "NoStatusReceived"},
+		{StatusPolicyViolation, "PolicyViolation"},
+		{StatusMessageTooBig, "MessageTooBig"},
+		{StatusTLSHandshake, "TLSHandshake"},
+		{1004, "Unknown(1004)"},
+		{4001, "Unknown(4001)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if got := CloseCodeName(tt.code); got != tt.expected {
+				t.Errorf("CloseCodeName(%d) = %v, want %v", tt.code, got, tt.expected)
+			}
+		})
+	}
+}
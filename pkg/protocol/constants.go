@@ -10,17 +10,22 @@ const (
 	WebSocketVersion = "13"
 
 	// Header names
-	HeaderUpgrade              = "Upgrade"
-	HeaderConnection           = "Connection"
-	HeaderSecWebSocketKey      = "Sec-WebSocket-Key"
-	HeaderSecWebSocketAccept   = "Sec-WebSocket-Accept"
-	HeaderSecWebSocketVersion  = "Sec-WebSocket-Version"
-	HeaderSecWebSocketProtocol = "Sec-WebSocket-Protocol"
+	HeaderUpgrade                = "Upgrade"
+	HeaderConnection             = "Connection"
+	HeaderSecWebSocketKey        = "Sec-WebSocket-Key"
+	HeaderSecWebSocketAccept     = "Sec-WebSocket-Accept"
+	HeaderSecWebSocketVersion    = "Sec-WebSocket-Version"
+	HeaderSecWebSocketProtocol   = "Sec-WebSocket-Protocol"
+	HeaderSecWebSocketExtensions = "Sec-WebSocket-Extensions"
 
 	// Header values
 	HeaderValueWebSocket = "websocket"
 	HeaderValueUpgrade   = "Upgrade"
 
+	// Extension tokens
+	ExtensionFrameChecksum     = "x-frame-crc32" // Non-standard, see FrameParser.Checksum
+	ExtensionPermessageDeflate = "permessage-deflate"
+
 	// Close status codes
 	StatusNormalClosure           = 1000
 	StatusGoingAway               = 1001
@@ -41,8 +46,14 @@ const (
 	// Frame size limits
 	MaxControlFramePayloadSize = 125
 	MaxPayloadSize             = 1 << 20 // 1MB default max payload size
+	MaxMessageSize             = 1 << 20 // 1MB default max reassembled message size
+	MaxOutboundFragments       = 1024    // Default cap on frames written for one message
 
 	// Payload length indicators
 	PayloadLen16Bit = 126
 	PayloadLen64Bit = 127
+
+	// MaxFrameHeaderSize is the largest frame header: two bytes, a 64-bit extended
+	// length and a masking key
+	MaxFrameHeaderSize = 14
 )